@@ -1,10 +1,22 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
 
+	"github.com/VicSobDev/anniversaryAPI/internal/keyring"
+	"github.com/VicSobDev/anniversaryAPI/internal/pictures"
 	"github.com/VicSobDev/anniversaryAPI/internal/server"
+	"github.com/VicSobDev/anniversaryAPI/internal/token"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
+	"github.com/VicSobDev/anniversaryAPI/pkg/imaging"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 func init() {
@@ -12,27 +24,232 @@ func init() {
 }
 
 func main() {
+	bootstrap := flag.Bool("bootstrap", false, "generate a new single-use admin registration key, print it and exit")
+	backfill := flag.Bool("backfill-variants", false, "generate resized variants of existing pictures and exit")
+	recommend := flag.Duration("recommend-argon2", 0, "benchmark password hashing, print Argon2 parameters taking about this long and exit")
+	flag.Parse()
+
+	hashConfig, err := loadArgon2Config()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *recommend > 0 {
+		config, elapsed := crypto.RecommendArgon2(*recommend, hashConfig.Memory, hashConfig.Threads)
+		fmt.Printf("# hashing takes %s\nARGON2_TIME=%d\nARGON2_MEMORY=%d\nARGON2_THREADS=%d\n", elapsed.Round(time.Millisecond), config.Time, config.Memory, config.Threads)
+		return
+	}
 
 	log.Println("Starting server...")
 
-	jwtKey := os.Getenv("JWT_KEY")
-	if jwtKey == "" {
-		log.Fatal("JWT_KEY environment variable is not set")
+	keyConfig, err := loadKeyConfig()
+	if err != nil {
+		log.Fatal(err)
 	}
 
+	// Optional service credentials for the metrics scraper and admin scripts; more can be created through the API
 	prometheusKey := os.Getenv("PROMETHEUS_KEY")
-	if prometheusKey == "" {
-		log.Fatal("PROMETHEUS_KEY environment variable is not set")
+	apiKey := os.Getenv("API_KEY")
+
+	// Access rules are evaluated in this timezone; defaults to UTC
+	location, err := time.LoadLocation(os.Getenv("ACCESS_TIMEZONE"))
+	if err != nil {
+		log.Fatalf("Invalid ACCESS_TIMEZONE: %v", err)
 	}
 
-	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
-		log.Fatal("API_KEY environment variable is not set")
+	// Issuer and audience default to the API name when unset
+	tokenConfig := token.Config{
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+
+	// Audit events older than this are deleted; 0 keeps them forever
+	auditRetention := 90 * 24 * time.Hour
+	if v := os.Getenv("AUDIT_RETENTION"); v != "" {
+		if auditRetention, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid AUDIT_RETENTION: %v", err)
+		}
+	}
+
+	picturesConfig, err := loadPicturesConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Reverse proxies whose X-Forwarded-For header is trusted for the client IP; none by default
+	var trustedProxies []string
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		for _, proxy := range strings.Split(v, ",") {
+			trustedProxies = append(trustedProxies, strings.TrimSpace(proxy))
+		}
+	}
+
+	// Encrypts TOTP secrets at rest; two-factor authentication is disabled without it
+	totpKey := os.Getenv("TOTP_ENCRYPTION_KEY")
+
+	api := server.NewApi(":8080", keyConfig, hashConfig, picturesConfig, tokenConfig, prometheusKey, apiKey, totpKey, loadWebAuthnConfig(), location, auditRetention, trustedProxies)
+
+	if *bootstrap {
+		if err := api.Bootstrap(); err != nil {
+			log.Fatalf("Failed to create bootstrap key: %v", err)
+		}
+		return
 	}
 
-	api := server.NewApi(":8080", []byte(jwtKey), prometheusKey, apiKey)
+	if *backfill {
+		if err := api.BackfillVariants(); err != nil {
+			log.Fatalf("Failed to backfill picture variants: %v", err)
+		}
+		return
+	}
 
 	if err := api.Start(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// loadWebAuthnConfig reads the passkey relying party from the environment.
+// Passkeys are disabled when WEBAUTHN_RP_ID is unset.
+func loadWebAuthnConfig() *webauthn.Config {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		return nil
+	}
+
+	// Origins default to the HTTPS origin of the relying party ID
+	origins := []string{"https://" + rpID}
+	if v := os.Getenv("WEBAUTHN_RP_ORIGINS"); v != "" {
+		origins = strings.Split(v, ",")
+	}
+
+	return &webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "AnniversaryAPI",
+		RPOrigins:     origins,
+	}
+}
+
+// loadArgon2Config reads the password hashing parameters from the environment.
+// Stored hashes with weaker parameters are upgraded on the next login.
+func loadArgon2Config() (crypto.Argon2Config, error) {
+	config := crypto.DefaultArgon2Config()
+
+	if v := os.Getenv("ARGON2_TIME"); v != "" {
+		t, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || t == 0 {
+			return config, fmt.Errorf("invalid ARGON2_TIME: %q", v)
+		}
+		config.Time = uint32(t)
+	}
+
+	if v := os.Getenv("ARGON2_MEMORY"); v != "" {
+		m, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || m == 0 {
+			return config, fmt.Errorf("invalid ARGON2_MEMORY: %q", v)
+		}
+		config.Memory = uint32(m)
+	}
+
+	if v := os.Getenv("ARGON2_THREADS"); v != "" {
+		p, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || p == 0 {
+			return config, fmt.Errorf("invalid ARGON2_THREADS: %q", v)
+		}
+		config.Threads = uint8(p)
+	}
+
+	// Stored hashes outside these bounds are refused, so the service must not create any
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("invalid argon2 configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// loadPicturesConfig reads the size limits of uploaded pictures and how their metadata is
+// handled from the environment.
+func loadPicturesConfig() (pictures.Config, error) {
+	config := pictures.DefaultConfig()
+
+	if v := os.Getenv("UPLOAD_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return config, fmt.Errorf("invalid UPLOAD_MAX_BYTES: %q", v)
+		}
+		config.Limits.MaxBytes = n
+	}
+
+	if v := os.Getenv("UPLOAD_MAX_PIXELS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return config, fmt.Errorf("invalid UPLOAD_MAX_PIXELS: %q", v)
+		}
+		config.Limits.MaxPixels = n
+	}
+
+	if v := os.Getenv("UPLOAD_MAX_FILES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return config, fmt.Errorf("invalid UPLOAD_MAX_FILES: %q", v)
+		}
+		config.MaxFiles = n
+	}
+
+	if v := os.Getenv("STRIP_METADATA"); v != "" {
+		strip, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid STRIP_METADATA: %q", v)
+		}
+		config.StripMetadata = strip
+	}
+
+	if v := os.Getenv("KEEP_PRIVATE_METADATA"); v != "" {
+		keep, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid KEEP_PRIVATE_METADATA: %q", v)
+		}
+		config.KeepPrivateMetadata = keep
+	}
+
+	if v := os.Getenv("VARIANT_FORMAT"); v != "" {
+		switch v {
+		case imaging.FormatJPEG:
+		case imaging.FormatWebP:
+			if !imaging.EncodesWebP {
+				return config, fmt.Errorf("invalid VARIANT_FORMAT: %q needs a binary built with -tags webp", v)
+			}
+		default:
+			return config, fmt.Errorf("invalid VARIANT_FORMAT: %q", v)
+		}
+		config.VariantFormat = v
+	}
+
+	return config, nil
+}
+
+// loadKeyConfig reads the JWT signing configuration from the environment.
+func loadKeyConfig() (keyring.Config, error) {
+	config := keyring.Config{
+		Algorithm:        os.Getenv("JWT_ALGORITHM"),
+		RotationInterval: 30 * 24 * time.Hour,
+		GracePeriod:      24 * time.Hour,
+	}
+
+	if v := os.Getenv("JWT_ROTATION_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid JWT_ROTATION_INTERVAL: %w", err)
+		}
+		config.RotationInterval = interval
+	}
+
+	if v := os.Getenv("JWT_KEY_GRACE_PERIOD"); v != "" {
+		grace, err := time.ParseDuration(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid JWT_KEY_GRACE_PERIOD: %w", err)
+		}
+		config.GracePeriod = grace
+	}
+
+	return config, nil
+}
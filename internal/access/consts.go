@@ -0,0 +1,21 @@
+package access
+
+import "errors"
+
+// Supported rule kinds.
+const (
+	kindFixedDate  = "fixed_date"   // every year on Month/Day
+	kindDayOfMonth = "day_of_month" // every month on Day
+	kindDateRange  = "date_range"   // Month/Day through EndMonth/EndDay, yearly unless Year/EndYear are set
+	kindNthWeekday = "nth_weekday"  // Nth Weekday of Month (every month when Month is 0, last when Nth is -1)
+	kindOneOff     = "one_off"      // a single Year/Month/Day
+)
+
+// searchHorizonDays bounds how far ahead the engine looks for window boundaries;
+// eight years covers a Feb 29 rule across a skipped leap year.
+const searchHorizonDays = 8 * 366
+
+var (
+	errInvalidRule  = errors.New("invalid rule")
+	errRuleNotFound = errors.New("rule not found")
+)
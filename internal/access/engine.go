@@ -0,0 +1,166 @@
+package access
+
+import (
+	"sync"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+)
+
+// Engine evaluates access rules against a clock in a configured timezone.
+type Engine struct {
+	mx       sync.RWMutex
+	rules    []db.AccessRule
+	location *time.Location
+	clock    clock.Clock
+	db       *db.SQLiteDB
+}
+
+// NewEngine creates an Engine evaluating rules stored in sqliteDB in the given location.
+func NewEngine(sqliteDB *db.SQLiteDB, location *time.Location, c clock.Clock) *Engine {
+	if location == nil {
+		location = time.UTC
+	}
+	if c == nil {
+		c = clock.System{}
+	}
+	return &Engine{db: sqliteDB, location: location, clock: c}
+}
+
+// Reload replaces the in-memory rules with the ones stored in the database.
+func (e *Engine) Reload() error {
+	rules, err := e.db.GetAccessRules()
+	if err != nil {
+		return err
+	}
+	e.SetRules(rules)
+	return nil
+}
+
+// SetRules replaces the in-memory rules.
+func (e *Engine) SetRules(rules []db.AccessRule) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	e.rules = rules
+}
+
+// IsOpen reports whether content is unlocked right now and, if so, which rule unlocked it.
+func (e *Engine) IsOpen() (bool, *db.AccessRule) {
+	return e.OpenAt(e.clock.Now())
+}
+
+// OpenAt reports whether content is unlocked at t and, if so, which rule unlocked it.
+func (e *Engine) OpenAt(t time.Time) (bool, *db.AccessRule) {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
+
+	local := t.In(e.location)
+	for i := range e.rules {
+		if e.rules[i].Enabled && ruleMatches(e.rules[i], local) {
+			rule := e.rules[i]
+			return true, &rule
+		}
+	}
+	return false, nil
+}
+
+// Status reports the current unlock state along with the close and next unlock times.
+func (e *Engine) Status() Status {
+	return e.StatusAt(e.clock.Now())
+}
+
+// StatusAt reports the unlock state at t. When content is unlocked, ClosesAt is the
+// end of the current window and the next unlock is the first window after it.
+func (e *Engine) StatusAt(t time.Time) Status {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
+
+	status := Status{Now: t}
+	local := t.In(e.location)
+	year, month, day := local.Date()
+
+	i := 0
+	if rule := e.matchDay(year, month, day); rule != nil {
+		status.Unlocked = true
+		status.Rule = rule.Name
+
+		// Walk forward until the first day no rule matches; that is when the window closes
+		for i = 1; i <= searchHorizonDays; i++ {
+			if e.matchDay(year, month, day+i) == nil {
+				closesAt := time.Date(year, month, day+i, 0, 0, 0, 0, e.location)
+				status.ClosesAt = &closesAt
+				break
+			}
+		}
+	}
+
+	// Look for the first matching day after today (or after the current window)
+	for i++; i <= searchHorizonDays; i++ {
+		if rule := e.matchDay(year, month, day+i); rule != nil {
+			nextUnlock := time.Date(year, month, day+i, 0, 0, 0, 0, e.location)
+			status.NextUnlockAt = &nextUnlock
+			status.NextUnlockName = rule.Name
+			break
+		}
+	}
+
+	return status
+}
+
+// matchDay returns the first enabled rule matching the given calendar day, or nil.
+// Out-of-range days are normalized, so day may run past the end of the month.
+func (e *Engine) matchDay(year int, month time.Month, day int) *db.AccessRule {
+	date := time.Date(year, month, day, 12, 0, 0, 0, e.location)
+	for i := range e.rules {
+		if e.rules[i].Enabled && ruleMatches(e.rules[i], date) {
+			return &e.rules[i]
+		}
+	}
+	return nil
+}
+
+// ruleMatches reports whether the calendar day of t falls within the rule.
+func ruleMatches(rule db.AccessRule, t time.Time) bool {
+	year, month, day := t.Date()
+
+	switch rule.Kind {
+	case kindFixedDate:
+		return int(month) == rule.Month && day == rule.Day
+	case kindDayOfMonth:
+		return day == rule.Day
+	case kindOneOff:
+		return year == rule.Year && int(month) == rule.Month && day == rule.Day
+	case kindNthWeekday:
+		if rule.Month != 0 && int(month) != rule.Month {
+			return false
+		}
+		if int(t.Weekday()) != rule.Weekday {
+			return false
+		}
+		if rule.Nth == -1 {
+			return day+7 > daysIn(year, month)
+		}
+		return (day-1)/7+1 == rule.Nth
+	case kindDateRange:
+		current := int(month)*100 + day
+		start := rule.Month*100 + rule.Day
+		end := rule.EndMonth*100 + rule.EndDay
+		if rule.Year != 0 {
+			current += year * 10000
+			return current >= rule.Year*10000+start && current <= rule.EndYear*10000+end
+		}
+		if start <= end {
+			return current >= start && current <= end
+		}
+		// The range wraps around the end of the year
+		return current >= start || current <= end
+	}
+
+	return false
+}
+
+// daysIn returns the number of days in the given month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
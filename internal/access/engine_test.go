@@ -0,0 +1,299 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+)
+
+var (
+	anniversary = db.AccessRule{Name: "anniversary", Kind: kindDayOfMonth, Day: 13, Enabled: true}
+	valentine   = db.AccessRule{Name: "valentine", Kind: kindFixedDate, Month: 2, Day: 14, Enabled: true}
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone %s unavailable: %v", name, err)
+	}
+	return location
+}
+
+func TestStatusAt(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	midnight := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		rules []db.AccessRule
+		at    time.Time
+		// zero closes or next mean the status must not have them
+		unlocked bool
+		rule     string
+		closes   time.Time
+		next     time.Time
+		nextName string
+	}{
+		{
+			name:     "fixed date unlocked",
+			rules:    []db.AccessRule{valentine},
+			at:       utc(2024, 2, 14, 10, 0),
+			unlocked: true,
+			rule:     "valentine",
+			closes:   midnight(2024, 2, 15),
+			next:     midnight(2025, 2, 14),
+			nextName: "valentine",
+		},
+		{
+			name:     "fixed date locked",
+			rules:    []db.AccessRule{valentine},
+			at:       utc(2024, 2, 15, 0, 0),
+			next:     midnight(2025, 2, 14),
+			nextName: "valentine",
+		},
+		{
+			name:     "fixed date on a leap day waits for the next leap year",
+			rules:    []db.AccessRule{{Name: "leap", Kind: kindFixedDate, Month: 2, Day: 29, Enabled: true}},
+			at:       utc(2024, 3, 1, 0, 0),
+			next:     midnight(2028, 2, 29),
+			nextName: "leap",
+		},
+		{
+			name:     "day of month",
+			rules:    []db.AccessRule{anniversary},
+			at:       utc(2024, 2, 12, 23, 59),
+			next:     midnight(2024, 2, 13),
+			nextName: "anniversary",
+		},
+		{
+			name:     "day of month skips shorter months",
+			rules:    []db.AccessRule{{Name: "last", Kind: kindDayOfMonth, Day: 31, Enabled: true}},
+			at:       utc(2024, 2, 1, 0, 0),
+			next:     midnight(2024, 3, 31),
+			nextName: "last",
+		},
+		{
+			name:     "one off ahead",
+			rules:    []db.AccessRule{{Name: "trip", Kind: kindOneOff, Year: 2024, Month: 3, Day: 1, Enabled: true}},
+			at:       utc(2024, 2, 20, 0, 0),
+			next:     midnight(2024, 3, 1),
+			nextName: "trip",
+		},
+		{
+			name:     "one off today has no next unlock",
+			rules:    []db.AccessRule{{Name: "trip", Kind: kindOneOff, Year: 2024, Month: 3, Day: 1, Enabled: true}},
+			at:       utc(2024, 3, 1, 8, 0),
+			unlocked: true,
+			rule:     "trip",
+			closes:   midnight(2024, 3, 2),
+		},
+		{
+			name:  "one off passed",
+			rules: []db.AccessRule{{Name: "trip", Kind: kindOneOff, Year: 2024, Month: 3, Day: 1, Enabled: true}},
+			at:    utc(2024, 3, 2, 0, 0),
+		},
+		{
+			name:     "nth weekday of a month",
+			rules:    []db.AccessRule{{Name: "mothers_day", Kind: kindNthWeekday, Month: 5, Weekday: 0, Nth: 2, Enabled: true}},
+			at:       utc(2024, 1, 1, 0, 0),
+			next:     midnight(2024, 5, 12),
+			nextName: "mothers_day",
+		},
+		{
+			name:     "last weekday of every month",
+			rules:    []db.AccessRule{{Name: "last_friday", Kind: kindNthWeekday, Weekday: 5, Nth: -1, Enabled: true}},
+			at:       utc(2024, 2, 1, 0, 0),
+			next:     midnight(2024, 2, 23),
+			nextName: "last_friday",
+		},
+		{
+			name:     "yearly range across new year",
+			rules:    []db.AccessRule{{Name: "holidays", Kind: kindDateRange, Month: 12, Day: 24, EndMonth: 1, EndDay: 2, Enabled: true}},
+			at:       utc(2024, 12, 31, 12, 0),
+			unlocked: true,
+			rule:     "holidays",
+			closes:   midnight(2025, 1, 3),
+			next:     midnight(2025, 12, 24),
+			nextName: "holidays",
+		},
+		{
+			name:     "range with years",
+			rules:    []db.AccessRule{{Name: "trip", Kind: kindDateRange, Year: 2024, Month: 6, Day: 1, EndYear: 2024, EndMonth: 6, EndDay: 3, Enabled: true}},
+			at:       utc(2024, 6, 2, 12, 0),
+			unlocked: true,
+			rule:     "trip",
+			closes:   midnight(2024, 6, 4),
+		},
+		{
+			name:     "adjacent windows close together",
+			rules:    []db.AccessRule{anniversary, valentine},
+			at:       utc(2024, 2, 13, 9, 0),
+			unlocked: true,
+			rule:     "anniversary",
+			closes:   midnight(2024, 2, 15),
+			next:     midnight(2024, 3, 13),
+			nextName: "anniversary",
+		},
+		{
+			name:     "next unlock picks the earliest rule",
+			rules:    []db.AccessRule{valentine, anniversary},
+			at:       utc(2024, 2, 15, 9, 0),
+			next:     midnight(2024, 3, 13),
+			nextName: "anniversary",
+		},
+		{
+			name:     "disabled rules are ignored",
+			rules:    []db.AccessRule{{Name: "off", Kind: kindDayOfMonth, Day: 14, Enabled: false}, anniversary},
+			at:       utc(2024, 2, 14, 9, 0),
+			next:     midnight(2024, 3, 13),
+			nextName: "anniversary",
+		},
+		{
+			name: "no rules",
+			at:   utc(2024, 2, 14, 9, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(nil, time.UTC, clock.Fixed(tt.at))
+			engine.SetRules(tt.rules)
+
+			assertStatus(t, engine.Status(), tt.unlocked, tt.rule, tt.closes, tt.next, tt.nextName)
+		})
+	}
+}
+
+func TestStatusAtTimezones(t *testing.T) {
+	madrid := mustLoadLocation(t, "Europe/Madrid")
+	auckland := mustLoadLocation(t, "Pacific/Auckland")
+
+	tests := []struct {
+		name     string
+		location *time.Location
+		rules    []db.AccessRule
+		at       time.Time
+		unlocked bool
+		rule     string
+		closes   time.Time
+		next     time.Time
+		nextName string
+	}{
+		{
+			name:     "already the next day east of UTC",
+			location: madrid,
+			rules:    []db.AccessRule{anniversary},
+			at:       time.Date(2024, 2, 12, 23, 30, 0, 0, time.UTC),
+			unlocked: true,
+			rule:     "anniversary",
+			closes:   time.Date(2024, 2, 14, 0, 0, 0, 0, madrid),
+			next:     time.Date(2024, 3, 13, 0, 0, 0, 0, madrid),
+			nextName: "anniversary",
+		},
+		{
+			name:     "same instant still the previous day in UTC",
+			location: time.UTC,
+			rules:    []db.AccessRule{anniversary},
+			at:       time.Date(2024, 2, 12, 23, 30, 0, 0, time.UTC),
+			next:     time.Date(2024, 2, 13, 0, 0, 0, 0, time.UTC),
+			nextName: "anniversary",
+		},
+		{
+			name:     "window closes before midnight UTC",
+			location: madrid,
+			rules:    []db.AccessRule{valentine},
+			at:       time.Date(2024, 2, 14, 22, 59, 0, 0, time.UTC),
+			unlocked: true,
+			rule:     "valentine",
+			closes:   time.Date(2024, 2, 14, 23, 0, 0, 0, time.UTC),
+			next:     time.Date(2025, 2, 14, 0, 0, 0, 0, madrid),
+			nextName: "valentine",
+		},
+		{
+			name:     "window closed at local midnight",
+			location: madrid,
+			rules:    []db.AccessRule{valentine},
+			at:       time.Date(2024, 2, 14, 23, 0, 0, 0, time.UTC),
+			next:     time.Date(2025, 2, 14, 0, 0, 0, 0, madrid),
+			nextName: "valentine",
+		},
+		{
+			name:     "far east of UTC",
+			location: auckland,
+			rules:    []db.AccessRule{valentine},
+			at:       time.Date(2024, 2, 13, 11, 0, 0, 0, time.UTC),
+			unlocked: true,
+			rule:     "valentine",
+			closes:   time.Date(2024, 2, 15, 0, 0, 0, 0, auckland),
+			next:     time.Date(2025, 2, 14, 0, 0, 0, 0, auckland),
+			nextName: "valentine",
+		},
+		{
+			// Clocks move forward on the morning of the 31st, so the window is 23 hours long
+			name:     "window spanning a daylight saving change",
+			location: madrid,
+			rules:    []db.AccessRule{{Name: "last", Kind: kindDayOfMonth, Day: 31, Enabled: true}},
+			at:       time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+			unlocked: true,
+			rule:     "last",
+			closes:   time.Date(2024, 3, 31, 22, 0, 0, 0, time.UTC),
+			next:     time.Date(2024, 5, 31, 0, 0, 0, 0, madrid),
+			nextName: "last",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(nil, tt.location, clock.Fixed(tt.at))
+			engine.SetRules(tt.rules)
+
+			assertStatus(t, engine.Status(), tt.unlocked, tt.rule, tt.closes, tt.next, tt.nextName)
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	engine := NewEngine(nil, time.UTC, clock.Fixed(time.Date(2024, 2, 12, 23, 59, 30, 0, time.UTC)))
+	engine.SetRules([]db.AccessRule{anniversary})
+
+	if got := engine.Status().RetryAfter(); got != 30 {
+		t.Fatalf("RetryAfter = %d, want 30", got)
+	}
+	if got := (Status{}).RetryAfter(); got != 0 {
+		t.Fatalf("RetryAfter without a next unlock = %d, want 0", got)
+	}
+}
+
+func assertStatus(t *testing.T, status Status, unlocked bool, rule string, closes, next time.Time, nextName string) {
+	t.Helper()
+
+	if status.Unlocked != unlocked || status.Rule != rule {
+		t.Errorf("unlocked = %v by %q, want %v by %q", status.Unlocked, status.Rule, unlocked, rule)
+	}
+	assertTime(t, "closes_at", status.ClosesAt, closes)
+	assertTime(t, "next_unlock_at", status.NextUnlockAt, next)
+	if status.NextUnlockName != nextName {
+		t.Errorf("next_unlock_name = %q, want %q", status.NextUnlockName, nextName)
+	}
+}
+
+func assertTime(t *testing.T, field string, got *time.Time, want time.Time) {
+	t.Helper()
+
+	switch {
+	case want.IsZero() && got != nil:
+		t.Errorf("%s = %v, want none", field, *got)
+	case !want.IsZero() && got == nil:
+		t.Errorf("%s missing, want %v", field, want)
+	case got != nil && !got.Equal(want):
+		t.Errorf("%s = %v, want %v", field, *got, want)
+	}
+}
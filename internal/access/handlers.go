@@ -0,0 +1,158 @@
+package access
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// UnlockStatus reports whether gated content is currently unlocked and when the next window opens.
+func (svc *AccessService) UnlockStatus(c *gin.Context) {
+	svc.logger.Info("UnlockStatus called")
+
+	status := svc.engine.Status()
+
+	unlockStatusRequests.WithLabelValues("successful").Inc()
+	c.JSON(http.StatusOK, status)
+}
+
+// GetRules lists every configured access rule.
+func (svc *AccessService) GetRules(c *gin.Context) {
+	svc.logger.Info("GetRules called")
+
+	rules, err := svc.db.GetAccessRules()
+	if err != nil {
+		svc.ErrorHandler(ruleRequests, err, zap.String("error", "failed to get rules"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	ruleRequests.WithLabelValues("successful").Inc()
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// CreateRule validates and stores a new access rule.
+func (svc *AccessService) CreateRule(c *gin.Context) {
+	svc.logger.Info("CreateRule called")
+
+	var req RuleRequest
+	// Bind the incoming JSON request to a RuleRequest struct; handle errors
+	if err := c.ShouldBindJSON(&req); err != nil {
+		svc.ErrorHandler(ruleRequests, err, zap.String("error", "invalid request"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	rule, err := req.toRule()
+	if err != nil {
+		ruleRequests.WithLabelValues("invalid_rule").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err = svc.db.CreateAccessRule(rule)
+	if err != nil {
+		if errors.Is(err, db.ErrRuleNameTaken) {
+			ruleRequests.WithLabelValues("name_exists").Inc()
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		svc.ErrorHandler(ruleRequests, err, zap.String("error", "failed to create rule"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := svc.reload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	ruleRequests.WithLabelValues("successful").Inc()
+	svc.logger.Info("access rule created", zap.String("name", rule.Name), zap.String("kind", rule.Kind))
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateRule replaces an existing access rule.
+func (svc *AccessService) UpdateRule(c *gin.Context) {
+	svc.logger.Info("UpdateRule called")
+
+	id, err := parseRuleID(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req RuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		svc.ErrorHandler(ruleRequests, err, zap.String("error", "invalid request"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	rule, err := req.toRule()
+	if err != nil {
+		ruleRequests.WithLabelValues("invalid_rule").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule.ID = id
+	exists, err := svc.db.UpdateAccessRule(rule)
+	if err != nil {
+		if errors.Is(err, db.ErrRuleNameTaken) {
+			ruleRequests.WithLabelValues("name_exists").Inc()
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		svc.ErrorHandler(ruleRequests, err, zap.String("error", "failed to update rule"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": errRuleNotFound.Error()})
+		return
+	}
+
+	if err := svc.reload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	ruleRequests.WithLabelValues("successful").Inc()
+	svc.logger.Info("access rule updated", zap.Int("id", id))
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteRule removes an access rule.
+func (svc *AccessService) DeleteRule(c *gin.Context) {
+	svc.logger.Info("DeleteRule called")
+
+	id, err := parseRuleID(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	deleted, err := svc.db.DeleteAccessRule(id)
+	if err != nil {
+		svc.ErrorHandler(ruleRequests, err, zap.String("error", "failed to delete rule"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": errRuleNotFound.Error()})
+		return
+	}
+
+	if err := svc.reload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	ruleRequests.WithLabelValues("successful").Inc()
+	svc.logger.Info("access rule deleted", zap.Int("id", id))
+	c.JSON(http.StatusOK, gin.H{"message": "rule deleted"})
+}
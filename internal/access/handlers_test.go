@@ -0,0 +1,137 @@
+package access
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// newTestService returns an AccessService backed by a fresh database holding the seeded rules.
+func newTestService(t *testing.T) *AccessService {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	sqliteDB, err := db.NewSQLiteDB(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqliteDB.Close() })
+	if err := sqliteDB.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &AccessService{logger: zap.NewNop(), db: sqliteDB, engine: NewEngine(sqliteDB, time.UTC, nil)}
+}
+
+// serve calls a handler with a JSON body and rule id and returns the response.
+func serve(handler gin.HandlerFunc, method string, id int, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, fmt.Sprintf("/api/access/rules/%d", id), &buf)
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: fmt.Sprint(id)}}
+
+	handler(c)
+	return w
+}
+
+// ruleID returns the id of the stored rule with the given name.
+func ruleID(t *testing.T, svc *AccessService, name string) int {
+	t.Helper()
+
+	rules, err := svc.db.GetAccessRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range rules {
+		if rule.Name == name {
+			return rule.ID
+		}
+	}
+	t.Fatalf("rule %q not found", name)
+	return 0
+}
+
+func TestCreateRule(t *testing.T) {
+	svc := newTestService(t)
+
+	tests := []struct {
+		name   string
+		req    RuleRequest
+		status int
+	}{
+		{"new rule", RuleRequest{Name: "first_date", Kind: kindFixedDate, Month: 6, Day: 1}, http.StatusCreated},
+		{"duplicate name", RuleRequest{Name: "valentine", Kind: kindFixedDate, Month: 6, Day: 2}, http.StatusConflict},
+		{"duplicate name with spaces", RuleRequest{Name: " first_date ", Kind: kindDayOfMonth, Day: 1}, http.StatusConflict},
+		{"invalid rule", RuleRequest{Name: "bad", Kind: kindFixedDate, Month: 2, Day: 30}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		if w := serve(svc.CreateRule, http.MethodPost, 0, tt.req); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
+
+func TestUpdateRule(t *testing.T) {
+	svc := newTestService(t)
+	id := ruleID(t, svc, "anniversary")
+
+	tests := []struct {
+		name   string
+		id     int
+		req    RuleRequest
+		status int
+	}{
+		{"keep own name", id, RuleRequest{Name: "anniversary", Kind: kindDayOfMonth, Day: 14}, http.StatusOK},
+		{"rename", id, RuleRequest{Name: "monthiversary", Kind: kindDayOfMonth, Day: 13}, http.StatusOK},
+		{"take another rule's name", id, RuleRequest{Name: "valentine", Kind: kindDayOfMonth, Day: 13}, http.StatusConflict},
+		{"missing rule", id + 100, RuleRequest{Name: "ghost", Kind: kindDayOfMonth, Day: 13}, http.StatusNotFound},
+		{"missing rule with a taken name", id + 100, RuleRequest{Name: "valentine", Kind: kindDayOfMonth, Day: 13}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		if w := serve(svc.UpdateRule, http.MethodPut, tt.id, tt.req); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+	}
+
+	rule, err := svc.db.GetAccessRule(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Name != "monthiversary" || rule.Day != 13 {
+		t.Fatalf("rule after updates = %+v", rule)
+	}
+}
+
+func TestDeleteRule(t *testing.T) {
+	svc := newTestService(t)
+	id := ruleID(t, svc, "valentine")
+
+	if w := serve(svc.DeleteRule, http.MethodDelete, id, nil); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w := serve(svc.DeleteRule, http.MethodDelete, id, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("deleting again: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// The engine no longer unlocks on the deleted rule
+	if open, rule := svc.engine.OpenAt(time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)); open {
+		t.Fatalf("still unlocked by %q", rule.Name)
+	}
+}
@@ -0,0 +1,117 @@
+package access
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ErrorHandler increments a Prometheus counter for tracking errors and logs the error with additional fields.
+func (svc *AccessService) ErrorHandler(cv *prometheus.CounterVec, err error, fields ...zapcore.Field) {
+	cv.WithLabelValues("error").Inc()
+	svc.logger.Error(err.Error(), fields...)
+}
+
+// parseRuleID extracts the rule ID from the route parameters.
+func parseRuleID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, errRuleNotFound
+	}
+	return id, nil
+}
+
+// toRule converts a request into a validated database rule.
+func (req RuleRequest) toRule() (db.AccessRule, error) {
+	rule := db.AccessRule{
+		Name:     strings.TrimSpace(req.Name),
+		Kind:     req.Kind,
+		Year:     req.Year,
+		Month:    req.Month,
+		Day:      req.Day,
+		EndYear:  req.EndYear,
+		EndMonth: req.EndMonth,
+		EndDay:   req.EndDay,
+		Weekday:  req.Weekday,
+		Nth:      req.Nth,
+		Enabled:  true,
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+
+	return rule, validateRule(rule)
+}
+
+// validateRule checks that the fields required by the rule's kind are present and in range.
+func validateRule(rule db.AccessRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("%w: name is required", errInvalidRule)
+	}
+
+	switch rule.Kind {
+	case kindFixedDate:
+		return validateDate(2000, rule.Month, rule.Day)
+	case kindDayOfMonth:
+		if rule.Day < 1 || rule.Day > 31 {
+			return fmt.Errorf("%w: day must be between 1 and 31", errInvalidRule)
+		}
+	case kindOneOff:
+		return validateDate(rule.Year, rule.Month, rule.Day)
+	case kindNthWeekday:
+		if rule.Month < 0 || rule.Month > 12 {
+			return fmt.Errorf("%w: month must be between 0 and 12", errInvalidRule)
+		}
+		if rule.Weekday < 0 || rule.Weekday > 6 {
+			return fmt.Errorf("%w: weekday must be between 0 (Sunday) and 6 (Saturday)", errInvalidRule)
+		}
+		if rule.Nth != -1 && (rule.Nth < 1 || rule.Nth > 5) {
+			return fmt.Errorf("%w: nth must be between 1 and 5, or -1 for the last weekday", errInvalidRule)
+		}
+	case kindDateRange:
+		if (rule.Year == 0) != (rule.EndYear == 0) {
+			return fmt.Errorf("%w: year and end_year must be set together", errInvalidRule)
+		}
+		year, endYear := rule.Year, rule.EndYear
+		if year == 0 {
+			year, endYear = 2000, 2000
+		}
+		if err := validateDate(year, rule.Month, rule.Day); err != nil {
+			return err
+		}
+		if err := validateDate(endYear, rule.EndMonth, rule.EndDay); err != nil {
+			return err
+		}
+		if rule.Year != 0 && rule.Year*10000+rule.Month*100+rule.Day > rule.EndYear*10000+rule.EndMonth*100+rule.EndDay {
+			return fmt.Errorf("%w: range must not end before it starts", errInvalidRule)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", errInvalidRule, rule.Kind)
+	}
+
+	return nil
+}
+
+// validateDate checks that year, month and day form an existing calendar date.
+func validateDate(year, month, day int) error {
+	if year < 1 || month < 1 || month > 12 || day < 1 || day > daysIn(year, time.Month(month)) {
+		return fmt.Errorf("%w: %04d-%02d-%02d is not a valid date", errInvalidRule, year, month, day)
+	}
+	return nil
+}
+
+// reload refreshes the engine after a rule change, logging failures.
+func (svc *AccessService) reload() error {
+	if err := svc.engine.Reload(); err != nil {
+		svc.ErrorHandler(ruleRequests, err, zap.String("error", "failed to reload access rules"))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package access
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Define your metrics
+var (
+	ruleRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "access_rule_requests_total",
+			Help: "Total number of access rule management requests.",
+		},
+		[]string{"status"},
+	)
+	unlockStatusRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "access_unlock_status_requests_total",
+			Help: "Total number of unlock status requests.",
+		},
+		[]string{"status"},
+	)
+)
@@ -0,0 +1,54 @@
+package access
+
+import (
+	"math"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type AccessService struct {
+	logger *zap.Logger
+	db     *db.SQLiteDB
+	engine *Engine
+}
+
+type RuleRequest struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Year     int    `json:"year"`
+	Month    int    `json:"month"`
+	Day      int    `json:"day"`
+	EndYear  int    `json:"end_year"`
+	EndMonth int    `json:"end_month"`
+	EndDay   int    `json:"end_day"`
+	Weekday  int    `json:"weekday"`
+	Nth      int    `json:"nth"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+// Status describes whether gated content is unlocked and when that changes.
+type Status struct {
+	Now            time.Time  `json:"now"`
+	Unlocked       bool       `json:"unlocked"`
+	Rule           string     `json:"rule,omitempty"`
+	ClosesAt       *time.Time `json:"closes_at,omitempty"`
+	NextUnlockAt   *time.Time `json:"next_unlock_at,omitempty"`
+	NextUnlockName string     `json:"next_unlock_name,omitempty"`
+}
+
+// RetryAfter returns the number of seconds until the next unlock, or 0 if unknown.
+func (s Status) RetryAfter() int {
+	if s.NextUnlockAt == nil {
+		return 0
+	}
+	return int(math.Ceil(s.NextUnlockAt.Sub(s.Now).Seconds()))
+}
+
+func NewAccessService(logger *zap.Logger, sqliteDB *db.SQLiteDB, engine *Engine) *AccessService {
+	prometheus.MustRegister(ruleRequests)
+	prometheus.MustRegister(unlockStatusRequests)
+	return &AccessService{logger: logger, db: sqliteDB, engine: engine}
+}
@@ -0,0 +1,10 @@
+package audit
+
+import "errors"
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+var errInvalidFilter = errors.New("invalid filter")
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// GetEvents lists audit events matching the query filters, newest first, a page at a time.
+func (svc *AuditService) GetEvents(c *gin.Context) {
+	svc.logger.Info("GetEvents called")
+
+	filter, err := parseFilter(c)
+	if err != nil {
+		auditRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, offset := parsePagination(c)
+
+	events, total, err := svc.db.GetAuditEvents(filter, limit, offset)
+	if err != nil {
+		svc.ErrorHandler(auditRequests, err, zap.String("error", "failed to get audit events"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if events == nil {
+		events = []db.AuditEvent{}
+	}
+
+	auditRequests.WithLabelValues("successful").Inc()
+	c.JSON(http.StatusOK, gin.H{"events": events, "total": total, "limit": limit, "offset": offset})
+}
+
+// ExportEvents streams every audit event matching the query filters as NDJSON, oldest first.
+func (svc *AuditService) ExportEvents(c *gin.Context) {
+	svc.logger.Info("ExportEvents called")
+
+	filter, err := parseFilter(c)
+	if err != nil {
+		auditRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	err = svc.db.EachAuditEvent(filter, func(event db.AuditEvent) error {
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		// The status is already sent, so the export is cut short
+		svc.ErrorHandler(auditRequests, err, zap.String("error", "failed to export audit events"))
+		return
+	}
+
+	auditRequests.WithLabelValues("exported").Inc()
+}
@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap/zapcore"
+)
+
+// ErrorHandler increments a Prometheus counter for tracking errors and logs the error with additional fields.
+func (svc *AuditService) ErrorHandler(cv *prometheus.CounterVec, err error, fields ...zapcore.Field) {
+	cv.WithLabelValues("error").Inc()
+	svc.logger.Error(err.Error(), fields...)
+}
+
+// parseFilter reads the event filter from the query string. since and until are RFC 3339 times.
+func parseFilter(c *gin.Context) (db.AuditFilter, error) {
+	filter := db.AuditFilter{
+		Action:    c.Query("action"),
+		Outcome:   c.Query("outcome"),
+		Actor:     c.Query("actor"),
+		IP:        c.Query("ip"),
+		RequestID: c.Query("request_id"),
+	}
+
+	if v := c.Query("actor_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return filter, fmt.Errorf("%w: actor_id must be a positive integer", errInvalidFilter)
+		}
+		filter.ActorID = id
+	}
+
+	for name, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("%w: %s must be an RFC 3339 time", errInvalidFilter, name)
+			}
+			*dst = &t
+		}
+	}
+
+	return filter, nil
+}
+
+// parsePagination reads limit and offset from the query, falling back to defaults on invalid values.
+func parsePagination(c *gin.Context) (int, int) {
+	limit, offset := defaultPageSize, 0
+
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, maxPageSize)
+	}
+	if v, err := strconv.Atoi(c.Query("offset")); err == nil && v >= 0 {
+		offset = v
+	}
+
+	return limit, offset
+}
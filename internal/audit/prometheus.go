@@ -0,0 +1,13 @@
+package audit
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	auditRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "audit_requests_total",
+			Help: "Total number of audit log queries and exports.",
+		},
+		[]string{"status"},
+	)
+)
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Recorded actions
+const (
+	ActionLogin              = "auth.login"
+	ActionRegister           = "auth.register"
+	ActionRefresh            = "auth.refresh"
+	ActionPasswordChange     = "auth.password_change"
+	ActionPasswordReset      = "auth.password_reset"
+	ActionKeyCreate          = "keys.create"
+	ActionKeyDelete          = "keys.delete"
+	ActionAccessTokenCreate  = "access_tokens.create"
+	ActionAccessTokenDelete  = "access_tokens.delete"
+	ActionCredentialCreate   = "service_credentials.create"
+	ActionCredentialDelete   = "service_credentials.delete"
+	ActionPictureUpload      = "pictures.upload"
+	ActionPictureDelete      = "pictures.delete"
+	ActionUserDisable        = "users.disable"
+	ActionUserEnable         = "users.enable"
+	ActionUserRole           = "users.role"
+	ActionUserPasswordReset  = "users.password_reset"
+	ActionUserDelete         = "users.delete"
+	ActionUserImport         = "users.import"
+	ActionLockoutUnlock      = "lockouts.unlock"
+	ActionSigningKeyRotation = "signing_keys.rotate"
+)
+
+// Outcomes of a recorded action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event describes an action to record. Actor, IP, user agent and request ID are taken
+// from the request unless set.
+type Event struct {
+	Action  string
+	Outcome string
+	// ActorID and Actor identify who acted; they default to the authenticated user or service credential
+	ActorID int
+	Actor   string
+	// Target identifies what was acted on, e.g. "user:12"
+	Target  string
+	Details map[string]any
+}
+
+// Recorder writes audit events to SQLite and removes them once they are older than the retention period.
+type Recorder struct {
+	db        *db.SQLiteDB
+	clock     clock.Clock
+	logger    *zap.Logger
+	retention time.Duration
+}
+
+// NewRecorder creates a Recorder backed by sqliteDB. A zero retention keeps events forever.
+func NewRecorder(sqliteDB *db.SQLiteDB, clock clock.Clock, logger *zap.Logger, retention time.Duration) *Recorder {
+	return &Recorder{db: sqliteDB, clock: clock, logger: logger, retention: retention}
+}
+
+// Record stores an event for the request. A failure to record is logged rather than
+// failing the request.
+func (r *Recorder) Record(c *gin.Context, event Event) {
+	stored := db.AuditEvent{
+		CreatedAt: r.clock.Now(),
+		Action:    event.Action,
+		Outcome:   event.Outcome,
+		Actor:     event.Actor,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: c.GetString("request_id"),
+		Target:    event.Target,
+	}
+
+	actorID := event.ActorID
+	if actorID == 0 {
+		actorID = c.GetInt("user_id")
+	}
+	if actorID != 0 {
+		stored.ActorID = &actorID
+	}
+
+	if stored.Actor == "" {
+		stored.Actor = c.GetString("username")
+	}
+	if stored.Actor == "" && c.GetString("service_credential") != "" {
+		stored.Actor = "service:" + c.GetString("service_credential")
+	}
+
+	if len(event.Details) > 0 {
+		details, err := json.Marshal(event.Details)
+		if err != nil {
+			r.logger.Error("failed to encode audit event details", zap.Error(err), zap.String("action", event.Action))
+		}
+		stored.Details = details
+	}
+
+	if err := r.db.CreateAuditEvent(stored); err != nil {
+		r.logger.Error("failed to record audit event", zap.Error(err), zap.String("action", event.Action), zap.String("outcome", event.Outcome))
+	}
+}
+
+// Prune deletes events older than the retention period.
+func (r *Recorder) Prune(now time.Time) error {
+	if r.retention <= 0 {
+		return nil
+	}
+	return r.db.DeleteAuditEventsBefore(now.Add(-r.retention))
+}
+
+// StartPruning prunes expired events every interval in a background goroutine.
+func (r *Recorder) StartPruning(interval time.Duration, logger *zap.Logger) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if err := r.Prune(now); err != nil {
+				logger.Error("failed to prune audit events", zap.Error(err))
+			}
+		}
+	}()
+}
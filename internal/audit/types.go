@@ -0,0 +1,17 @@
+package audit
+
+import (
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type AuditService struct {
+	logger *zap.Logger
+	db     *db.SQLiteDB
+}
+
+func NewAuditService(logger *zap.Logger, sqliteDB *db.SQLiteDB) *AuditService {
+	prometheus.MustRegister(auditRequests)
+	return &AuditService{logger: logger, db: sqliteDB}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
+	"github.com/VicSobDev/anniversaryAPI/internal/keyring"
+	"github.com/VicSobDev/anniversaryAPI/internal/lockout"
+	"github.com/VicSobDev/anniversaryAPI/internal/revocation"
+	"github.com/VicSobDev/anniversaryAPI/internal/token"
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.uber.org/zap"
+)
+
+// testNow is the instant the fixed clock of test services reports
+var testNow = time.Date(2024, 2, 13, 12, 0, 0, 0, time.UTC)
+
+// newTestService returns an AuthService backed by a fresh database in a temporary directory,
+// with passkeys enabled for https://localhost and time fixed at testNow.
+func newTestService(t *testing.T) *AuthService {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	sqliteDB, err := db.NewSQLiteDB(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqliteDB.Close() })
+	if err := sqliteDB.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := keyring.New(sqliteDB, keyring.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          "localhost",
+		RPDisplayName: "AnniversaryAPI",
+		RPOrigins:     []string{"https://localhost"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipher, err := crypto.NewAESGCM("test encryption key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zap.NewNop()
+	fixed := clock.Fixed(testNow)
+	return &AuthService{
+		logger:      *logger,
+		db:          sqliteDB,
+		hasher:      crypto.NewPasswordHasher(crypto.NewArgon2(crypto.Argon2Config{Time: 1, Memory: 64, Threads: 1, KeyLen: 32})),
+		revocations: revocation.NewStore(sqliteDB),
+		guard:       lockout.NewGuard(sqliteDB, fixed, lockout.DefaultPolicies),
+		audit:       audit.NewRecorder(sqliteDB, fixed, logger, 0),
+		keyring:     keys,
+		tokens:      token.NewManager(keys, token.Config{}, fixed),
+		cipher:      cipher,
+		webauthn:    webAuthn,
+		clock:       fixed,
+	}
+}
+
+// createTestUser stores a user with the given password.
+func createTestUser(t *testing.T, svc *AuthService, username, password string) *db.User {
+	t.Helper()
+
+	hash, err := svc.hasher.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := svc.db.CreateUser(username, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// serve calls a handler with a JSON body and the authenticated user, if any, and returns the response.
+func serve(handler gin.HandlerFunc, method, target string, body any, userID int) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, &buf)
+	c.Request.Header.Set("Content-Type", "application/json")
+	if userID != 0 {
+		c.Set("user_id", userID)
+	}
+
+	handler(c)
+	return w
+}
+
+// decode unmarshals a JSON response, failing the test on an unexpected status.
+func decode(t *testing.T, w *httptest.ResponseRecorder, status int, v any) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -1,8 +1,56 @@
 package auth
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
+
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+// minPasswordLength applies to passwords set through change and reset
+const minPasswordLength = 8
+
+const (
+	// AccessTokenPrefix marks personal access tokens so they can be told apart from JWTs
+	AccessTokenPrefix = "pat_"
+	// accessTokenTTL is the lifetime of a personal access token created without expires_at
+	accessTokenTTL = 90 * 24 * time.Hour
+)
+
+const (
+	// totpIssuer is the account issuer shown in authenticator apps
+	totpIssuer = "AnniversaryAPI"
+	// recoveryCodeCount is the number of single-use recovery codes issued when 2FA is enabled
+	recoveryCodeCount = 10
+	// qrCodeSize is the width and height of the enrollment QR code in pixels
+	qrCodeSize = 256
+)
+
+const (
+	// passkeySessionTTL bounds how long a WebAuthn ceremony may take between begin and finish
+	passkeySessionTTL = 5 * time.Minute
+	// passkeyRegistration and passkeyLogin tag stored ceremony sessions so one cannot finish the other
+	passkeyRegistration = "registration"
+	passkeyLogin        = "login"
+)
 
 var (
-	errInvalidPassword = errors.New("invalid password")
-	errUserNotFound    = errors.New("user not found")
+	errInvalidPassword     = errors.New("invalid password")
+	errUserNotFound        = errors.New("user not found")
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+	errInvalidMFACode      = errors.New("invalid two-factor code")
+	errTwoFactorDisabled   = errors.New("two-factor authentication is not configured")
+	errPasskeysDisabled    = errors.New("passkeys are not configured")
+	errInvalidSession      = errors.New("invalid or expired passkey session")
+	errPasswordTooShort    = errors.New("password must be at least 8 characters")
+	errInvalidMaxUses      = errors.New("max_uses must be positive")
+	errInvalidRole         = errors.New("role must be member or admin")
+	errExpiryPast          = errors.New("expires_at must be in the future")
+	errTokenNameRequired   = errors.New("name is required")
+	errInvalidScopes       = errors.New("scopes must be one or more permissions granted to your role")
+	errReservedName        = errors.New("names starting with env: are reserved for keys set by environment variables")
+	errUserDisabled        = errors.New("account disabled")
+	errPasswordReset       = errors.New("password reset required")
+	errAdminKeyScoped      = errors.New("admin registration keys cannot be created with a personal access token")
 )
@@ -1,11 +1,27 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
+	"github.com/VicSobDev/anniversaryAPI/internal/lockout"
+	"github.com/VicSobDev/anniversaryAPI/internal/token"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/VicSobDev/anniversaryAPI/pkg/totp"
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/skip2/go-qrcode"
 	"go.uber.org/zap"
 )
 
@@ -23,14 +39,28 @@ func (svc *AuthService) Login(c *gin.Context) {
 		return
 	}
 
+	// Refuse attempts while the username or client IP is backing off or locked out
+	if svc.throttled(c, req.Username) {
+		svc.recordLogin(c, 0, req.Username, "password", audit.OutcomeFailure, "throttled")
+		return
+	}
+
 	// Attempt to login with the provided credentials
 	user, err := svc.attemptLogin(req)
 	if err != nil {
 		if err == errUserNotFound || err == errInvalidPassword {
 			loginAttempts.WithLabelValues("invalid_credentials").Inc()
+			svc.recordLoginFailure(req.Username, c.ClientIP())
+			svc.recordLogin(c, 0, req.Username, "password", audit.OutcomeFailure, "invalid_credentials")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"})
 			return
 		}
+		if err == errUserDisabled || err == errPasswordReset {
+			loginAttempts.WithLabelValues("rejected").Inc()
+			svc.recordLogin(c, 0, req.Username, "password", audit.OutcomeFailure, err.Error())
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 
 		// Log the error and respond with an unauthorized status if login fails
 		svc.ErrorHandler(loginAttempts, err, zap.String("error", "could not login"))
@@ -45,8 +75,24 @@ func (svc *AuthService) Login(c *gin.Context) {
 		return
 	}
 
-	// Generate and send a token for the logged-in user
-	token, err := svc.generateAndSendToken(user, c)
+	// Users with two-factor authentication get a challenge token instead of a session
+	if user.TOTPEnabled {
+		challenge, _, err := svc.tokens.IssueChallenge(user.ID, user.Username)
+		if err != nil {
+			svc.ErrorHandler(loginAttempts, err, zap.String("error", "failed to generate challenge token"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+
+		loginAttempts.WithLabelValues("mfa_required").Inc()
+		c.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": challenge, "expires_in": int(token.ChallengeTTL.Seconds())})
+		return
+	}
+
+	svc.clearLoginFailures(user.Username)
+
+	// Generate and send a token pair for the logged-in user
+	tokens, err := svc.generateAndSendToken(user, "")
 	if err != nil {
 		// Log the error and respond with an internal server error status if token generation fails
 		svc.ErrorHandler(loginAttempts, err, zap.String("error", "failed to generate token"))
@@ -55,206 +101,1237 @@ func (svc *AuthService) Login(c *gin.Context) {
 	}
 
 	loginAttempts.WithLabelValues("successful").Inc()
-	// Respond with the generated token upon successful login
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	svc.recordLogin(c, user.ID, user.Username, "password", audit.OutcomeSuccess, "")
+	// Respond with the generated tokens upon successful login
+	c.JSON(http.StatusOK, tokens)
 }
 
-// Register handles user registration requests.
-func (svc *AuthService) Register(c *gin.Context) {
-	// Log the invocation of the Register function
-	svc.logger.Info("Register called")
+// LoginMFA completes a two-step login by exchanging a challenge token and a second factor for a session.
+func (svc *AuthService) LoginMFA(c *gin.Context) {
+	svc.logger.Info("LoginMFA called")
 
-	var req RegisterRequest
-	// Bind the incoming JSON request to a RegisterRequest struct; handle errors
-	if err := c.ShouldBindJSON(&req); err != nil {
-		// Log the error and respond with a bad request status if request binding fails
-		svc.ErrorHandler(registerAttempts, err, zap.String("error", "invalid request"))
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		twoFactorRequests.WithLabelValues("invalid_request").Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
 
-	// Check if the provided registration key exists in the database
-	if exists, err := svc.db.ContainsKey(req.Key); err != nil {
-		// Log the error and respond with an internal server error status if a database error occurs
-		svc.ErrorHandler(registerAttempts, err, zap.String("error", "internal server error"))
+	// Validate the challenge token issued by the password step
+	claims, err := svc.tokens.ParseChallenge(req.MFAToken)
+	if err != nil || svc.revocations.IsRevoked(claims.ID) {
+		twoFactorRequests.WithLabelValues("invalid_token").Inc()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := svc.db.GetUserByID(claims.UserID)
+	if err != nil || !user.TOTPEnabled || user.Disabled {
+		twoFactorRequests.WithLabelValues("invalid_token").Inc()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Guessing codes counts towards the same lockout as guessing passwords
+	if svc.throttled(c, user.Username) {
+		svc.recordLogin(c, user.ID, user.Username, "two_factor", audit.OutcomeFailure, "throttled")
+		return
+	}
+
+	if err := svc.verifySecondFactor(user, req.Code); err != nil {
+		if err == errInvalidMFACode {
+			twoFactorRequests.WithLabelValues("invalid_code").Inc()
+			svc.recordLoginFailure(user.Username, c.ClientIP())
+			svc.recordLogin(c, user.ID, user.Username, "two_factor", audit.OutcomeFailure, "invalid_code")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to verify second factor"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	svc.clearLoginFailures(user.Username)
+
+	// The challenge is single-use
+	if err := svc.revocations.Revoke(claims.ID, claims.Expiry()); err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to revoke challenge token"))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
-	} else if !exists {
-		// Respond with an unauthorized status if the registration key is invalid
-		registerAttempts.WithLabelValues("invalid_key").Inc()
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid key"})
+	}
+
+	tokens, err := svc.generateAndSendToken(user, "")
+	if err != nil {
+		svc.ErrorHandler(loginAttempts, err, zap.String("error", "failed to generate token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	username := strings.ToLower(req.Username)
+	twoFactorRequests.WithLabelValues("successful").Inc()
+	loginAttempts.WithLabelValues("successful").Inc()
+	svc.recordLogin(c, user.ID, user.Username, "two_factor", audit.OutcomeSuccess, "")
+	c.JSON(http.StatusOK, tokens)
+}
+
+// SetupTwoFactor starts TOTP enrollment, returning the secret as an otpauth URI and QR code.
+// Two-factor authentication is not enabled until a code is confirmed through VerifyTwoFactor.
+func (svc *AuthService) SetupTwoFactor(c *gin.Context) {
+	svc.logger.Info("SetupTwoFactor called")
 
-	// Check if the username is already taken
-	if _, err := svc.db.GetUser(username); err == nil {
-		// Respond with a conflict status if the username already exists
-		registerAttempts.WithLabelValues("username_exists").Inc()
-		c.JSON(http.StatusConflict, gin.H{"error": "username already exists"})
+	if svc.cipher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errTwoFactorDisabled.Error()})
 		return
 	}
 
-	// Hash the provided password
-	hash, err := svc.argon.Hash(req.Password)
+	user, err := svc.db.GetUserByID(c.GetInt("user_id"))
 	if err != nil {
-		// Log the error and respond with an internal server error if password hashing fails
-		svc.ErrorHandler(registerAttempts, err, zap.String("error", "failed to hash password"))
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to generate secret"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Only the encrypted secret is stored
+	encrypted, err := svc.cipher.Encrypt(secret)
+	if err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to encrypt secret"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := svc.db.SetPendingTOTPSecret(user.ID, encrypted); err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to store secret"))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	// Create a new user with the hashed password
-	user, err := svc.db.CreateUser(username, hash)
+	uri := totp.URI(totpIssuer, user.Username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrCodeSize)
 	if err != nil {
-		// Log the error and respond with an internal server error if user creation fails
-		svc.ErrorHandler(registerAttempts, err, zap.String("error", "failed to create user"))
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to generate QR code"))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	// Delete the registration key used for signing up
-	if err := svc.db.DeleteKey(req.Key); err != nil {
-		// Log the error and respond with an internal server error if key deletion fails
-		svc.ErrorHandler(registerAttempts, err, zap.String("error", "failed to delete key"))
+	twoFactorRequests.WithLabelValues("setup").Inc()
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// VerifyTwoFactor confirms TOTP enrollment with a code and returns the recovery codes, shown only once.
+func (svc *AuthService) VerifyTwoFactor(c *gin.Context) {
+	svc.logger.Info("VerifyTwoFactor called")
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		twoFactorRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	user, err := svc.db.GetUserByID(c.GetInt("user_id"))
+	if err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to get user"))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	// Initialize a new JWT token
-	token := jwt.New(jwt.SigningMethodHS256)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+		return
+	}
 
-	// Set claims for the JWT token (e.g., username and user ID)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["username"] = user.Username
-	claims["user_id"] = user.ID
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor setup has not been started"})
+		return
+	}
 
-	// Generate a signed JWT token string
-	tokenString, err := token.SignedString(svc.jwtKey)
+	secret, err := svc.decryptTOTPSecret(user)
 	if err != nil {
-		// Log the error and respond with an internal server error if token generation fails
-		svc.ErrorHandler(registerAttempts, err, zap.String("error", "failed to generate token"))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to decrypt secret"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	// Increment the register attempts metric for successful registration
-	registerAttempts.WithLabelValues("successful").Inc()
-	// Log the successful registration
-	svc.logger.Info("user registered", zap.String("username", user.Username), zap.Int("user_id", user.ID))
-	// Respond with the generated token upon successful registration
-	c.JSON(http.StatusOK, gin.H{"token": tokenString})
+	// Enrollment must be confirmed with a TOTP code; recovery codes do not exist yet
+	step, ok, err := totp.Validate(secret, req.Code, svc.clock.Now())
+	if err == nil && ok {
+		ok, err = svc.db.UseTOTPStep(user.ID, step)
+	}
+	if err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to validate code"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	if !ok {
+		twoFactorRequests.WithLabelValues("invalid_code").Inc()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to generate recovery codes"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := svc.db.EnableTOTP(user.ID, hashes); err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to enable two-factor authentication"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	twoFactorRequests.WithLabelValues("enabled").Inc()
+	svc.logger.Info("two-factor authentication enabled", zap.Int("user_id", user.ID))
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
 }
 
-// Refresh handles token refresh requests, generating a new token for authenticated users.
-func (svc *AuthService) Refresh(c *gin.Context) {
-	// Log the invocation of the Refresh function
-	svc.logger.Info("Refresh called")
+// DisableTwoFactor turns off two-factor authentication after checking the password and a second factor.
+func (svc *AuthService) DisableTwoFactor(c *gin.Context) {
+	svc.logger.Info("DisableTwoFactor called")
 
-	// Retrieve the Authorization header from the request
-	authHeader := c.GetHeader("Authorization")
-	// Check if the Authorization header is missing
-	if authHeader == "" {
-		// Log the error and respond with an unauthorized status if the header is missing
-		svc.ErrorHandler(refreshAttempts, nil, zap.String("error", "Authorization header is missing"))
-		c.JSON(401, gin.H{"error": "Authorization header is missing"})
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		twoFactorRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
 
-	const prefix = "Bearer "
-	// Verify the Authorization header format starts with "Bearer "
-	if !strings.HasPrefix(authHeader, prefix) {
-		// Log the error and respond with an unauthorized status if the header format is incorrect
-		svc.ErrorHandler(refreshAttempts, nil, zap.String("error", "Authorization header must start with Bearer"))
-		c.JSON(401, gin.H{"error": "Authorization header must start with Bearer"})
+	user, err := svc.db.GetUserByID(c.GetInt("user_id"))
+	if err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	// Extract the JWT token from the Authorization header
-	jwtToken := authHeader[len(prefix):]
-	// Check if the token is missing after removing the prefix
-	if jwtToken == "" {
-		// Log the error and respond with an unauthorized status if the token is missing
-		svc.ErrorHandler(refreshAttempts, nil, zap.String("error", "Token is missing"))
-		c.JSON(401, gin.H{"error": "Token is missing"})
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "two-factor authentication is not enabled"})
 		return
 	}
 
-	// Validate the extracted token and retrieve the associated user if valid
-	user, valid := svc.validateToken(jwtToken, c)
-	// Proceed only if the token is valid; otherwise, respond with an unauthorized status
+	valid, err := svc.verifyPassword(user.Password, req.Password)
+	if err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to verify password"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
 	if !valid {
-		svc.ErrorHandler(refreshAttempts, nil, zap.String("error", "Unauthorized"))
-		c.JSON(401, gin.H{"error": "Unauthorized"})
+		twoFactorRequests.WithLabelValues("invalid_password").Inc()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
 		return
 	}
 
-	// Initialize a new JWT token
-	token := jwt.New(jwt.SigningMethodHS256)
+	if err := svc.verifySecondFactor(user, req.Code); err != nil {
+		if err == errInvalidMFACode {
+			twoFactorRequests.WithLabelValues("invalid_code").Inc()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid two-factor code"})
+			return
+		}
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to verify second factor"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := svc.db.DisableTOTP(user.ID); err != nil {
+		svc.ErrorHandler(twoFactorRequests, err, zap.String("error", "failed to disable two-factor authentication"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	twoFactorRequests.WithLabelValues("disabled").Inc()
+	svc.logger.Info("two-factor authentication disabled", zap.Int("user_id", user.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the authenticated user.
+// The returned options are passed to navigator.credentials.create and the session_id to FinishPasskeyRegistration.
+func (svc *AuthService) BeginPasskeyRegistration(c *gin.Context) {
+	svc.logger.Info("BeginPasskeyRegistration called")
 
-	// Set claims for the new token (e.g., username and user ID)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["username"] = user.Username
-	claims["user_id"] = user.ID
+	if svc.webauthn == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errPasskeysDisabled.Error()})
+		return
+	}
 
-	// Sign the new token with the server's secret key
-	tokenString, err := token.SignedString(svc.jwtKey)
+	user, err := svc.db.GetUserByID(c.GetInt("user_id"))
 	if err != nil {
-		// Log the error and respond with an unauthorized status if signing the token fails
-		svc.logger.Error("failed to sign token", zap.Error(err))
-		c.JSON(401, gin.H{"error": "failed to sign token"})
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	// Log the successful token refresh
-	svc.logger.Info("token refreshed")
+	passkeyUser, err := svc.loadPasskeyUser(user)
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to load passkeys"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
 
-	refreshAttempts.WithLabelValues("successful").Inc()
+	// Exclude registered authenticators and require a discoverable credential so it can log in without a username
+	exclusions := make([]protocol.CredentialDescriptor, len(passkeyUser.credentials))
+	for i, credential := range passkeyUser.credentials {
+		exclusions[i] = credential.Descriptor()
+	}
+
+	options, session, err := svc.webauthn.BeginRegistration(passkeyUser,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to begin registration"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	sessionID, err := svc.saveSession(passkeyRegistration, user.ID, session)
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to save session"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
 
-	// Respond with the new token upon successful refresh
-	c.JSON(200, gin.H{"token": tokenString})
+	passkeyRequests.WithLabelValues("registration_started").Inc()
+	c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "options": options})
 }
 
-func (svc *AuthService) GetKeys(c *gin.Context) {
+// FinishPasskeyRegistration verifies the authenticator's attestation and stores the new credential.
+// The body is the PublicKeyCredential returned by the browser; session_id and an optional name are query parameters.
+func (svc *AuthService) FinishPasskeyRegistration(c *gin.Context) {
+	svc.logger.Info("FinishPasskeyRegistration called")
 
-	api_key := c.GetHeader("api_key")
+	if svc.webauthn == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errPasskeysDisabled.Error()})
+		return
+	}
 
-	if api_key != svc.apiKey {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort()
+	userID, session, err := svc.takeSession(passkeyRegistration, c.Query("session_id"))
+	if err != nil || userID != c.GetInt("user_id") {
+		if err != nil && err != errInvalidSession {
+			svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to load session"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		passkeyRequests.WithLabelValues("invalid_session").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidSession.Error()})
 		return
 	}
 
-	keys, err := svc.db.GetKeys()
+	response, err := protocol.ParseCredentialCreationResponseBody(c.Request.Body)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "internal server error"})
+		passkeyRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 		return
 	}
 
-	c.JSON(200, gin.H{"keys": keys})
+	user, err := svc.db.GetUserByID(userID)
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if user.Disabled {
+		passkeyRequests.WithLabelValues("disabled").Inc()
+		c.JSON(http.StatusForbidden, gin.H{"error": errUserDisabled.Error()})
+		return
+	}
+
+	passkeyUser, err := svc.loadPasskeyUser(user)
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to load passkeys"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	credential, err := svc.webauthn.CreateCredential(passkeyUser, *session, response)
+	if err != nil {
+		svc.logger.Warn("passkey registration rejected", zap.Int("user_id", user.ID), zap.Error(err))
+		passkeyRequests.WithLabelValues("invalid_credential").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid credential"})
+		return
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+
+	if err := svc.db.CreatePasskey(newPasskeyCredential(user.ID, name, credential, svc.clock.Now())); err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to store passkey"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	passkeyRequests.WithLabelValues("registered").Inc()
+	svc.logger.Info("passkey registered", zap.Int("user_id", user.ID))
+	c.JSON(http.StatusCreated, gin.H{"message": "passkey registered"})
 }
 
-func (svc *AuthService) AddKey(c *gin.Context) {
-	api_key := c.GetHeader("api_key")
+// BeginPasskeyLogin starts a passkey assertion. With a username the challenge is limited to that
+// user's credentials; otherwise, or when the user has none, any discoverable credential may answer.
+func (svc *AuthService) BeginPasskeyLogin(c *gin.Context) {
+	svc.logger.Info("BeginPasskeyLogin called")
 
-	if api_key != svc.apiKey {
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort()
+	if svc.webauthn == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errPasskeysDisabled.Error()})
 		return
 	}
 
-	var req AddKeyRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "invalid request"})
+	var req PasskeyLoginRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			passkeyRequests.WithLabelValues("invalid_request").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+	}
+
+	// Unknown users fall back to a discoverable challenge so the response does not reveal who exists
+	var passkeyUser *passkeyUser
+	if req.Username != "" {
+		user, err := svc.db.GetUser(strings.ToLower(req.Username))
+		if err != nil && err != sql.ErrNoRows {
+			svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to get user"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if user != nil {
+			passkeyUser, err = svc.loadPasskeyUser(user)
+			if err != nil {
+				svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to load passkeys"))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+				return
+			}
+		}
+	}
+
+	// Passkeys replace both the password and the second factor, so user verification is required
+	var (
+		options *protocol.CredentialAssertion
+		session *webauthn.SessionData
+		userID  int
+		err     error
+	)
+	if passkeyUser != nil && len(passkeyUser.credentials) > 0 {
+		userID = passkeyUser.user.ID
+		options, session, err = svc.webauthn.BeginLogin(passkeyUser, webauthn.WithUserVerification(protocol.VerificationRequired))
+	} else {
+		options, session, err = svc.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	}
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to begin login"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		return
 	}
 
-	err := svc.db.AddKey(req.Key)
+	sessionID, err := svc.saveSession(passkeyLogin, userID, session)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "internal server error"})
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to save session"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	passkeyRequests.WithLabelValues("login_started").Inc()
+	c.JSON(http.StatusOK, gin.H{"session_id": sessionID, "options": options})
+}
+
+// FinishPasskeyLogin verifies the assertion and issues the same tokens as a password login.
+// The body is the PublicKeyCredential returned by the browser; session_id is a query parameter.
+func (svc *AuthService) FinishPasskeyLogin(c *gin.Context) {
+	svc.logger.Info("FinishPasskeyLogin called")
+
+	if svc.webauthn == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errPasskeysDisabled.Error()})
+		return
+	}
+
+	userID, session, err := svc.takeSession(passkeyLogin, c.Query("session_id"))
+	if err != nil {
+		if err == errInvalidSession {
+			passkeyRequests.WithLabelValues("invalid_session").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidSession.Error()})
+			return
+		}
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to load session"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	response, err := protocol.ParseCredentialRequestResponseBody(c.Request.Body)
+	if err != nil {
+		passkeyRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	var credential *webauthn.Credential
+	if userID != 0 {
+		var passkeyUser webauthn.User
+		if passkeyUser, err = svc.discoverPasskeyUser(nil, userHandle(userID)); err == nil {
+			credential, err = svc.webauthn.ValidateLogin(passkeyUser, *session, response)
+		}
+	} else {
+		credential, err = svc.webauthn.ValidateDiscoverableLogin(svc.discoverPasskeyUser, *session, response)
+	}
+	if err != nil {
+		svc.logger.Warn("passkey login rejected", zap.Error(err))
+		passkeyRequests.WithLabelValues("invalid_credentials").Inc()
+		loginAttempts.WithLabelValues("invalid_credentials").Inc()
+		svc.recordLogin(c, userID, "", "passkey", audit.OutcomeFailure, "invalid_credentials")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid passkey"})
+		return
+	}
+
+	// A signature counter that did not advance suggests the authenticator was cloned
+	if credential.Authenticator.CloneWarning {
+		svc.logger.Warn("passkey sign count did not increase, possible cloned authenticator", zap.Binary("credential_id", credential.ID))
+		passkeyRequests.WithLabelValues("clone_warning").Inc()
+		svc.recordLogin(c, userID, "", "passkey", audit.OutcomeFailure, "clone_warning")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid passkey"})
+		return
+	}
+
+	// A discoverable assertion identifies its user by the handle checked during validation
+	if userID == 0 {
+		userID = int(binary.BigEndian.Uint64(response.Response.UserHandle))
+	}
+
+	user, err := svc.db.GetUserByID(userID)
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := checkAccountState(user); err != nil {
+		passkeyRequests.WithLabelValues("rejected").Inc()
+		loginAttempts.WithLabelValues("rejected").Inc()
+		svc.recordLogin(c, user.ID, user.Username, "passkey", audit.OutcomeFailure, err.Error())
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := svc.db.UpdatePasskeyUsage(credential.ID, credential.Authenticator.SignCount, credential.Flags.BackupState, svc.clock.Now()); err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to update passkey"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	tokens, err := svc.generateAndSendToken(user, "")
+	if err != nil {
+		svc.ErrorHandler(loginAttempts, err, zap.String("error", "failed to generate token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	passkeyRequests.WithLabelValues("successful").Inc()
+	loginAttempts.WithLabelValues("successful").Inc()
+	svc.recordLogin(c, user.ID, user.Username, "passkey", audit.OutcomeSuccess, "")
+	c.JSON(http.StatusOK, tokens)
+}
+
+// GetPasskeys lists the authenticated user's registered passkeys.
+func (svc *AuthService) GetPasskeys(c *gin.Context) {
+	svc.logger.Info("GetPasskeys called")
+
+	passkeys, err := svc.db.GetPasskeysByUser(c.GetInt("user_id"))
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to get passkeys"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if passkeys == nil {
+		passkeys = []db.PasskeyCredential{}
+	}
+	c.JSON(http.StatusOK, gin.H{"passkeys": passkeys})
+}
+
+// DeletePasskey removes one of the authenticated user's passkeys.
+func (svc *AuthService) DeletePasskey(c *gin.Context) {
+	svc.logger.Info("DeletePasskey called")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid passkey id"})
+		return
+	}
+
+	deleted, err := svc.db.DeletePasskey(c.GetInt("user_id"), id)
+	if err != nil {
+		svc.ErrorHandler(passkeyRequests, err, zap.String("error", "failed to delete passkey"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+		return
+	}
+
+	passkeyRequests.WithLabelValues("deleted").Inc()
+	c.JSON(http.StatusOK, gin.H{"message": "passkey deleted"})
+}
+
+// Register handles user registration requests.
+func (svc *AuthService) Register(c *gin.Context) {
+	// Log the invocation of the Register function
+	svc.logger.Info("Register called")
+
+	var req RegisterRequest
+	// Bind the incoming JSON request to a RegisterRequest struct; handle errors
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// Log the error and respond with a bad request status if request binding fails
+		svc.ErrorHandler(registerAttempts, err, zap.String("error", "invalid request"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	username := strings.ToLower(req.Username)
+
+	// Hash the provided password
+	hash, err := svc.hasher.Hash(req.Password)
+	if err != nil {
+		// Log the error and respond with an internal server error if password hashing fails
+		svc.ErrorHandler(registerAttempts, err, zap.String("error", "failed to hash password"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Redeem the registration key and create the user in one transaction, so a key
+	// cannot be used more often than allowed and a failed signup does not consume it
+	user, key, err := svc.db.RegisterUser(req.Key, username, hash, svc.clock.Now())
+	if err != nil {
+		switch err {
+		case db.ErrInvalidKey:
+			// Respond with an unauthorized status if the registration key is invalid
+			registerAttempts.WithLabelValues("invalid_key").Inc()
+			svc.audit.Record(c, audit.Event{Action: audit.ActionRegister, Outcome: audit.OutcomeFailure, Actor: username, Details: map[string]any{"reason": "invalid_key"}})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid key"})
+		case db.ErrUsernameTaken:
+			// Respond with a conflict status if the username already exists
+			registerAttempts.WithLabelValues("username_exists").Inc()
+			svc.audit.Record(c, audit.Event{Action: audit.ActionRegister, Outcome: audit.OutcomeFailure, Actor: username, Details: map[string]any{"reason": "username_exists"}})
+			c.JSON(http.StatusConflict, gin.H{"error": "username already exists"})
+		default:
+			// Log the error and respond with an internal server error if user creation fails
+			svc.ErrorHandler(registerAttempts, err, zap.String("error", "failed to create user"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	// Generate a token pair for the new user
+	tokens, err := svc.generateAndSendToken(user, "")
+	if err != nil {
+		// Log the error and respond with an internal server error if token generation fails
+		svc.ErrorHandler(registerAttempts, err, zap.String("error", "failed to generate token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	// Increment the register attempts metric for successful registration
+	registerAttempts.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{
+		Action:  audit.ActionRegister,
+		Outcome: audit.OutcomeSuccess,
+		ActorID: user.ID,
+		Actor:   user.Username,
+		Target:  fmt.Sprintf("key:%d", key.ID),
+		Details: map[string]any{"key_id": key.ID, "key_label": key.Label, "role": user.Role},
+	})
+	// Log the successful registration
+	svc.logger.Info("user registered", zap.String("username", user.Username), zap.Int("user_id", user.ID), zap.Int("key_id", key.ID), zap.String("role", user.Role))
+	// Respond with the generated tokens upon successful registration
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Refresh handles token refresh requests, rotating the presented refresh token into a new token pair.
+func (svc *AuthService) Refresh(c *gin.Context) {
+	// Log the invocation of the Refresh function
+	svc.logger.Info("Refresh called")
+
+	var req RefreshRequest
+	// Bind the incoming JSON request to a RefreshRequest struct; handle errors
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		refreshAttempts.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	// Redeem the refresh token and issue a new pair in the same family
+	tokens, userID, err := svc.rotateRefreshToken(req.RefreshToken)
+	if err != nil {
+		if err == errInvalidRefreshToken || err == errRefreshTokenReused {
+			svc.audit.Record(c, audit.Event{Action: audit.ActionRefresh, Outcome: audit.OutcomeFailure, ActorID: userID, Details: map[string]any{"reason": err.Error()}})
+		}
+
+		switch err {
+		case errInvalidRefreshToken:
+			refreshAttempts.WithLabelValues("invalid_token").Inc()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		case errRefreshTokenReused:
+			refreshAttempts.WithLabelValues("reuse_detected").Inc()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		default:
+			svc.ErrorHandler(refreshAttempts, err, zap.String("error", "failed to refresh token"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		}
+		return
+	}
+
+	// Log the successful token refresh
+	svc.logger.Info("token refreshed")
+
+	refreshAttempts.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionRefresh, Outcome: audit.OutcomeSuccess, ActorID: userID})
+
+	// Respond with the new token pair upon successful refresh
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the access token used for the request and, if provided, the refresh token family.
+func (svc *AuthService) Logout(c *gin.Context) {
+	svc.logger.Info("Logout called")
+
+	// The refresh token is optional; a missing body only revokes the access token
+	var req LogoutRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			logoutRequests.WithLabelValues("invalid_request").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+			return
+		}
+	}
+
+	// Revoke the access token until it would have expired anyway
+	if err := svc.revocations.Revoke(c.GetString("jti"), c.GetTime("token_expires_at")); err != nil {
+		svc.ErrorHandler(logoutRequests, err, zap.String("error", "failed to revoke token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Revoke the refresh token family so the session cannot be refreshed
+	if req.RefreshToken != "" {
+		if err := svc.revokeRefreshFamily(c.GetInt("user_id"), req.RefreshToken); err != nil {
+			svc.ErrorHandler(logoutRequests, err, zap.String("error", "failed to revoke refresh token"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+	}
+
+	logoutRequests.WithLabelValues("successful").Inc()
+	svc.logger.Info("user logged out", zap.Int("user_id", c.GetInt("user_id")))
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// LogoutAll invalidates every outstanding access and refresh token of the current user.
+func (svc *AuthService) LogoutAll(c *gin.Context) {
+	svc.logger.Info("LogoutAll called")
+
+	userID := c.GetInt("user_id")
+	if err := svc.revokeAllSessions(userID); err != nil {
+		svc.ErrorHandler(logoutAllRequests, err, zap.String("error", "failed to revoke sessions"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	logoutAllRequests.WithLabelValues("successful").Inc()
+	svc.logger.Info("user logged out everywhere", zap.Int("user_id", userID))
+	c.JSON(http.StatusOK, gin.H{"message": "logged out from all sessions"})
+}
+
+// ChangePassword replaces the current user's password after verifying the old one.
+// All outstanding sessions are revoked and a fresh token pair is returned for the caller.
+func (svc *AuthService) ChangePassword(c *gin.Context) {
+	svc.logger.Info("ChangePassword called")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.OldPassword == "" {
+		passwordRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if len(req.NewPassword) < minPasswordLength {
+		passwordRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errPasswordTooShort.Error()})
+		return
+	}
+
+	user, err := svc.db.GetUserByID(c.GetInt("user_id"))
+	if err != nil {
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// A stolen access token must not allow guessing the old password
+	if svc.throttled(c, user.Username) {
+		return
+	}
+
+	valid, err := svc.verifyPassword(user.Password, req.OldPassword)
+	if err != nil {
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to verify password"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !valid {
+		passwordRequests.WithLabelValues("invalid_password").Inc()
+		svc.recordLoginFailure(user.Username, c.ClientIP())
+		svc.audit.Record(c, audit.Event{Action: audit.ActionPasswordChange, Outcome: audit.OutcomeFailure, Details: map[string]any{"reason": "invalid_password"}})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		return
+	}
+
+	hash, err := svc.hasher.Hash(req.NewPassword)
+	if err != nil {
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to hash password"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Sessions are revoked first, so a failure cannot leave them valid under the new password
+	if err := svc.revokeAllSessions(user.ID); err != nil {
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to revoke sessions"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := svc.db.UpdatePassword(user.ID, hash); err != nil {
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to update password"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	svc.clearLoginFailures(user.Username)
+
+	tokens, err := svc.generateAndSendToken(user, "")
+	if err != nil {
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to generate token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	passwordRequests.WithLabelValues("changed").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionPasswordChange, Outcome: audit.OutcomeSuccess})
+	svc.logger.Info("password changed", zap.Int("user_id", user.ID))
+	c.JSON(http.StatusOK, tokens)
+}
+
+// ResetPassword redeems a reset token, sets the new password and revokes all sessions of the user.
+func (svc *AuthService) ResetPassword(c *gin.Context) {
+	svc.logger.Info("ResetPassword called")
+
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		passwordRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if len(req.NewPassword) < minPasswordLength {
+		passwordRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errPasswordTooShort.Error()})
+		return
+	}
+
+	hash, err := svc.hasher.Hash(req.NewPassword)
+	if err != nil {
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to hash password"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	userID, err := svc.db.ResetPassword(crypto.HashToken(req.Token), hash, svc.clock.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			passwordRequests.WithLabelValues("invalid_token").Inc()
+			svc.audit.Record(c, audit.Event{Action: audit.ActionPasswordReset, Outcome: audit.OutcomeFailure, Details: map[string]any{"reason": "invalid_token"}})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to reset password"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := svc.revokeAllSessions(userID); err != nil {
+		svc.ErrorHandler(passwordRequests, err, zap.String("error", "failed to revoke sessions"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// A locked out user may be resetting precisely because of the lockout
+	if user, err := svc.db.GetUserByID(userID); err == nil {
+		svc.clearLoginFailures(user.Username)
+	}
+
+	passwordRequests.WithLabelValues("reset").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionPasswordReset, Outcome: audit.OutcomeSuccess, ActorID: userID})
+	svc.logger.Info("password reset", zap.Int("user_id", userID))
+	c.JSON(http.StatusOK, gin.H{"message": "password reset"})
+}
+
+// JWKS publishes the public keys that verify tokens issued by this API. A rotated key signs
+// tokens right away, so verifiers must revalidate their copy on every use rather than cache it
+// for a while; the ETag lets them do so without downloading an unchanged set again.
+func (svc *AuthService) JWKS(c *gin.Context) {
+	body, err := json.Marshal(svc.keyring.JWKS())
+	if err != nil {
+		svc.logger.Error("failed to encode the key set", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("Cache-Control", "no-cache")
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// RotateSigningKey replaces the active signing key; the previous key keeps verifying during the grace period.
+func (svc *AuthService) RotateSigningKey(c *gin.Context) {
+	svc.logger.Info("RotateSigningKey called")
+
+	key, err := svc.keyring.Rotate()
+	if err != nil {
+		svc.ErrorHandler(keyRotations, err, zap.String("error", "failed to rotate signing key"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	keyRotations.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionSigningKeyRotation, Outcome: audit.OutcomeSuccess, Target: "signing_key:" + key.ID})
+	svc.logger.Info("signing key rotated", zap.String("kid", key.ID), zap.String("algorithm", key.Algorithm))
+	c.JSON(http.StatusOK, gin.H{"kid": key.ID, "algorithm": key.Algorithm})
+}
+
+// GetLockouts lists the usernames and client IPs currently throttled or locked out.
+func (svc *AuthService) GetLockouts(c *gin.Context) {
+	svc.logger.Info("GetLockouts called")
+
+	lockouts, err := svc.guard.Blocked()
+	if err != nil {
+		svc.ErrorHandler(lockoutEvents, err, zap.String("error", "failed to get lockouts"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if lockouts == nil {
+		lockouts = []db.LoginFailure{}
+	}
+	c.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+// Unlock clears the failed logins of a username or client IP, lifting any lockout.
+func (svc *AuthService) Unlock(c *gin.Context) {
+	svc.logger.Info("Unlock called")
+
+	scope, identifier := c.Param("scope"), c.Param("identifier")
+	if scope != lockout.ScopeUsername && scope != lockout.ScopeIP {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be username or ip"})
+		return
+	}
+
+	unlocked, err := svc.guard.Unlock(scope, identifier)
+	if err != nil {
+		svc.ErrorHandler(lockoutEvents, err, zap.String("error", "failed to unlock"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !unlocked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no failed logins recorded"})
+		return
+	}
+
+	lockoutEvents.WithLabelValues("unlocked").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionLockoutUnlock, Outcome: audit.OutcomeSuccess, Target: scope + ":" + identifier})
+	svc.logger.Info("login unlocked", zap.String("scope", scope), zap.String("identifier", identifier))
+	c.JSON(http.StatusOK, gin.H{"message": "unlocked"})
+}
+
+// GetKeys lists the registration keys with their remaining uses, expiry and role.
+func (svc *AuthService) GetKeys(c *gin.Context) {
+	svc.logger.Info("GetKeys called")
+
+	keys, err := svc.db.GetKeys()
+	if err != nil {
+		svc.ErrorHandler(keyRequests, err, zap.String("error", "failed to get keys"))
+		c.JSON(500, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if keys == nil {
+		keys = []db.RegistrationKey{}
+	}
+	c.JSON(200, gin.H{"keys": keys})
+}
+
+// AddKey creates a registration key. Label, expiry, number of uses and the role granted
+// to accounts created with it are optional; the key itself is generated when omitted.
+func (svc *AuthService) AddKey(c *gin.Context) {
+	svc.logger.Info("AddKey called")
+
+	var req AddKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request"})
+		return
+	}
+
+	key, err := svc.newRegistrationKey(req)
+	if err != nil {
+		keyRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// An admin signed up with the key would hold every permission, beyond the token's scopes
+	if _, scoped := c.Get("scopes"); scoped && key.Role == db.RoleAdmin {
+		keyRequests.WithLabelValues("forbidden").Inc()
+		c.JSON(http.StatusForbidden, gin.H{"error": errAdminKeyScoped.Error()})
+		return
+	}
+
+	// Keys created with a service credential have no user to attribute them to
+	key.CreatedBy = c.GetString("username")
+	if key.CreatedBy == "" {
+		key.CreatedBy = "service:" + c.GetString("service_credential")
+	}
+
+	created, err := svc.db.CreateKey(key, svc.clock.Now())
+	if err != nil {
+		svc.ErrorHandler(keyRequests, err, zap.String("error", "failed to create key"))
+		c.JSON(500, gin.H{"error": "internal server error"})
+		return
+	}
+
+	keyRequests.WithLabelValues("created").Inc()
+	svc.audit.Record(c, audit.Event{
+		Action:  audit.ActionKeyCreate,
+		Outcome: audit.OutcomeSuccess,
+		Target:  fmt.Sprintf("key:%d", created.ID),
+		Details: map[string]any{"label": created.Label, "role": created.Role, "max_uses": created.MaxUses},
+	})
+	svc.logger.Info("registration key created", zap.Int("key_id", created.ID), zap.String("label", created.Label), zap.String("role", created.Role))
+	c.JSON(200, gin.H{"message": "key added", "key": created})
+}
+
+// DeleteKey revokes a registration key so it can no longer be redeemed.
+func (svc *AuthService) DeleteKey(c *gin.Context) {
+	svc.logger.Info("DeleteKey called")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key id"})
+		return
+	}
+
+	deleted, err := svc.db.DeleteKey(id)
+	if err != nil {
+		svc.ErrorHandler(keyRequests, err, zap.String("error", "failed to delete key"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+
+	keyRequests.WithLabelValues("revoked").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionKeyDelete, Outcome: audit.OutcomeSuccess, Target: fmt.Sprintf("key:%d", id)})
+	svc.logger.Info("registration key revoked", zap.Int("key_id", id))
+	c.JSON(http.StatusOK, gin.H{"message": "key revoked"})
+}
+
+// CreateAccessToken issues a personal access token for scripts. The token is returned once
+// and only its hash is stored.
+func (svc *AuthService) CreateAccessToken(c *gin.Context) {
+	svc.logger.Info("CreateAccessToken called")
+
+	var req CreateAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		accessTokenRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	token, err := svc.newAccessToken(c.GetInt("user_id"), c.GetString("role"), req)
+	if err != nil {
+		accessTokenRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := crypto.GenerateToken()
+	if err != nil {
+		svc.ErrorHandler(accessTokenRequests, err, zap.String("error", "failed to generate access token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	secret = AccessTokenPrefix + secret
+	token.TokenHash = crypto.HashToken(secret)
+
+	token, err = svc.db.CreateAccessToken(token)
+	if err != nil {
+		svc.ErrorHandler(accessTokenRequests, err, zap.String("error", "failed to store access token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	accessTokenRequests.WithLabelValues("created").Inc()
+	svc.audit.Record(c, audit.Event{
+		Action:  audit.ActionAccessTokenCreate,
+		Outcome: audit.OutcomeSuccess,
+		Target:  fmt.Sprintf("access_token:%d", token.ID),
+		Details: map[string]any{"name": token.Name, "scopes": token.Scopes},
+	})
+	svc.logger.Info("access token created", zap.Int("user_id", token.UserID), zap.Int("token_id", token.ID), zap.Strings("scopes", token.Scopes))
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "access_token": token})
+}
+
+// GetAccessTokens lists the authenticated user's personal access tokens.
+func (svc *AuthService) GetAccessTokens(c *gin.Context) {
+	svc.logger.Info("GetAccessTokens called")
+
+	tokens, err := svc.db.GetAccessTokensByUser(c.GetInt("user_id"))
+	if err != nil {
+		svc.ErrorHandler(accessTokenRequests, err, zap.String("error", "failed to get access tokens"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if tokens == nil {
+		tokens = []db.AccessToken{}
+	}
+
+	accessTokenRequests.WithLabelValues("successful").Inc()
+	c.JSON(http.StatusOK, gin.H{"access_tokens": tokens})
+}
+
+// DeleteAccessToken revokes one of the authenticated user's personal access tokens.
+func (svc *AuthService) DeleteAccessToken(c *gin.Context) {
+	svc.logger.Info("DeleteAccessToken called")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid access token id"})
+		return
+	}
+
+	deleted, err := svc.db.DeleteAccessToken(c.GetInt("user_id"), id)
+	if err != nil {
+		svc.ErrorHandler(accessTokenRequests, err, zap.String("error", "failed to delete access token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "access token not found"})
+		return
+	}
+
+	accessTokenRequests.WithLabelValues("revoked").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionAccessTokenDelete, Outcome: audit.OutcomeSuccess, Target: fmt.Sprintf("access_token:%d", id)})
+	c.JSON(http.StatusOK, gin.H{"message": "access token revoked"})
+}
+
+// CreateServiceCredential generates a named key for a service calling admin or metrics
+// endpoints. The key is returned once and only its hash is stored.
+func (svc *AuthService) CreateServiceCredential(c *gin.Context) {
+	svc.logger.Info("CreateServiceCredential called")
+
+	var req CreateServiceCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		credentialRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if err := svc.validateServiceCredential(name, req); err != nil {
+		credentialRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, credential, err := svc.credentials.Create(name, req.Purpose, req.ExpiresAt)
+	if err != nil {
+		svc.ErrorHandler(credentialRequests, err, zap.String("error", "failed to create service credential"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	credentialRequests.WithLabelValues("created").Inc()
+	svc.audit.Record(c, audit.Event{
+		Action:  audit.ActionCredentialCreate,
+		Outcome: audit.OutcomeSuccess,
+		Target:  fmt.Sprintf("service_credential:%d", credential.ID),
+		Details: map[string]any{"name": credential.Name, "purpose": credential.Purpose},
+	})
+	svc.logger.Info("service credential created", zap.Int("credential_id", credential.ID), zap.String("name", credential.Name), zap.String("purpose", credential.Purpose))
+	c.JSON(http.StatusCreated, gin.H{"key": key, "credential": credential})
+}
+
+// GetServiceCredentials lists service credentials with their last use.
+func (svc *AuthService) GetServiceCredentials(c *gin.Context) {
+	svc.logger.Info("GetServiceCredentials called")
+
+	credentialRequests.WithLabelValues("successful").Inc()
+	c.JSON(http.StatusOK, gin.H{"credentials": svc.credentials.List()})
+}
+
+// DeleteServiceCredential revokes a service credential.
+func (svc *AuthService) DeleteServiceCredential(c *gin.Context) {
+	svc.logger.Info("DeleteServiceCredential called")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid credential id"})
+		return
+	}
+
+	deleted, err := svc.credentials.Delete(id)
+	if err != nil {
+		svc.ErrorHandler(credentialRequests, err, zap.String("error", "failed to delete service credential"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "credential not found"})
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "key added"})
+	credentialRequests.WithLabelValues("revoked").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionCredentialDelete, Outcome: audit.OutcomeSuccess, Target: fmt.Sprintf("service_credential:%d", id)})
+	svc.logger.Info("service credential revoked", zap.Int("credential_id", id))
+	c.JSON(http.StatusOK, gin.H{"message": "credential revoked"})
 }
@@ -1,13 +1,29 @@
 package auth
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
+	"github.com/VicSobDev/anniversaryAPI/internal/credentials"
+	"github.com/VicSobDev/anniversaryAPI/internal/rbac"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
 	"github.com/VicSobDev/anniversaryAPI/pkg/db"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/VicSobDev/anniversaryAPI/pkg/totp"
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -21,14 +37,37 @@ func (svc *AuthService) ErrorHandler(cv *prometheus.CounterVec, err error, field
 
 // verifyPassword checks if the provided password matches the stored hash.
 func (svc *AuthService) verifyPassword(storedHash, providedPassword string) (bool, error) {
-	// Use Argon2 to verify the password against the stored hash
-	valid, err := svc.argon.Verify(storedHash, providedPassword)
+	// Verify the password with the hasher matching the format of the stored hash
+	valid, err := svc.hasher.Verify(storedHash, providedPassword)
 	if err != nil {
 		return false, err // Return false and the error if verification fails
 	}
 	return valid, nil // Return true if the password is valid, else false
 }
 
+// upgradePasswordHash rehashes the password with the configured parameters when the stored
+// hash uses weaker ones. Failures are logged without failing the login.
+func (svc *AuthService) upgradePasswordHash(user *db.User, password string) {
+	rehash, err := svc.hasher.NeedsRehash(user.Password)
+	if err != nil || !rehash {
+		return
+	}
+
+	hash, err := svc.hasher.Hash(password)
+	if err != nil {
+		svc.logger.Error("failed to rehash password", zap.Int("user_id", user.ID), zap.Error(err))
+		return
+	}
+
+	if err := svc.db.ReplacePasswordHash(user.ID, user.Password, hash); err != nil {
+		svc.logger.Error("failed to store rehashed password", zap.Int("user_id", user.ID), zap.Error(err))
+		return
+	}
+
+	user.Password = hash
+	svc.logger.Info("password rehashed with current parameters", zap.Int("user_id", user.ID))
+}
+
 // attemptLogin processes a login request, verifying the user's credentials.
 func (svc *AuthService) attemptLogin(req LoginRequest) (*db.User, error) {
 	username := strings.ToLower(req.Username) // Normalize the username to lowercase
@@ -58,96 +97,476 @@ func (svc *AuthService) attemptLogin(req LoginRequest) (*db.User, error) {
 		return nil, errInvalidPassword
 	}
 
+	// Only reveal the account state to callers who know the password
+	if err := checkAccountState(user); err != nil {
+		return nil, err
+	}
+
+	svc.upgradePasswordHash(user, req.Password)
+
 	// Return the user object if the login is successful
 	return user, nil
 }
 
-// generateAndSendToken creates a JWT token for the authenticated user and returns it.
-func (svc *AuthService) generateAndSendToken(user *db.User, c *gin.Context) (string, error) {
-	// Initialize a new JWT token
-	token := jwt.New(jwt.SigningMethodHS256)
+// checkAccountState rejects accounts that may not sign in with any method: disabled users and
+// users an admin required to reset their password. It runs once the credentials are verified.
+func checkAccountState(user *db.User) error {
+	if user.Disabled {
+		return errUserDisabled
+	}
+	if user.PasswordResetRequired {
+		return errPasswordReset
+	}
+	return nil
+}
 
-	// Cast the token claims to a MapClaims object and set username and user ID
-	claims := token.Claims.(jwt.MapClaims)
-	claims["username"] = user.Username // Include the username in the token payload
-	claims["user_id"] = user.ID        // Include the user ID in the token payload
+// throttled responds with 429 and reports true when logins for username from the client IP are blocked.
+func (svc *AuthService) throttled(c *gin.Context, username string) bool {
+	wait, err := svc.guard.Check(username, c.ClientIP())
+	if err != nil {
+		svc.ErrorHandler(loginAttempts, err, zap.String("error", "failed to check lockout"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return true
+	}
+
+	if wait <= 0 {
+		return false
+	}
+
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	loginAttempts.WithLabelValues("throttled").Inc()
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts", "retry_after": retryAfter})
+	return true
+}
 
-	// Sign the token with the server's secret key to generate the token string
-	tokenString, err := token.SignedString(svc.jwtKey)
+// recordLoginFailure counts a failed login towards the lockout of the username and client IP.
+func (svc *AuthService) recordLoginFailure(username, ip string) {
+	outcome, err := svc.guard.Fail(username, ip)
 	if err != nil {
-		// If an error occurs during token signing, log the error and return it
-		svc.ErrorHandler(loginAttempts, err, zap.String("error", "failed to sign token"))
-		return "", err // Return an empty string and the error
+		svc.ErrorHandler(lockoutEvents, err, zap.String("error", "failed to record login failure"))
+		return
 	}
 
-	// Return the signed token string and nil for the error if token generation was successful
-	return tokenString, nil
+	for _, scope := range outcome.Locked {
+		lockoutEvents.WithLabelValues(scope).Inc()
+		svc.logger.Warn("login locked out after repeated failures", zap.String("scope", scope), zap.String("username", username), zap.String("ip", ip))
+	}
+}
+
+// clearLoginFailures resets the username's failure count after a successful login.
+func (svc *AuthService) clearLoginFailures(username string) {
+	if err := svc.guard.Succeed(username); err != nil {
+		svc.ErrorHandler(lockoutEvents, err, zap.String("error", "failed to clear login failures"))
+	}
 }
 
-// validateToken parses and validates a JWT token string, extracting user information if valid.
-func (a *AuthService) validateToken(tokenString string, c *gin.Context) (db.User, bool) {
-	// Parse the token using the specified callback function to provide the key for verification
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the token uses the expected HMAC signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// recordLogin adds a login attempt to the audit log. The user is not authenticated yet,
+// so the actor is given explicitly.
+func (svc *AuthService) recordLogin(c *gin.Context, userID int, username, method, outcome, reason string) {
+	details := map[string]any{"method": method}
+	if reason != "" {
+		details["reason"] = reason
+	}
+
+	svc.audit.Record(c, audit.Event{Action: audit.ActionLogin, Outcome: outcome, ActorID: userID, Actor: username, Details: details})
+}
+
+// generateAndSendToken creates a short-lived access token and a refresh token for the user.
+// The refresh token joins familyID, or starts a new rotation family when familyID is empty.
+func (svc *AuthService) generateAndSendToken(user *db.User, familyID string) (*TokenResponse, error) {
+	now := time.Now()
+
+	// Tokens carry the user's current generation so logout-all can invalidate them
+	generation, err := svc.revocations.Generation(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token generation: %w", err)
+	}
+
+	// Issue a signed access token carrying the user and registered claims
+	tokenString, _, err := svc.tokens.Issue(user.ID, user.Username, user.Role, generation)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the opaque refresh token; only its hash is persisted
+	refreshToken, err := crypto.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// A new family means a fresh login rather than a refresh
+	if familyID == "" {
+		familyID = uuid.NewString()
+		if err := svc.db.RecordLogin(user.ID, now); err != nil {
+			return nil, fmt.Errorf("failed to record login: %w", err)
 		}
-		return a.jwtKey, nil // Provide the secret key for the HMAC method
-	})
+	}
 
-	// Handle parsing errors by logging and responding with an unauthorized status
+	if err := svc.db.CreateRefreshToken(user.ID, familyID, crypto.HashToken(refreshToken), now, now.Add(refreshTokenTTL)); err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(svc.tokens.TTL().Seconds()),
+	}, nil
+}
+
+// rotateRefreshToken redeems a refresh token and issues a new token pair in the same family.
+// Presenting an already used token revokes the whole family, since it means the token was replayed.
+// The owner of the token is returned whenever it is known, for the audit log.
+func (svc *AuthService) rotateRefreshToken(refreshToken string) (*TokenResponse, int, error) {
+	now := time.Now()
+
+	stored, err := svc.db.GetRefreshToken(crypto.HashToken(refreshToken))
 	if err != nil {
-		a.logger.Error("failed to parse token", zap.Error(err))
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort() // Stop further handlers from executing
-		return db.User{}, false
+		if err == sql.ErrNoRows {
+			return nil, 0, errInvalidRefreshToken
+		}
+		return nil, 0, err
 	}
 
-	// Check if the token is actually valid
-	if !token.Valid {
-		a.logger.Error("invalid token")
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort() // Stop further handlers from executing
-		return db.User{}, false
+	if stored.RevokedAt != nil || now.After(stored.ExpiresAt) {
+		return nil, stored.UserID, errInvalidRefreshToken
 	}
 
-	// Attempt to extract claims from the token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		a.logger.Error("failed to extract claims from token")
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort() // Stop further handlers from executing
-		return db.User{}, false
+	// Only one caller can mark a token as used; anyone else is replaying it
+	marked, err := svc.db.MarkRefreshTokenUsed(stored.ID, now)
+	if err != nil {
+		return nil, stored.UserID, err
 	}
 
-	// Log the extracted claims for debugging purposes
-	a.logger.Info("claims", zap.Any("claims", claims))
+	if stored.UsedAt != nil || !marked {
+		if err := svc.db.RevokeRefreshTokenFamily(stored.FamilyID, now); err != nil {
+			return nil, stored.UserID, err
+		}
+		svc.logger.Warn("refresh token reuse detected, family revoked", zap.String("family_id", stored.FamilyID), zap.Int("user_id", stored.UserID))
+		return nil, stored.UserID, errRefreshTokenReused
+	}
+
+	user, err := svc.db.GetUserByID(stored.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, stored.UserID, errInvalidRefreshToken
+		}
+		return nil, stored.UserID, err
+	}
 
-	// Extract the user ID from the claims
-	userID, ok := claims["user_id"].(float64) // JWT standard encodes numeric IDs as floats
-	if !ok {
-		a.logger.Error("user_id not found in claims")
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort() // Stop further handlers from executing
-		return db.User{}, false
+	if user.Disabled {
+		return nil, stored.UserID, errInvalidRefreshToken
 	}
 
-	// Extract the username from the claims
-	username, ok := claims["username"].(string)
-	if !ok {
-		a.logger.Error("username not found in claims")
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort() // Stop further handlers from executing
-		return db.User{}, false
+	tokens, err := svc.generateAndSendToken(user, stored.FamilyID)
+	return tokens, user.ID, err
+}
+
+// revokeRefreshFamily revokes the rotation family of a refresh token owned by userID.
+// Unknown tokens and tokens belonging to other users are ignored.
+func (svc *AuthService) revokeRefreshFamily(userID int, refreshToken string) error {
+	stored, err := svc.db.GetRefreshToken(crypto.HashToken(refreshToken))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
 	}
 
-	// Reconstruct the user object from the claims
-	user := db.User{
-		ID:       int(userID), // Convert the float64 ID back to an int
-		Username: username,
+	if stored.UserID != userID {
+		return nil
 	}
 
-	// Log the successful validation of the user
-	a.logger.Info("user validated", zap.String("username", user.Username))
-	return user, true // Return the reconstructed user and true indicating the token is valid
+	return svc.db.RevokeRefreshTokenFamily(stored.FamilyID, time.Now())
+}
+
+// revokeAllSessions bumps the user's token generation and revokes all of their refresh
+// and personal access tokens.
+func (svc *AuthService) revokeAllSessions(userID int) error {
+	if _, err := svc.revocations.BumpGeneration(userID); err != nil {
+		return err
+	}
+	if err := svc.db.RevokeUserRefreshTokens(userID, svc.clock.Now()); err != nil {
+		return err
+	}
+	return svc.db.DeleteUserAccessTokens(userID)
+}
+
+// verifySecondFactor checks a TOTP code, or failing that a recovery code, for a user with 2FA enabled.
+// Accepted TOTP steps and recovery codes are consumed so they cannot be replayed.
+func (svc *AuthService) verifySecondFactor(user *db.User, code string) error {
+	secret, err := svc.decryptTOTPSecret(user)
+	if err != nil {
+		return err
+	}
+
+	now := svc.clock.Now()
+	step, ok, err := totp.Validate(secret, code, now)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		fresh, err := svc.db.UseTOTPStep(user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errInvalidMFACode
+		}
+		return nil
+	}
+
+	// Fall back to a single-use recovery code
+	used, err := svc.db.UseRecoveryCode(user.ID, crypto.HashToken(normalizeRecoveryCode(code)), now)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errInvalidMFACode
+	}
+
+	svc.logger.Warn("recovery code used", zap.Int("user_id", user.ID))
+	return nil
+}
+
+// decryptTOTPSecret returns the user's plaintext TOTP secret.
+func (svc *AuthService) decryptTOTPSecret(user *db.User) (string, error) {
+	if svc.cipher == nil {
+		return "", errTwoFactorDisabled
+	}
+	if user.TOTPSecret == "" {
+		return "", errInvalidMFACode
+	}
+	return svc.cipher.Decrypt(user.TOTPSecret)
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return userHandle(u.user.ID) }
+func (u *passkeyUser) WebAuthnName() string                       { return u.user.Username }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.user.Username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+func (u *passkeyUser) WebAuthnIcon() string                       { return "" }
+
+// loadPasskeyUser loads the user's registered passkeys for a WebAuthn ceremony.
+func (svc *AuthService) loadPasskeyUser(user *db.User) (*passkeyUser, error) {
+	stored, err := svc.db.GetPasskeysByUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, len(stored))
+	for i, credential := range stored {
+		transports := make([]protocol.AuthenticatorTransport, len(credential.Transports))
+		for j, transport := range credential.Transports {
+			transports[j] = protocol.AuthenticatorTransport(transport)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              credential.CredentialID,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: credential.BackupEligible,
+				BackupState:    credential.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    credential.AAGUID,
+				SignCount: credential.SignCount,
+			},
+		}
+	}
+
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+// discoverPasskeyUser resolves the user handle returned by a discoverable credential.
+func (svc *AuthService) discoverPasskeyUser(_, handle []byte) (webauthn.User, error) {
+	if len(handle) != 8 {
+		return nil, errUserNotFound
+	}
+
+	user, err := svc.db.GetUserByID(int(binary.BigEndian.Uint64(handle)))
+	if err != nil {
+		return nil, err
+	}
+
+	passkeyUser, err := svc.loadPasskeyUser(user)
+	if err != nil {
+		return nil, err
+	}
+	return passkeyUser, nil
+}
+
+// saveSession stores the state of a started ceremony and returns the ID the client finishes it with.
+func (svc *AuthService) saveSession(purpose string, userID int, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := crypto.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := svc.db.CreateWebAuthnSession(crypto.HashToken(id), purpose, userID, data, svc.clock.Now().Add(passkeySessionTTL)); err != nil {
+		return "", fmt.Errorf("failed to store passkey session: %w", err)
+	}
+	return id, nil
+}
+
+// takeSession redeems a ceremony session; each session can only be finished once.
+func (svc *AuthService) takeSession(purpose, id string) (int, *webauthn.SessionData, error) {
+	userID, data, err := svc.db.TakeWebAuthnSession(crypto.HashToken(id), purpose, svc.clock.Now())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil, errInvalidSession
+		}
+		return 0, nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(data, &session); err != nil {
+		return 0, nil, err
+	}
+	return userID, &session, nil
+}
+
+// newPasskeyCredential converts a verified credential into its stored form.
+func newPasskeyCredential(userID int, name string, credential *webauthn.Credential, now time.Time) db.PasskeyCredential {
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+
+	return db.PasskeyCredential{
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            name,
+		CreatedAt:       now,
+	}
+}
+
+// userHandle returns the opaque WebAuthn user handle for a user ID.
+func userHandle(userID int) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// generateRecoveryCodes returns new recovery codes and the hashes to persist for them.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = crypto.HashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode strips the separator and case so codes can be typed loosely.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// newRegistrationKey validates an AddKeyRequest and fills in the defaults.
+func (svc *AuthService) newRegistrationKey(req AddKeyRequest) (db.RegistrationKey, error) {
+	key := db.RegistrationKey{
+		Key:       strings.TrimSpace(req.Key),
+		Label:     strings.TrimSpace(req.Label),
+		ExpiresAt: req.ExpiresAt,
+		MaxUses:   req.MaxUses,
+		Role:      req.Role,
+	}
+
+	if key.Key == "" {
+		key.Key = uuid.NewString()
+	}
+	if key.MaxUses == 0 {
+		key.MaxUses = 1
+	}
+	if key.Role == "" {
+		key.Role = db.RoleMember
+	}
+
+	if key.MaxUses < 0 {
+		return key, errInvalidMaxUses
+	}
+	if !db.ValidRole(key.Role) {
+		return key, errInvalidRole
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(svc.clock.Now()) {
+		return key, errExpiryPast
+	}
+
+	return key, nil
+}
+
+// newAccessToken validates a CreateAccessTokenRequest for a user holding role and fills in the defaults.
+func (svc *AuthService) newAccessToken(userID int, role string, req CreateAccessTokenRequest) (db.AccessToken, error) {
+	now := svc.clock.Now()
+	token := db.AccessToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		CreatedAt: now,
+		ExpiresAt: now.Add(accessTokenTTL),
+	}
+
+	if token.Name == "" {
+		return token, errTokenNameRequired
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			return token, errExpiryPast
+		}
+		token.ExpiresAt = *req.ExpiresAt
+	}
+
+	// A token can only narrow what its owner may do
+	if len(req.Scopes) == 0 {
+		return token, errInvalidScopes
+	}
+	for _, scope := range req.Scopes {
+		if !rbac.Allows(role, scope) {
+			return token, errInvalidScopes
+		}
+		if !slices.Contains(token.Scopes, scope) {
+			token.Scopes = append(token.Scopes, scope)
+		}
+	}
+
+	return token, nil
+}
+
+// validateServiceCredential checks a CreateServiceCredentialRequest with its trimmed name.
+func (svc *AuthService) validateServiceCredential(name string, req CreateServiceCredentialRequest) error {
+	if name == "" {
+		return errTokenNameRequired
+	}
+	if strings.HasPrefix(name, credentials.ReservedPrefix) {
+		return errReservedName
+	}
+	if !credentials.ValidPurpose(req.Purpose) {
+		return credentials.ErrInvalidPurpose
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(svc.clock.Now()) {
+		return errExpiryPast
+	}
+	return nil
 }
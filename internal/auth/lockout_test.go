@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/lockout"
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+)
+
+func TestLoginThrottled(t *testing.T) {
+	svc := newTestService(t)
+	createTestUser(t, svc, "victor", testPassword)
+	free := lockout.DefaultPolicies[lockout.ScopeUsername].FreeAttempts
+
+	// Wrong passwords are refused as such until the free attempts run out
+	wrong := LoginRequest{Username: "victor", Password: "wrong password"}
+	for i := 0; i <= free; i++ {
+		decode(t, serve(svc.Login, http.MethodPost, "/api/auth/login", wrong, 0), http.StatusUnauthorized, nil)
+	}
+
+	// During the backoff even the right password is refused, with the time to wait
+	correct := LoginRequest{Username: "victor", Password: testPassword}
+	w := serve(svc.Login, http.MethodPost, "/api/auth/login", correct, 0)
+	var throttled struct {
+		RetryAfter int `json:"retry_after"`
+	}
+	decode(t, w, http.StatusTooManyRequests, &throttled)
+	if got := w.Header().Get("Retry-After"); got != "1" || throttled.RetryAfter != 1 {
+		t.Fatalf("Retry-After = %q, retry_after = %d, want 1", got, throttled.RetryAfter)
+	}
+
+	// Once the delay has passed the login goes through
+	svc.guard = lockout.NewGuard(svc.db, clock.Fixed(testNow.Add(time.Second)), lockout.DefaultPolicies)
+	decode(t, serve(svc.Login, http.MethodPost, "/api/auth/login", correct, 0), http.StatusOK, nil)
+}
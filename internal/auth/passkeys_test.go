@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+const testOrigin = "https://localhost"
+
+var b64 = base64.RawURLEncoding
+
+// softAuthenticator is an in-memory platform authenticator holding one ES256 passkey, used to
+// drive registration and assertion ceremonies without a browser.
+type softAuthenticator struct {
+	t      *testing.T
+	key    *ecdsa.PrivateKey
+	id     []byte
+	handle []byte
+	count  uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{t: t, key: key, id: id}
+}
+
+// ceremony is the part of the Begin responses the authenticator needs
+type ceremony struct {
+	SessionID string `json:"session_id"`
+	Options   struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	} `json:"options"`
+}
+
+func (a *softAuthenticator) clientData(typ, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": challenge, "origin": testOrigin})
+	return data
+}
+
+// authData builds the authenticator data for the relying party, advancing the signature counter.
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	a.count++
+	rpID := sha256.Sum256([]byte("localhost"))
+
+	data := append(rpID[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	return append(data, attested...)
+}
+
+// create answers navigator.credentials.create with a "none" attestation.
+func (a *softAuthenticator) create(c ceremony) map[string]any {
+	handle, err := b64.DecodeString(c.Options.PublicKey.User.ID)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	a.handle = handle
+
+	publicKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(flagUserPresent|flagUserVerified|flagAttested, attested),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return map[string]any{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", c.Options.PublicKey.Challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	}
+}
+
+// get answers navigator.credentials.get with a signed assertion.
+func (a *softAuthenticator) get(c ceremony) map[string]any {
+	clientData := a.clientData("webauthn.get", c.Options.PublicKey.Challenge)
+	authData := a.authData(flagUserPresent|flagUserVerified, nil)
+
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return map[string]any{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.handle),
+		},
+	}
+}
+
+// registerPasskey runs a registration ceremony for the user.
+func registerPasskey(t *testing.T, svc *AuthService, userID int, a *softAuthenticator) {
+	t.Helper()
+
+	var begin ceremony
+	decode(t, serve(svc.BeginPasskeyRegistration, "POST", "/api/auth/passkeys/register/begin", nil, userID), http.StatusOK, &begin)
+	w := serve(svc.FinishPasskeyRegistration, "POST", "/api/auth/passkeys/register/finish?session_id="+begin.SessionID, a.create(begin), userID)
+	decode(t, w, http.StatusCreated, nil)
+}
+
+// beginPasskeyLogin starts an assertion, limited to the user's passkeys when username is set.
+func beginPasskeyLogin(t *testing.T, svc *AuthService, username string) ceremony {
+	t.Helper()
+
+	var body any
+	if username != "" {
+		body = PasskeyLoginRequest{Username: username}
+	}
+
+	var begin ceremony
+	decode(t, serve(svc.BeginPasskeyLogin, "POST", "/api/auth/passkeys/login/begin", body, 0), http.StatusOK, &begin)
+	return begin
+}
+
+func finishPasskeyLogin(svc *AuthService, begin ceremony, assertion map[string]any) int {
+	return serve(svc.FinishPasskeyLogin, "POST", "/api/auth/passkeys/login/finish?session_id="+begin.SessionID, assertion, 0).Code
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	svc := newTestService(t)
+	user := createTestUser(t, svc, "alice", "correct horse")
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, svc, user.ID, a)
+
+	for _, username := range []string{"alice", ""} {
+		begin := beginPasskeyLogin(t, svc, username)
+
+		var tokens TokenResponse
+		w := serve(svc.FinishPasskeyLogin, "POST", "/api/auth/passkeys/login/finish?session_id="+begin.SessionID, a.get(begin), 0)
+		decode(t, w, http.StatusOK, &tokens)
+		if tokens.Token == "" || tokens.RefreshToken == "" {
+			t.Fatalf("login with username %q returned no tokens: %s", username, w.Body.String())
+		}
+	}
+}
+
+func TestPasskeyLoginRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, svc *AuthService, userID int, a *softAuthenticator, begin *ceremony) map[string]any
+		status int
+	}{
+		{
+			name: "forged signature",
+			tamper: func(t *testing.T, _ *AuthService, _ int, a *softAuthenticator, begin *ceremony) map[string]any {
+				other := newSoftAuthenticator(t)
+				other.id, other.handle = a.id, a.handle
+				return other.get(*begin)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong challenge",
+			tamper: func(_ *testing.T, _ *AuthService, _ int, a *softAuthenticator, begin *ceremony) map[string]any {
+				forged := *begin
+				forged.Options.PublicKey.Challenge = b64.EncodeToString([]byte("not the challenge"))
+				return a.get(forged)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "replayed counter",
+			tamper: func(_ *testing.T, _ *AuthService, _ int, a *softAuthenticator, begin *ceremony) map[string]any {
+				a.count = 0
+				return a.get(*begin)
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "disabled user",
+			tamper: func(t *testing.T, svc *AuthService, userID int, a *softAuthenticator, begin *ceremony) map[string]any {
+				if _, err := svc.db.SetUserDisabled(userID, true); err != nil {
+					t.Fatal(err)
+				}
+				return a.get(*begin)
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "password reset required",
+			tamper: func(t *testing.T, svc *AuthService, userID int, a *softAuthenticator, begin *ceremony) map[string]any {
+				if err := svc.db.RequirePasswordReset(userID); err != nil {
+					t.Fatal(err)
+				}
+				return a.get(*begin)
+			},
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(t)
+			user := createTestUser(t, svc, "alice", "correct horse")
+			a := newSoftAuthenticator(t)
+			registerPasskey(t, svc, user.ID, a)
+
+			// A first login moves the stored counter past zero, so a replayed counter is detectable
+			begin := beginPasskeyLogin(t, svc, "alice")
+			if status := finishPasskeyLogin(svc, begin, a.get(begin)); status != http.StatusOK {
+				t.Fatalf("first login: status = %d", status)
+			}
+
+			begin = beginPasskeyLogin(t, svc, "alice")
+			if status := finishPasskeyLogin(svc, begin, tt.tamper(t, svc, user.ID, a, &begin)); status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestPasskeySessionIsSingleUse(t *testing.T) {
+	svc := newTestService(t)
+	user := createTestUser(t, svc, "alice", "correct horse")
+	a := newSoftAuthenticator(t)
+	registerPasskey(t, svc, user.ID, a)
+
+	begin := beginPasskeyLogin(t, svc, "alice")
+	if status := finishPasskeyLogin(svc, begin, a.get(begin)); status != http.StatusOK {
+		t.Fatalf("first login: status = %d", status)
+	}
+	if status := finishPasskeyLogin(svc, begin, a.get(begin)); status != http.StatusBadRequest {
+		t.Fatalf("reused session: status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
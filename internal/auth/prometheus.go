@@ -25,4 +25,74 @@ var (
 		},
 		[]string{"status"},
 	)
+	logoutRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_logout_requests_total",
+			Help: "Total number of logout requests.",
+		},
+		[]string{"status"},
+	)
+	logoutAllRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_logout_all_requests_total",
+			Help: "Total number of logout-all requests.",
+		},
+		[]string{"status"},
+	)
+	twoFactorRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_two_factor_requests_total",
+			Help: "Total number of two-factor setup, verification and login requests.",
+		},
+		[]string{"status"},
+	)
+	keyRotations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_signing_key_rotations_total",
+			Help: "Total number of manual signing key rotations.",
+		},
+		[]string{"status"},
+	)
+	passkeyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_passkey_requests_total",
+			Help: "Total number of passkey registration and login requests.",
+		},
+		[]string{"status"},
+	)
+	lockoutEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_lockout_events_total",
+			Help: "Total number of login lockouts by scope (username or ip) and admin unlocks.",
+		},
+		[]string{"status"},
+	)
+	passwordRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_password_requests_total",
+			Help: "Total number of password change, reset token and reset requests.",
+		},
+		[]string{"status"},
+	)
+	keyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_registration_key_requests_total",
+			Help: "Total number of registration key creations and revocations.",
+		},
+		[]string{"status"},
+	)
+	accessTokenRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_access_token_requests_total",
+			Help: "Total number of personal access token creations, listings and revocations.",
+		},
+		[]string{"status"},
+	)
+	credentialRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_service_credential_requests_total",
+			Help: "Total number of service credential creations, listings and revocations.",
+		},
+		[]string{"status"},
+	)
 )
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/keyring"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+)
+
+// createTestAccessToken stores a personal access token of the user.
+func createTestAccessToken(t *testing.T, svc *AuthService, userID int) {
+	t.Helper()
+
+	_, err := svc.db.CreateAccessToken(db.AccessToken{
+		UserID:    userID,
+		Name:      "script",
+		TokenHash: crypto.HashToken(AccessTokenPrefix + "secret"),
+		Scopes:    []string{"pictures:read"},
+		CreatedAt: testNow,
+		ExpiresAt: testNow.Add(accessTokenTTL),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAccessTokensRevokedWithSessions(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, svc *AuthService, user *db.User) int
+	}{
+		{"logout all", func(t *testing.T, svc *AuthService, user *db.User) int {
+			return serve(svc.LogoutAll, http.MethodPost, "/api/auth/logout-all", nil, user.ID).Code
+		}},
+		{"password change", func(t *testing.T, svc *AuthService, user *db.User) int {
+			req := ChangePasswordRequest{OldPassword: testPassword, NewPassword: "another horse battery"}
+			return serve(svc.ChangePassword, http.MethodPost, "/api/auth/password", req, user.ID).Code
+		}},
+		{"password reset", func(t *testing.T, svc *AuthService, user *db.User) int {
+			if err := svc.db.CreatePasswordResetToken(user.ID, crypto.HashToken("reset"), testNow, testNow.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			req := ResetPasswordRequest{Token: "reset", NewPassword: "another horse battery"}
+			return serve(svc.ResetPassword, http.MethodPost, "/api/auth/password/reset", req, 0).Code
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(t)
+			user := createTestUser(t, svc, "victor", testPassword)
+			createTestAccessToken(t, svc, user.ID)
+
+			if status := tt.revoke(t, svc, user); status != http.StatusOK {
+				t.Fatalf("status = %d, want %d", status, http.StatusOK)
+			}
+
+			tokens, err := svc.db.GetAccessTokensByUser(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tokens) != 0 {
+				t.Fatalf("%d access tokens survived", len(tokens))
+			}
+		})
+	}
+}
+
+func TestAdminKeyRefusedToAccessTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		scopes []string
+		status int
+	}{
+		{"admin key with a login session", db.RoleAdmin, nil, http.StatusOK},
+		{"member key with an access token", db.RoleMember, []string{"keys:admin"}, http.StatusOK},
+		{"admin key with an access token", db.RoleAdmin, []string{"keys:admin"}, http.StatusForbidden},
+	}
+
+	svc := newTestService(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			json.NewEncoder(&buf).Encode(AddKeyRequest{Role: tt.role})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/auth/keys", &buf)
+			c.Set("username", "victor")
+			if tt.scopes != nil {
+				c.Set("scopes", tt.scopes)
+			}
+
+			svc.AddKey(c)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWKSRevalidatedAfterRotation(t *testing.T) {
+	svc := newTestService(t)
+	keys, err := keyring.New(svc.db, keyring.Config{Algorithm: keyring.AlgorithmEdDSA, GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Load(); err != nil {
+		t.Fatal(err)
+	}
+	svc.keyring = keys
+
+	fetch := func(etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+		if etag != "" {
+			c.Request.Header.Set("If-None-Match", etag)
+		}
+		svc.JWKS(c)
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+
+	var set keyring.JWKSet
+	first := fetch("")
+	decode(t, first, http.StatusOK, &set)
+	if len(set.Keys) != 1 {
+		t.Fatalf("published %d keys, want 1", len(set.Keys))
+	}
+	if got := first.Header().Get("Cache-Control"); strings.Contains(got, "max-age") {
+		t.Fatalf("Cache-Control = %q, lets verifiers keep a set that misses a rotated key", got)
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag to revalidate with")
+	}
+
+	if w := fetch(etag); w.Code != http.StatusNotModified {
+		t.Fatalf("unchanged set: status = %d, want 304", w.Code)
+	}
+
+	// Once rotated, the new key signs tokens straight away and must reach verifiers
+	if _, err := keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	rotated := fetch(etag)
+	decode(t, rotated, http.StatusOK, &set)
+	if len(set.Keys) != 2 || rotated.Header().Get("ETag") == etag {
+		t.Fatalf("after rotation got %d keys with ETag %s, want both keys under a new ETag", len(set.Keys), rotated.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/totp"
+)
+
+const testPassword = "correct horse battery"
+
+// enrollTwoFactor creates a user with two-factor authentication confirmed at testNow and
+// returns their TOTP secret and recovery codes.
+func enrollTwoFactor(t *testing.T, svc *AuthService, username string) (string, []string) {
+	t.Helper()
+
+	user := createTestUser(t, svc, username, testPassword)
+
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, serve(svc.SetupTwoFactor, http.MethodPost, "/api/auth/2fa/setup", nil, user.ID), http.StatusOK, &setup)
+
+	var verified struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, serve(svc.VerifyTwoFactor, http.MethodPost, "/api/auth/2fa/verify", TwoFactorCodeRequest{Code: totpCode(t, setup.Secret, testNow)}, user.ID), http.StatusOK, &verified)
+
+	return setup.Secret, verified.RecoveryCodes
+}
+
+// totpCode returns the TOTP code of the secret at the given time.
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// loginWithCode completes a password and second factor login at the given time and returns the
+// status of the second step.
+func loginWithCode(t *testing.T, svc *AuthService, username, code string, at time.Time) int {
+	t.Helper()
+	svc.clock = clock.Fixed(at)
+
+	var challenge struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+	decode(t, serve(svc.Login, http.MethodPost, "/api/auth/login", LoginRequest{Username: username, Password: testPassword}, 0), http.StatusOK, &challenge)
+	if !challenge.MFARequired {
+		t.Fatal("login did not require a second factor")
+	}
+
+	return serve(svc.LoginMFA, http.MethodPost, "/api/auth/login/2fa", LoginMFARequest{MFAToken: challenge.MFAToken, Code: code}, 0).Code
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	svc := newTestService(t)
+	secret, _ := enrollTwoFactor(t, svc, "victor")
+
+	at := testNow.Add(totp.Period)
+	if status := loginWithCode(t, svc, "victor", totpCode(t, secret, at), at); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestTwoFactorStepReplay(t *testing.T) {
+	svc := newTestService(t)
+	secret, _ := enrollTwoFactor(t, svc, "victor")
+
+	next := testNow.Add(totp.Period)
+	tests := []struct {
+		name   string
+		code   string
+		at     time.Time
+		status int
+	}{
+		// The step that confirmed enrollment is already used
+		{"enrollment code", totpCode(t, secret, testNow), testNow, http.StatusUnauthorized},
+		{"next step", totpCode(t, secret, next), next, http.StatusOK},
+		{"same step again", totpCode(t, secret, next), next, http.StatusUnauthorized},
+		// Still within the window, but older than the last accepted step
+		{"earlier step", totpCode(t, secret, testNow), next, http.StatusUnauthorized},
+		{"later step", totpCode(t, secret, next.Add(totp.Period)), next.Add(totp.Period), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if status := loginWithCode(t, svc, "victor", tt.code, tt.at); status != tt.status {
+			t.Fatalf("%s: status = %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
+
+func TestTwoFactorWindow(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration
+		status int
+	}{
+		{"previous step", -totp.Period, http.StatusOK},
+		{"next step", totp.Period, http.StatusOK},
+		{"two steps behind", -2 * totp.Period, http.StatusUnauthorized},
+		{"two steps ahead", 2 * totp.Period, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newTestService(t)
+			secret, _ := enrollTwoFactor(t, svc, "victor")
+
+			// Log in a few steps after enrollment, so no step in the window has been used
+			at := testNow.Add(5 * totp.Period)
+			if status := loginWithCode(t, svc, "victor", totpCode(t, secret, at.Add(tt.offset)), at); status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	svc := newTestService(t)
+	_, codes := enrollTwoFactor(t, svc, "victor")
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	if status := loginWithCode(t, svc, "victor", codes[0], testNow); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	if status := loginWithCode(t, svc, "victor", codes[0], testNow); status != http.StatusUnauthorized {
+		t.Fatalf("reused recovery code: status = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	// Codes can be typed without the separator and in upper case
+	typed := strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))
+	if status := loginWithCode(t, svc, "victor", typed, testNow); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestRecoveryCodesBelongToTheirUser(t *testing.T) {
+	svc := newTestService(t)
+	_, codes := enrollTwoFactor(t, svc, "victor")
+	enrollTwoFactor(t, svc, "ana")
+
+	if status := loginWithCode(t, svc, "ana", codes[0], testNow); status != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status := loginWithCode(t, svc, "victor", codes[0], testNow); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+}
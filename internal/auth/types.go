@@ -1,18 +1,35 @@
 package auth
 
 import (
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
+	"github.com/VicSobDev/anniversaryAPI/internal/credentials"
+	"github.com/VicSobDev/anniversaryAPI/internal/keyring"
+	"github.com/VicSobDev/anniversaryAPI/internal/lockout"
+	"github.com/VicSobDev/anniversaryAPI/internal/revocation"
+	"github.com/VicSobDev/anniversaryAPI/internal/token"
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
 	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
 	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
 type AuthService struct {
-	logger zap.Logger
-	db     *db.SQLiteDB
-	argon  *crypto.Argon2
-	apiKey string
-	jwtKey []byte
+	logger      zap.Logger
+	db          *db.SQLiteDB
+	hasher      crypto.PasswordHasher
+	revocations *revocation.Store
+	guard       *lockout.Guard
+	credentials *credentials.Store
+	audit       *audit.Recorder
+	keyring     *keyring.Keyring
+	tokens      *token.Manager
+	cipher      *crypto.AESGCM
+	webauthn    *webauthn.WebAuthn
+	clock       clock.Clock
 }
 
 type LoginRequest struct {
@@ -25,12 +42,93 @@ type RegisterRequest struct {
 	Key      string `json:"key"`
 }
 
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type PasskeyLoginRequest struct {
+	// Username is optional; without it the authenticator picks a discoverable credential
+	Username string `json:"username"`
+}
+
+// passkeyUser adapts a user and their registered credentials to webauthn.User
+type passkeyUser struct {
+	user        *db.User
+	credentials []webauthn.Credential
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type CreateAccessTokenRequest struct {
+	Name string `json:"name"`
+	// ExpiresAt defaults to 90 days from now
+	ExpiresAt *time.Time `json:"expires_at"`
+	Scopes    []string   `json:"scopes"`
+}
+
+type CreateServiceCredentialRequest struct {
+	Name string `json:"name"`
+	// Purpose is admin or metrics
+	Purpose   string     `json:"purpose"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
 type AddKeyRequest struct {
-	Key string `json:"key"`
+	// Key is generated when empty
+	Key       string     `json:"key"`
+	Label     string     `json:"label"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// MaxUses defaults to a single use
+	MaxUses int `json:"max_uses"`
+	// Role defaults to member
+	Role string `json:"role"`
 }
 
-func NewAuthService(logger *zap.Logger, db *db.SQLiteDB, argon *crypto.Argon2, jwtKey []byte, apiKey string) *AuthService {
+func NewAuthService(logger *zap.Logger, db *db.SQLiteDB, hasher crypto.PasswordHasher, revocations *revocation.Store, guard *lockout.Guard, credentials *credentials.Store, recorder *audit.Recorder, keyring *keyring.Keyring, tokens *token.Manager, cipher *crypto.AESGCM, webAuthn *webauthn.WebAuthn, clock clock.Clock) *AuthService {
 	prometheus.MustRegister(loginAttempts)
 	prometheus.MustRegister(registerAttempts)
-	return &AuthService{logger: *logger, db: db, argon: argon, jwtKey: jwtKey, apiKey: apiKey}
+	prometheus.MustRegister(refreshAttempts)
+	prometheus.MustRegister(logoutRequests)
+	prometheus.MustRegister(logoutAllRequests)
+	prometheus.MustRegister(keyRotations)
+	prometheus.MustRegister(twoFactorRequests)
+	prometheus.MustRegister(passkeyRequests)
+	prometheus.MustRegister(lockoutEvents)
+	prometheus.MustRegister(passwordRequests)
+	prometheus.MustRegister(keyRequests)
+	prometheus.MustRegister(accessTokenRequests)
+	prometheus.MustRegister(credentialRequests)
+	return &AuthService{logger: *logger, db: db, hasher: hasher, revocations: revocations, guard: guard, credentials: credentials, audit: recorder, keyring: keyring, tokens: tokens, cipher: cipher, webauthn: webAuthn, clock: clock}
 }
@@ -0,0 +1,181 @@
+package credentials
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+)
+
+// Purposes a service credential can be issued for
+const (
+	PurposeAdmin   = "admin"
+	PurposeMetrics = "metrics"
+)
+
+// Credentials synced from the API_KEY and PROMETHEUS_KEY environment variables. Names
+// with ReservedPrefix cannot be created through the API.
+const (
+	ReservedPrefix       = "env:"
+	EnvAdminCredential   = ReservedPrefix + "API_KEY"
+	EnvMetricsCredential = ReservedPrefix + "PROMETHEUS_KEY"
+)
+
+var (
+	ErrInvalidCredential = errors.New("invalid service credential")
+	ErrInvalidPurpose    = errors.New("purpose must be admin or metrics")
+)
+
+// ValidPurpose reports whether purpose is a known credential purpose.
+func ValidPurpose(purpose string) bool {
+	return purpose == PurposeAdmin || purpose == PurposeMetrics
+}
+
+// Store verifies service credentials. Credentials are persisted as hashes in SQLite and
+// mirrored in memory, so verification compares against every known hash in constant time
+// without a query per request.
+type Store struct {
+	mx          sync.RWMutex
+	credentials []db.ServiceCredential
+	db          *db.SQLiteDB
+	clock       clock.Clock
+}
+
+// NewStore creates a Store backed by sqliteDB.
+func NewStore(sqliteDB *db.SQLiteDB, clock clock.Clock) *Store {
+	return &Store{db: sqliteDB, clock: clock}
+}
+
+// Load fills the in-memory cache with the credentials stored in the database.
+func (s *Store) Load() error {
+	credentials, err := s.db.GetServiceCredentials()
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.credentials = credentials
+	return nil
+}
+
+// Create generates a new key for purpose and stores its hash under name, replacing any
+// credential with the same name. The key is returned once and cannot be recovered.
+func (s *Store) Create(name, purpose string, expiresAt *time.Time) (string, db.ServiceCredential, error) {
+	key, err := crypto.GenerateToken()
+	if err != nil {
+		return "", db.ServiceCredential{}, err
+	}
+
+	credential, err := s.Set(name, purpose, key, expiresAt)
+	return key, credential, err
+}
+
+// Set stores key under name for purpose, replacing any credential with the same name.
+func (s *Store) Set(name, purpose, key string, expiresAt *time.Time) (db.ServiceCredential, error) {
+	if !ValidPurpose(purpose) {
+		return db.ServiceCredential{}, ErrInvalidPurpose
+	}
+
+	credential, err := s.db.CreateServiceCredential(db.ServiceCredential{
+		Name:      name,
+		Purpose:   purpose,
+		KeyHash:   crypto.HashToken(key),
+		CreatedAt: s.clock.Now(),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return credential, err
+	}
+
+	return credential, s.Load()
+}
+
+// Sync keeps the credential called name in line with a key supplied by configuration.
+// An unchanged key keeps its usage history and an empty key deletes the credential.
+func (s *Store) Sync(name, purpose, key string) error {
+	if key == "" {
+		return s.DeleteByName(name)
+	}
+
+	for _, credential := range s.List() {
+		if credential.Name == name && credential.Purpose == purpose && credential.KeyHash == crypto.HashToken(key) {
+			return nil
+		}
+	}
+
+	_, err := s.Set(name, purpose, key, nil)
+	return err
+}
+
+// List returns every stored credential.
+func (s *Store) List() []db.ServiceCredential {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return append([]db.ServiceCredential{}, s.credentials...)
+}
+
+// Delete revokes the credential with id and reports whether it existed.
+func (s *Store) Delete(id int) (bool, error) {
+	deleted, err := s.db.DeleteServiceCredential(id)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	return true, s.Load()
+}
+
+// DeleteByName revokes the credential called name, if any.
+func (s *Store) DeleteByName(name string) error {
+	if err := s.db.DeleteServiceCredentialByName(name); err != nil {
+		return err
+	}
+	return s.Load()
+}
+
+// Verify returns the unexpired credential for purpose matching key and records its use.
+// Every stored hash is compared so the time taken does not depend on which key matched.
+func (s *Store) Verify(purpose, key string) (db.ServiceCredential, error) {
+	if key == "" {
+		return db.ServiceCredential{}, ErrInvalidCredential
+	}
+
+	now := s.clock.Now()
+	hash := []byte(crypto.HashToken(key))
+
+	s.mx.RLock()
+	match := -1
+	for i, credential := range s.credentials {
+		if subtle.ConstantTimeCompare(hash, []byte(credential.KeyHash)) == 1 {
+			match = i
+		}
+	}
+
+	var credential db.ServiceCredential
+	if match >= 0 {
+		credential = s.credentials[match]
+	}
+	s.mx.RUnlock()
+
+	if match < 0 || credential.Purpose != purpose || (credential.ExpiresAt != nil && !credential.ExpiresAt.After(now)) {
+		return db.ServiceCredential{}, ErrInvalidCredential
+	}
+
+	if err := s.db.UpdateServiceCredentialUsage(credential.ID, now); err != nil {
+		return db.ServiceCredential{}, err
+	}
+
+	s.mx.Lock()
+	for i := range s.credentials {
+		if s.credentials[i].ID == credential.ID {
+			s.credentials[i].LastUsedAt = &now
+		}
+	}
+	s.mx.Unlock()
+
+	credential.LastUsedAt = &now
+	return credential, nil
+}
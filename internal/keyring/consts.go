@@ -0,0 +1,19 @@
+package keyring
+
+import "errors"
+
+// Supported signing algorithms.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+)
+
+var (
+	errUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	errUnknownKey           = errors.New("unknown signing key")
+	errMissingKeyID         = errors.New("token has no kid header")
+	errAlgorithmMismatch    = errors.New("token algorithm does not match key")
+	errNoSigningKey         = errors.New("no active signing key")
+)
@@ -0,0 +1,39 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// toJWK converts the public half of an asymmetric key to a JWK.
+func toJWK(key *Key) (JWK, bool) {
+	jwk := JWK{Kid: key.ID, Alg: key.Algorithm, Use: "sig"}
+
+	switch pub := key.verifyKey.(type) {
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encode(pub)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encode(pub.N.Bytes())
+		jwk.E = encode(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = encode(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = encode(pub.Y.FillBytes(make([]byte, size)))
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,307 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// New creates a Keyring backed by sqliteDB. Call Load before using it.
+func New(sqliteDB *db.SQLiteDB, config Config) (*Keyring, error) {
+	if config.Algorithm == "" {
+		config.Algorithm = AlgorithmHS256
+	}
+	if signingMethod(config.Algorithm) == nil {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedAlgorithm, config.Algorithm)
+	}
+
+	return &Keyring{config: config, db: sqliteDB}, nil
+}
+
+// Load reads the stored keys and makes sure an active key of the configured algorithm exists.
+func (k *Keyring) Load() error {
+	if err := k.reload(); err != nil {
+		return err
+	}
+
+	if active := k.activeKey(); active != nil && active.Algorithm == k.config.Algorithm {
+		return nil
+	}
+
+	// No active key, or the configured algorithm changed: start a new key
+	_, err := k.Rotate()
+	return err
+}
+
+// Rotate generates a new active key and retires the previous ones, which keep
+// verifying tokens until the grace period has passed.
+func (k *Keyring) Rotate() (*Key, error) {
+	now := time.Now()
+
+	privateKey, err := generatePrivateKey(k.config.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := db.SigningKey{
+		ID:         uuid.NewString(),
+		Algorithm:  k.config.Algorithm,
+		PrivateKey: privateKey,
+		CreatedAt:  now,
+	}
+
+	if err := k.db.CreateSigningKey(stored); err != nil {
+		return nil, err
+	}
+
+	if err := k.db.RetireSigningKeys(stored.ID, now); err != nil {
+		return nil, err
+	}
+
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+
+	return k.lookup(stored.ID), nil
+}
+
+// Prune deletes keys whose grace period has ended.
+func (k *Keyring) Prune(now time.Time) error {
+	if err := k.db.DeleteSigningKeysRetiredBefore(now.Add(-k.config.GracePeriod)); err != nil {
+		return err
+	}
+	return k.reload()
+}
+
+// StartRotation rotates the active key once it is older than the rotation interval
+// and prunes expired keys, checking every interval in a background goroutine.
+func (k *Keyring) StartRotation(interval time.Duration, logger *zap.Logger) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if active := k.activeKey(); k.config.RotationInterval > 0 && (active == nil || now.Sub(active.CreatedAt) >= k.config.RotationInterval) {
+				key, err := k.Rotate()
+				if err != nil {
+					logger.Error("failed to rotate signing key", zap.Error(err))
+				} else {
+					logger.Info("signing key rotated", zap.String("kid", key.ID), zap.String("algorithm", key.Algorithm))
+				}
+			}
+
+			if err := k.Prune(now); err != nil {
+				logger.Error("failed to prune signing keys", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// Sign signs the claims with the active key, setting the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key := k.activeKey()
+	if key == nil {
+		return "", errNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signingKey)
+}
+
+// Keyfunc resolves the verification key for a token from its kid header. Tokens
+// without a kid predate the keyring and are rejected.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errMissingKeyID
+	}
+
+	key := k.verificationKey(kid)
+	if key == nil {
+		return nil, errUnknownKey
+	}
+
+	// Never let the token choose a different algorithm than the key was made for
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("%w: %v", errAlgorithmMismatch, token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// Algorithms returns the signing algorithms the keyring can produce and verify.
+func Algorithms() []string {
+	return []string{AlgorithmHS256, AlgorithmEdDSA, AlgorithmRS256, AlgorithmES256}
+}
+
+// JWKS returns the public keys currently accepted for verification. HMAC keys are
+// secret and never published.
+func (k *Keyring) JWKS() JWKSet {
+	k.mx.RLock()
+	defer k.mx.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range k.keys {
+		if !k.acceptsAt(key, now) {
+			continue
+		}
+		if jwk, ok := toJWK(key); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// reload replaces the in-memory keys with the ones stored in the database.
+func (k *Keyring) reload() error {
+	stored, err := k.db.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*Key, 0, len(stored))
+	for _, s := range stored {
+		key, err := parseKey(s)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key %s: %w", s.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	k.keys = keys
+	return nil
+}
+
+// activeKey returns the newest key that has not been retired.
+func (k *Keyring) activeKey() *Key {
+	k.mx.RLock()
+	defer k.mx.RUnlock()
+
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if k.keys[i].RetiredAt == nil {
+			return k.keys[i]
+		}
+	}
+	return nil
+}
+
+// lookup returns the key with the given ID regardless of its state.
+func (k *Keyring) lookup(kid string) *Key {
+	k.mx.RLock()
+	defer k.mx.RUnlock()
+
+	for _, key := range k.keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// verificationKey returns the key with the given ID if it is still accepted.
+func (k *Keyring) verificationKey(kid string) *Key {
+	key := k.lookup(kid)
+	if key == nil || !k.acceptsAt(key, time.Now()) {
+		return nil
+	}
+	return key
+}
+
+// acceptsAt reports whether the key verifies tokens at the given time.
+func (k *Keyring) acceptsAt(key *Key, now time.Time) bool {
+	return key.RetiredAt == nil || now.Before(key.RetiredAt.Add(k.config.GracePeriod))
+}
+
+// signingMethod maps an algorithm name to its jwt-go implementation.
+func signingMethod(algorithm string) jwt.SigningMethod {
+	switch algorithm {
+	case AlgorithmHS256:
+		return jwt.SigningMethodHS256
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256
+	case AlgorithmES256:
+		return jwt.SigningMethodES256
+	}
+	return nil
+}
+
+// generatePrivateKey creates new key material for the algorithm, encoded for storage.
+func generatePrivateKey(algorithm string) ([]byte, error) {
+	var privateKey interface{}
+	var err error
+
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := make([]byte, 64)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return secret, nil
+	case AlgorithmEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case AlgorithmRS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedAlgorithm, algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key: %w", algorithm, err)
+	}
+
+	return x509.MarshalPKCS8PrivateKey(privateKey)
+}
+
+// parseKey decodes a stored key into its signing and verification material.
+func parseKey(stored db.SigningKey) (*Key, error) {
+	key := &Key{
+		ID:        stored.ID,
+		Algorithm: stored.Algorithm,
+		CreatedAt: stored.CreatedAt,
+		RetiredAt: stored.RetiredAt,
+	}
+
+	if stored.Algorithm == AlgorithmHS256 {
+		key.signingKey = stored.PrivateKey
+		key.verifyKey = stored.PrivateKey
+		return key, nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(stored.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pk := privateKey.(type) {
+	case ed25519.PrivateKey:
+		key.signingKey, key.verifyKey = pk, pk.Public()
+	case *rsa.PrivateKey:
+		key.signingKey, key.verifyKey = pk, &pk.PublicKey
+	case *ecdsa.PrivateKey:
+		key.signingKey, key.verifyKey = pk, &pk.PublicKey
+	default:
+		return nil, fmt.Errorf("%w: %T", errUnsupportedAlgorithm, privateKey)
+	}
+
+	if signingMethod(stored.Algorithm) == nil {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedAlgorithm, stored.Algorithm)
+	}
+	return key, nil
+}
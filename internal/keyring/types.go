@@ -0,0 +1,55 @@
+package keyring
+
+import (
+	"sync"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+)
+
+// Config controls which algorithm new keys use and how often they are rotated.
+type Config struct {
+	// Algorithm used for newly generated keys (HS256, EdDSA, RS256 or ES256).
+	Algorithm string
+	// RotationInterval is the age at which the active key is replaced; zero disables rotation.
+	RotationInterval time.Duration
+	// GracePeriod is how long a retired key keeps verifying tokens. It should be at
+	// least as long as the access token lifetime.
+	GracePeriod time.Duration
+}
+
+// Key is a signing key together with the material needed to verify its signatures.
+type Key struct {
+	ID         string
+	Algorithm  string
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+	signingKey interface{}
+	verifyKey  interface{}
+}
+
+// Keyring holds the active signing key and every key still accepted for verification.
+type Keyring struct {
+	mx     sync.RWMutex
+	keys   []*Key
+	config Config
+	db     *db.SQLiteDB
+}
+
+// JWK is a JSON Web Key describing a public verification key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
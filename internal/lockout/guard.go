@@ -0,0 +1,192 @@
+package lockout
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"go.uber.org/zap"
+)
+
+// Scopes of tracked login failures
+const (
+	ScopeUsername = "username"
+	ScopeIP       = "ip"
+)
+
+// Policy configures how failed logins for one scope are throttled.
+type Policy struct {
+	// FreeAttempts is the number of failures allowed before backoff starts
+	FreeAttempts int
+	// Threshold is the number of failures that locks the identifier out
+	Threshold int
+	// LockoutDuration is how long a lockout lasts
+	LockoutDuration time.Duration
+	// BaseDelay is the first backoff delay, doubled on every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long a failure is remembered; the count starts over after a quiet window
+	Window time.Duration
+}
+
+// DefaultPolicies lock a username out well before an IP, since one address may serve many users.
+var DefaultPolicies = map[string]Policy{
+	ScopeUsername: {FreeAttempts: 3, Threshold: 10, LockoutDuration: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour},
+	ScopeIP:       {FreeAttempts: 20, Threshold: 100, LockoutDuration: time.Hour, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour},
+}
+
+// Outcome describes the effect of a recorded failure.
+type Outcome struct {
+	// RetryAfter is how long the caller must wait before trying again
+	RetryAfter time.Duration
+	// Locked lists the scopes this failure locked out
+	Locked []string
+}
+
+// Guard tracks failed logins per username and per client IP in SQLite, throttling
+// repeated failures with exponential backoff and locking out persistent ones.
+type Guard struct {
+	db       *db.SQLiteDB
+	clock    clock.Clock
+	policies map[string]Policy
+}
+
+// NewGuard creates a Guard backed by sqliteDB using the given policies per scope.
+func NewGuard(sqliteDB *db.SQLiteDB, clock clock.Clock, policies map[string]Policy) *Guard {
+	return &Guard{db: sqliteDB, clock: clock, policies: policies}
+}
+
+// Check returns how long logins for username from ip are blocked, or zero when they are allowed.
+func (g *Guard) Check(username, ip string) (time.Duration, error) {
+	now := g.clock.Now()
+
+	var wait time.Duration
+	for scope, identifier := range identifiers(username, ip) {
+		failure, err := g.db.GetLoginFailure(scope, identifier)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return 0, err
+		}
+
+		if failure.BlockedUntil != nil && failure.BlockedUntil.Sub(now) > wait {
+			wait = failure.BlockedUntil.Sub(now)
+		}
+	}
+
+	return wait, nil
+}
+
+// Fail records a failed login for username from ip and blocks either of them once they exceed their policy.
+func (g *Guard) Fail(username, ip string) (Outcome, error) {
+	now := g.clock.Now()
+
+	var outcome Outcome
+	for scope, identifier := range identifiers(username, ip) {
+		policy := g.policies[scope]
+
+		failures, err := g.db.RecordLoginFailure(scope, identifier, now, now.Add(-policy.Window))
+		if err != nil {
+			return outcome, err
+		}
+
+		delay := policy.delay(failures)
+		if delay == 0 {
+			continue
+		}
+
+		if err := g.db.SetLoginBlockedUntil(scope, identifier, now.Add(delay)); err != nil {
+			return outcome, err
+		}
+
+		if failures >= policy.Threshold {
+			outcome.Locked = append(outcome.Locked, scope)
+		}
+		if delay > outcome.RetryAfter {
+			outcome.RetryAfter = delay
+		}
+	}
+
+	return outcome, nil
+}
+
+// Succeed clears the failures of username after a successful login. The IP keeps its
+// count so a valid account cannot be used to reset an attacker's address.
+func (g *Guard) Succeed(username string) error {
+	_, err := g.db.DeleteLoginFailure(ScopeUsername, normalize(username))
+	return err
+}
+
+// Unlock clears the failures and any block of an identifier, reporting whether one was recorded.
+func (g *Guard) Unlock(scope, identifier string) (bool, error) {
+	if scope == ScopeUsername {
+		identifier = normalize(identifier)
+	}
+	return g.db.DeleteLoginFailure(scope, identifier)
+}
+
+// Blocked lists the identifiers that are currently throttled or locked out.
+func (g *Guard) Blocked() ([]db.LoginFailure, error) {
+	return g.db.GetBlockedLogins(g.clock.Now())
+}
+
+// Prune forgets failures that are outside every policy window and no longer blocked.
+func (g *Guard) Prune(now time.Time) error {
+	var window time.Duration
+	for _, policy := range g.policies {
+		if policy.Window > window {
+			window = policy.Window
+		}
+	}
+	return g.db.DeleteStaleLoginFailures(now.Add(-window), now)
+}
+
+// StartPruning prunes stale failures every interval in a background goroutine.
+func (g *Guard) StartPruning(interval time.Duration, logger *zap.Logger) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if err := g.Prune(now); err != nil {
+				logger.Error("failed to prune login failures", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// delay returns how long to block after the given number of consecutive failures.
+func (p Policy) delay(failures int) time.Duration {
+	if failures >= p.Threshold {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay << (failures - p.FreeAttempts - 1)
+	if delay > p.MaxDelay || delay <= 0 {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// identifiers returns the tracked identifier per scope, skipping empty ones.
+func identifiers(username, ip string) map[string]string {
+	ids := make(map[string]string, 2)
+	if username = normalize(username); username != "" {
+		ids[ScopeUsername] = username
+	}
+	if ip != "" {
+		ids[ScopeIP] = ip
+	}
+	return ids
+}
+
+// normalize matches usernames the way login does
+func normalize(username string) string {
+	return strings.ToLower(username)
+}
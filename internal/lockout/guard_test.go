@@ -0,0 +1,178 @@
+package lockout
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+)
+
+var testNow = time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// testPolicies throttle usernames after one failure and IPs after two, so both scopes can be
+// told apart in a few attempts.
+var testPolicies = map[string]Policy{
+	ScopeUsername: {FreeAttempts: 1, Threshold: 5, LockoutDuration: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour},
+	ScopeIP:       {FreeAttempts: 2, Threshold: 8, LockoutDuration: time.Hour, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour},
+}
+
+// newTestGuard returns a Guard backed by a fresh database whose clock reads testNow.
+func newTestGuard(t *testing.T) *Guard {
+	t.Helper()
+
+	sqliteDB, err := db.NewSQLiteDB(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqliteDB.Close() })
+	if err := sqliteDB.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewGuard(sqliteDB, clock.Fixed(testNow), testPolicies)
+}
+
+// fail records a failure and fails the test unless it succeeds.
+func fail(t *testing.T, g *Guard, username, ip string) Outcome {
+	t.Helper()
+
+	outcome, err := g.Fail(username, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outcome
+}
+
+// check returns how long logins are blocked and fails the test on errors.
+func check(t *testing.T, g *Guard, username, ip string) time.Duration {
+	t.Helper()
+
+	wait, err := g.Check(username, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wait
+}
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{FreeAttempts: 3, Threshold: 10, LockoutDuration: 15 * time.Minute, BaseDelay: time.Second, MaxDelay: 20 * time.Second}
+	unbounded := Policy{FreeAttempts: 0, Threshold: 1000, LockoutDuration: time.Hour, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures int
+		want     time.Duration
+	}{
+		{"no failures", policy, 0, 0},
+		{"last free attempt", policy, 3, 0},
+		{"first delay", policy, 4, time.Second},
+		{"doubled", policy, 5, 2 * time.Second},
+		{"doubled again", policy, 7, 8 * time.Second},
+		{"capped", policy, 9, 20 * time.Second},
+		{"threshold", policy, 10, 15 * time.Minute},
+		{"past threshold", policy, 25, 15 * time.Minute},
+		{"negative after overflow", unbounded, 35, time.Minute},
+		{"zero after overflow", unbounded, 100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.delay(tt.failures); got != tt.want {
+			t.Errorf("%s: delay(%d) = %v, want %v", tt.name, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestFailThrottlesAndLocks(t *testing.T) {
+	g := newTestGuard(t)
+
+	// The first failure is free
+	if outcome := fail(t, g, "victor", "192.0.2.1"); outcome.RetryAfter != 0 || len(outcome.Locked) != 0 {
+		t.Fatalf("first failure = %+v, want no delay", outcome)
+	}
+	if wait := check(t, g, "victor", "192.0.2.1"); wait != 0 {
+		t.Fatalf("Check after a free failure = %v, want 0", wait)
+	}
+
+	// The second one backs off the username, which is checked regardless of case or address
+	if outcome := fail(t, g, "victor", "192.0.2.1"); outcome.RetryAfter != time.Second {
+		t.Fatalf("second failure = %+v, want a 1s delay", outcome)
+	}
+	if wait := check(t, g, "Victor", "198.51.100.7"); wait != time.Second {
+		t.Fatalf("Check of the backed off username = %v, want 1s", wait)
+	}
+
+	// The block runs out with time
+	g.clock = clock.Fixed(testNow.Add(time.Second))
+	if wait := check(t, g, "victor", "192.0.2.1"); wait != 0 {
+		t.Fatalf("Check after the delay = %v, want 0", wait)
+	}
+
+	// Reaching the threshold locks the username out, reported once per locked scope
+	var outcome Outcome
+	for i := 0; i < 3; i++ {
+		outcome = fail(t, g, "victor", "192.0.2.1")
+	}
+	if outcome.RetryAfter != 15*time.Minute || len(outcome.Locked) != 1 || outcome.Locked[0] != ScopeUsername {
+		t.Fatalf("failure at the threshold = %+v, want the username locked for 15m", outcome)
+	}
+	if wait := check(t, g, "victor", "203.0.113.9"); wait != 15*time.Minute {
+		t.Fatalf("Check of the locked username = %v, want 15m", wait)
+	}
+}
+
+func TestSucceedKeepsIPFailures(t *testing.T) {
+	g := newTestGuard(t)
+
+	for i := 0; i < 3; i++ {
+		fail(t, g, "victor", "192.0.2.1")
+	}
+
+	// A successful login clears the username, whatever its case
+	if err := g.Succeed("Victor"); err != nil {
+		t.Fatal(err)
+	}
+	if wait := check(t, g, "victor", "198.51.100.7"); wait != 0 {
+		t.Fatalf("Check of the username after success = %v, want 0", wait)
+	}
+
+	// The address is still blocked, and its count carries on rather than starting over
+	if wait := check(t, g, "ana", "192.0.2.1"); wait != time.Second {
+		t.Fatalf("Check of the address after success = %v, want 1s", wait)
+	}
+	if outcome := fail(t, g, "ana", "192.0.2.1"); outcome.RetryAfter != 2*time.Second {
+		t.Fatalf("next failure from the address = %+v, want a 2s delay", outcome)
+	}
+}
+
+func TestFailuresForgottenAfterWindow(t *testing.T) {
+	g := newTestGuard(t)
+
+	fail(t, g, "victor", "192.0.2.1")
+	fail(t, g, "victor", "192.0.2.1")
+
+	// After a quiet window the count starts over, so the next failure is free again
+	g.clock = clock.Fixed(testNow.Add(testPolicies[ScopeUsername].Window + time.Second))
+	if outcome := fail(t, g, "victor", "192.0.2.1"); outcome.RetryAfter != 0 {
+		t.Fatalf("failure after the window = %+v, want no delay", outcome)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	g := newTestGuard(t)
+
+	for i := 0; i < 5; i++ {
+		fail(t, g, "victor", "192.0.2.1")
+	}
+
+	if unlocked, err := g.Unlock(ScopeUsername, "VICTOR"); err != nil || !unlocked {
+		t.Fatalf("Unlock = %v, %v, want true", unlocked, err)
+	}
+	if wait := check(t, g, "victor", ""); wait != 0 {
+		t.Fatalf("Check after unlock = %v, want 0", wait)
+	}
+	if unlocked, err := g.Unlock(ScopeUsername, "victor"); err != nil || unlocked {
+		t.Fatalf("second Unlock = %v, %v, want false", unlocked, err)
+	}
+}
@@ -1,7 +1,12 @@
 package pictures
 
-const (
-	anniversaryDay = 13
-	valentineDay   = 14
-	valentineMonth = 2
+import "errors"
+
+// multipartOverhead allows for the part headers and boundaries of an upload request on top of its files
+const multipartOverhead = 1 << 20
+
+var (
+	errInvalidSize       = errors.New("size must be thumbnail, medium, large or original")
+	errInvalidSort       = errors.New("sort must be uploaded or taken, and order asc or desc")
+	errInvalidTakenRange = errors.New("taken_after and taken_before must be RFC 3339 times")
 )
@@ -1,13 +1,17 @@
 package pictures
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
 
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -23,8 +27,16 @@ func (svc *PicturesService) GetPictures(c *gin.Context) {
 	// Log the details of the pagination request
 	svc.logger.Info("getting pictures", zap.Int("limit", pagination.limit), zap.Int("offset", pagination.offset))
 
+	// Parse the sort order and filters
+	query, err := parseImageQuery(c)
+	if err != nil {
+		getPicturesRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Retrieve a paginated list of images from the database
-	images, err := svc.SQLiteDB.GetImagesPaginated(pagination.limit, pagination.offset)
+	images, err := svc.SQLiteDB.GetImagesPaginated(pagination.limit, pagination.offset, query)
 	if err != nil {
 		// Log the error and respond with an internal server error if the database query fails
 		svc.ErrorHandler(getPicturesRequests, err, zap.String("error", "failed to get pictures"))
@@ -32,6 +44,20 @@ func (svc *PicturesService) GetPictures(c *gin.Context) {
 		return
 	}
 
+	if images == nil {
+		images = []db.Image{}
+	}
+
+	// Include the resized variants so clients can pick the smallest suitable file
+	if err := svc.SQLiteDB.AttachImageVariants(images); err != nil {
+		svc.ErrorHandler(getPicturesRequests, err, zap.String("error", "failed to get picture variants"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get pictures"})
+		return
+	}
+
+	// The camera and location of a picture are only shown to its uploader
+	redactPrivateMetadata(images, c.GetInt("user_id"))
+
 	// Log the total number of pictures retrieved and increment the success metric
 	svc.logger.Info("sending pictures", zap.Int("total", len(images)))
 	getPicturesRequests.WithLabelValues("successful").Inc()
@@ -42,20 +68,46 @@ func (svc *PicturesService) GetPictures(c *gin.Context) {
 
 // GetPicture serves a specific picture file to the client with access restrictions.
 func (svc *PicturesService) GetPicture(c *gin.Context) {
-	// Check if the current request is made on allowed dates
-	if time.Now().Day() != anniversaryDay && (time.Now().Month() != valentineDay && time.Now().Month() != valentineMonth) {
-		// Log a warning and deny access if the request is outside of the specified dates
-		svc.logger.Warn("GetPicture called outside of anniversary and valentine day")
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	// Check if the current request is made on a day unlocked by an access rule
+	status := svc.access.Status()
+	if !status.Unlocked {
+		// Log a warning and deny access, telling the client when the next window opens
+		svc.logger.Warn("GetPicture called outside of an access window")
+		if retryAfter := status.RetryAfter(); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":            "access denied",
+			"now":              status.Now,
+			"unlocked":         false,
+			"next_unlock_at":   status.NextUnlockAt,
+			"next_unlock_name": status.NextUnlockName,
+		})
 		return
 	}
 
 	// Log the successful access to the function on allowed dates
-	svc.logger.Info("GetPicture called")
+	svc.logger.Info("GetPicture called", zap.String("rule", status.Rule))
 
 	// Extract the picture name from the query parameters
 	name := c.Query("name")
 
+	// Serve a resized variant when one is requested; "original" or no size serves the upload itself
+	if size := c.Query("size"); size != "" && size != "original" {
+		variant, err := svc.resolveVariant(name, size)
+		if err != nil {
+			if err == errInvalidSize {
+				getPictureRequests.WithLabelValues("invalid_request").Inc()
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			svc.ErrorHandler(getPictureRequests, err, zap.String("error", "failed to get picture variant"), zap.String("name", name))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get picture"})
+			return
+		}
+		name = variant
+	}
+
 	// Construct the file path by joining the base path with the requested picture name
 	filePath := filepath.Join(svc.basePath, name)
 
@@ -113,9 +165,18 @@ func (svc *PicturesService) UploadPictures(c *gin.Context) {
 	// Log the invocation of the UploadPictures endpoint
 	svc.logger.Info("UploadPictures called")
 
+	// Bound the request body before the multipart form is parsed, so an oversized request is
+	// refused while it is read rather than after it has been spooled to disk
+	maxRequestBytes := svc.config.Limits.MaxBytes*int64(svc.config.MaxFiles) + multipartOverhead
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBytes)
+
 	// Retrieve the multipart form from the request
 	form, err := c.MultipartForm()
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			err = &RequestTooLargeError{Message: "Request too large"}
+		}
 		// Handle errors related to multipart form processing
 		svc.handleUploadPictureError(c, err)
 		return
@@ -123,51 +184,60 @@ func (svc *PicturesService) UploadPictures(c *gin.Context) {
 
 	// Extract the files from the "pictures" key in the multipart form
 	files := form.File["pictures"]
+	// Refuse requests with more pictures than allowed before reading any of them
+	if len(files) > svc.config.MaxFiles {
+		svc.handleUploadPictureError(c, &RequestTooLargeError{Message: "Too many pictures"})
+		return
+	}
 	// Check if no files were uploaded and handle the error
 	if len(files) == 0 {
 		svc.handleUploadPictureError(c, errors.New("no pictures uploaded"))
 		return
 	}
 
-	var successfullyUploaded []string // Track successfully uploaded file names
-	var failedUploads []string        // Track file names of failed uploads
+	var successfullyUploaded []string      // Track successfully uploaded file names
+	var uploadedPictures []UploadedPicture // Track the records they were stored as
+	var failedUploads []string             // Track file names of failed uploads
 
-	// Iterate over the uploaded files
+	// Extract user ID from context, added by an earlier middleware or handler
+	userID := c.GetInt("user_id")
+
+	// Iterate over the uploaded files one at a time, so only one decoded picture is held in memory
 	for _, file := range files {
-		// Validate and save each file, capturing the safe file name or an error
-		safeFileName, err := svc.validateAndSaveFile(c, file)
+		// Validate each file, capturing the content to save, its safe file name, decoded picture and metadata or an error
+		uploaded, err := svc.validateFile(file)
 		if err != nil {
 			// If an error occurs, log it and add the file to the list of failed uploads
 			failedUploads = append(failedUploads, file.Filename)
-			svc.logger.Error("Failed to upload file", zap.Error(err))
+			uploadPictureRequests.WithLabelValues("rejected").Inc()
+			svc.logger.Error("Failed to upload file", zap.String("file", file.Filename), zap.Error(err))
 			continue
 		}
 
-		// If uploaded successfully, add the safe file name to the success list
-		successfullyUploaded = append(successfullyUploaded, safeFileName)
-	}
-
-	// Extract user ID from context, added by an earlier middleware or handler
-	userID := c.GetInt("user_id")
-
-	// For each successfully uploaded file, create a record in the database
-	for _, filename := range successfullyUploaded {
-		err := svc.SQLiteDB.CreateImage(userID, filename, time.Now().Unix())
+		// Save the file and create a record of it and its metadata in the database, unless the user already uploaded it
+		picture, err := svc.storeUpload(userID, uploaded)
 		if err != nil {
-			// Log any errors that occur while saving to the database
-			svc.logger.Error("failed to save image to database", zap.Error(err))
+			// Log any errors that occur while saving the file or its record
+			failedUploads = append(failedUploads, file.Filename)
+			svc.logger.Error("failed to store picture", zap.String("file", file.Filename), zap.Error(err))
 			continue
 		}
+
+		// If uploaded successfully, add the safe file name to the success list
+		successfullyUploaded = append(successfullyUploaded, uploaded.name)
+		uploadedPictures = append(uploadedPictures, picture)
 	}
 
 	// If there are any successful uploads, send a confirmation response
 	if len(successfullyUploaded) > 0 {
+		svc.audit.Record(c, audit.Event{Action: audit.ActionPictureUpload, Outcome: audit.OutcomeSuccess, Details: map[string]any{"files": successfullyUploaded}})
 		svc.logger.Info("Files uploaded successfully", zap.Strings("paths", successfullyUploaded))
-		c.JSON(http.StatusOK, gin.H{"message": "Files uploaded successfully", "paths": successfullyUploaded})
+		c.JSON(http.StatusOK, gin.H{"message": "Files uploaded successfully", "paths": successfullyUploaded, "pictures": uploadedPictures})
 	}
 
 	// If there are any failed uploads, inform the client
 	if len(failedUploads) > 0 {
+		svc.audit.Record(c, audit.Event{Action: audit.ActionPictureUpload, Outcome: audit.OutcomeFailure, Details: map[string]any{"files": failedUploads}})
 		svc.logger.Warn("Some files failed to upload", zap.Strings("files", failedUploads))
 		c.JSON(http.StatusBadRequest, gin.H{"error": "some files failed to upload", "failed_files": failedUploads})
 	}
@@ -176,3 +246,58 @@ func (svc *PicturesService) UploadPictures(c *gin.Context) {
 	uploadPictureRequests.WithLabelValues("successful").Inc()
 
 }
+
+// DeletePicture removes a picture's database record, and its files unless another record uses them.
+func (svc *PicturesService) DeletePicture(c *gin.Context) {
+	svc.logger.Info("DeletePicture called")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		deletePictureRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid picture id"})
+		return
+	}
+
+	image, err := svc.SQLiteDB.GetImage(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "picture not found"})
+			return
+		}
+		svc.ErrorHandler(deletePictureRequests, err, zap.String("error", "failed to get picture"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete picture"})
+		return
+	}
+
+	images := []db.Image{image}
+	if err := svc.SQLiteDB.AttachImageVariants(images); err != nil {
+		svc.ErrorHandler(deletePictureRequests, err, zap.String("error", "failed to get picture variants"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete picture"})
+		return
+	}
+	image = images[0]
+
+	// Files shared with another upload of the same picture are kept
+	err = svc.ReleaseFiles(func() ([]db.Image, error) {
+		unused, err := svc.SQLiteDB.DeleteImage(id)
+		if err != nil || !unused {
+			return nil, err
+		}
+		return []db.Image{image}, nil
+	})
+	if err != nil {
+		svc.ErrorHandler(deletePictureRequests, err, zap.String("error", "failed to delete picture record"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete picture"})
+		return
+	}
+
+	deletePictureRequests.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{
+		Action:  audit.ActionPictureDelete,
+		Outcome: audit.OutcomeSuccess,
+		Target:  fmt.Sprintf("picture:%d", id),
+		Details: map[string]any{"name": image.Name, "uploaded_by": image.UploadedBy},
+	})
+	svc.logger.Info("picture deleted", zap.Int("id", id), zap.String("name", image.Name), zap.Int("deleted_by", c.GetInt("user_id")))
+	c.JSON(http.StatusOK, gin.H{"message": "picture deleted"})
+}
@@ -1,37 +1,32 @@
 package pictures
 
 import (
+	"bytes"
 	"crypto/sha256"
-	"fmt"
+	"encoding/hex"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/VicSobDev/anniversaryAPI/pkg/imaging"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-// generateSafeFileName generates a new filename to prevent directory traversal and overwriting sensitive files.
-func (svc *PicturesService) generateSafeFileName(originalFileName string) string {
-	// Extract the extension of the original file
-	ext := filepath.Ext(originalFileName)
-
-	// Use SHA-256 hashing over the original filename with a timestamp to ensure uniqueness
-	hash := sha256.New()
-	hash.Write([]byte(originalFileName + time.Now().String()))
-	hashedFileName := fmt.Sprintf("%x", hash.Sum(nil))
-
-	// Append the original extension to maintain the file type
-	safeFileName := hashedFileName + ext
-
-	return safeFileName
+// contentFileName names a file after the SHA-256 of its content, so the same picture is always
+// stored under the same name and client-supplied names never reach the filesystem. The extension
+// is the one of the detected format. The hex-encoded hash is returned along with the name.
+func contentFileName(data []byte, ext string) (string, string) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	return hash, hash + ext
 }
 
 // handleUploadPictureError handles different types of errors by sending appropriate responses.
@@ -43,46 +38,229 @@ func (svc *PicturesService) handleUploadPictureError(c *gin.Context, err error)
 	case *ValidationError:
 		svc.ErrorHandler(uploadPictureRequests, e, zap.String("error", "invalid file type"))
 		c.JSON(http.StatusBadRequest, gin.H{"error": e.Error()})
+	case *RequestTooLargeError:
+		svc.ErrorHandler(uploadPictureRequests, e, zap.String("error", "upload request too large"))
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": e.Error()})
 	default:
 		svc.ErrorHandler(uploadPictureRequests, err, zap.String("error", "internal server error"), zap.String("error", err.Error()))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 	}
 }
 
-// validateAndSaveFile validates the file type and saves the file if valid.
-func (svc *PicturesService) validateAndSaveFile(c *gin.Context, file *multipart.FileHeader) (string, error) {
-	if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") {
-		return "", &ValidationError{Message: "Invalid file type"}
+// validateFile identifies the file type by its content and decodes the whole file to confirm
+// it is a valid image within the size limits. The declared content type and the extension of
+// the original name are ignored. Unless disabled, the content to save is rotated upright and
+// stripped of its metadata. The upright picture is returned for resizing, along with the
+// dimensions of the content to save and the EXIF metadata kept in the database.
+func (svc *PicturesService) validateFile(file *multipart.FileHeader) (*upload, error) {
+	if file.Size > svc.config.Limits.MaxBytes {
+		return nil, &ValidationError{Message: "File too large"}
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, &FileError{Message: "Failed to read the file"}
+	}
+	defer src.Close()
+
+	header := make([]byte, imaging.SniffLen)
+	n, _ := io.ReadFull(src, header)
+	format, err := imaging.Sniff(header[:n])
+	if err != nil {
+		return nil, &ValidationError{Message: "Invalid file type"}
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, &FileError{Message: "Failed to read the file"}
+	}
+
+	img, decoded, err := imaging.Decode(src, svc.config.Limits.MaxPixels)
+	if err == imaging.ErrTooLarge {
+		return nil, &ValidationError{Message: "Image dimensions too large"}
+	}
+	if err != nil {
+		return nil, &ValidationError{Message: "Invalid image"}
+	}
+	if decoded != format {
+		return nil, &ValidationError{Message: imaging.ErrFormatMismatch.Error()}
+	}
+
+	exif := imaging.ReadExif(src, format)
+	meta := db.ImageMetadata{
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		TakenAt:     exif.TakenAt,
+		Orientation: exif.Orientation,
+	}
+	if svc.config.KeepPrivateMetadata {
+		meta.CameraMake, meta.CameraModel = exif.CameraMake, exif.CameraModel
+		meta.Latitude, meta.Longitude = exif.Latitude, exif.Longitude
 	}
 
-	filename := svc.generateSafeFileName(file.Filename)
-	savePath := filepath.Join(svc.basePath, filename)
+	// Variants carry no EXIF orientation, so they are always resized from the upright picture
+	upright := imaging.Orient(img, exif.Orientation)
 
-	if err := svc.saveUploadedFile(file, savePath); err != nil {
-		return "", &FileError{Message: "Failed to save the file"}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, &FileError{Message: "Failed to save the file"}
 	}
 
-	return filename, nil
+	var data bytes.Buffer
+	if svc.config.StripMetadata {
+		if format, err = imaging.Sanitize(&data, src, format, upright, exif.Orientation); err != nil {
+			return nil, &ValidationError{Message: "Invalid image"}
+		}
+
+		// The saved file is upright and has no orientation left to apply
+		meta.Width, meta.Height = upright.Bounds().Dx(), upright.Bounds().Dy()
+		meta.Orientation = 0
+	} else if _, err := data.ReadFrom(src); err != nil {
+		return nil, &FileError{Message: "Failed to read the file"}
+	}
+
+	hash, filename := contentFileName(data.Bytes(), imaging.Extension(format))
+	return &upload{name: filename, hash: hash, data: data.Bytes(), img: upright, meta: meta}, nil
 }
 
-func (svc *PicturesService) saveUploadedFile(file *multipart.FileHeader, dst string) error {
-	src, err := file.Open()
+// storeUpload saves a validated picture and records it. Deletions wait until both are done,
+// so the file cannot be removed between being found already stored and the new record using it.
+// A file written by this upload is removed again when it could not be recorded.
+func (svc *PicturesService) storeUpload(userID int, uploaded *upload) (UploadedPicture, error) {
+	svc.files.Lock()
+	defer svc.files.Unlock()
+
+	path := filepath.Join(svc.basePath, uploaded.name)
+	created, err := svc.saveUploadedFile(bytes.NewReader(uploaded.data), path)
+	if err != nil {
+		return UploadedPicture{}, &FileError{Message: "Failed to save the file"}
+	}
+
+	picture, err := svc.recordUpload(userID, uploaded)
+	if err != nil && created {
+		if err := os.Remove(path); err != nil {
+			svc.logger.Error("failed to delete unrecorded picture file", zap.Error(err), zap.String("name", uploaded.name))
+		}
+	}
+	return picture, err
+}
+
+// ReleaseFiles runs release, which deletes picture records and returns the ones whose files no
+// other record uses, then deletes those files. Uploads wait until it is done, so a file is never
+// removed after an upload started using it. Files that fail to delete are logged, since their
+// records are already gone.
+func (svc *PicturesService) ReleaseFiles(release func() ([]db.Image, error)) error {
+	svc.files.Lock()
+	defer svc.files.Unlock()
+
+	unused, err := release()
 	if err != nil {
 		return err
 	}
-	defer src.Close()
 
+	for _, image := range unused {
+		if err := svc.removeFiles(image); err != nil {
+			svc.logger.Error("failed to delete picture file", zap.Error(err), zap.String("name", image.Name))
+		}
+	}
+	return nil
+}
+
+// recordUpload creates the record of a saved picture, or returns the uploader's existing record
+// when they already uploaded the same file. Records of the file uploaded by other users keep
+// their own metadata but share the file and its variants.
+func (svc *PicturesService) recordUpload(userID int, uploaded *upload) (UploadedPicture, error) {
+	existing, err := svc.SQLiteDB.GetImagesByContentHash(uploaded.hash)
+	if err != nil {
+		return UploadedPicture{}, err
+	}
+	for _, image := range existing {
+		if image.UploadedBy == userID {
+			return UploadedPicture{ID: image.ID, Name: image.Name, Duplicate: true}, nil
+		}
+	}
+
+	id, err := svc.SQLiteDB.CreateImage(userID, uploaded.name, uploaded.hash, time.Now().Unix(), uploaded.meta)
+	if err != nil {
+		return UploadedPicture{}, err
+	}
+	picture := UploadedPicture{ID: id, Name: uploaded.name}
+
+	// A picture without variants is still served in full size, so failures only get logged
+	if len(existing) > 0 {
+		err = svc.SQLiteDB.CopyImageVariants(existing[0].ID, id)
+	} else {
+		_, err = svc.writeVariants(id, uploaded.name, uploaded.img)
+	}
+	if err != nil {
+		pictureVariants.WithLabelValues("error").Inc()
+		svc.logger.Warn("failed to generate picture variants", zap.String("name", uploaded.name), zap.Error(err))
+	}
+
+	return picture, nil
+}
+
+// redactPrivateMetadata removes the camera and location of the pictures the viewer did not upload.
+func redactPrivateMetadata(images []db.Image, viewer int) {
+	for i := range images {
+		if images[i].UploadedBy != viewer {
+			images[i].CameraMake, images[i].CameraModel = "", ""
+			images[i].Latitude, images[i].Longitude = nil, nil
+		}
+	}
+}
+
+// parseImageQuery reads the sort order and filters of a picture listing from the query string.
+func parseImageQuery(c *gin.Context) (db.ImageQuery, error) {
+	q := db.ImageQuery{
+		Sort:       c.DefaultQuery("sort", db.SortUploaded),
+		Descending: c.Query("order") == "desc",
+		Camera:     c.Query("camera"),
+		Viewer:     c.GetInt("user_id"),
+	}
+
+	if q.Sort != db.SortUploaded && q.Sort != db.SortTaken {
+		return q, errInvalidSort
+	}
+	if order := c.Query("order"); order != "" && order != "asc" && order != "desc" {
+		return q, errInvalidSort
+	}
+
+	for name, dst := range map[string]**time.Time{"taken_after": &q.TakenAfter, "taken_before": &q.TakenBefore} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, errInvalidTakenRange
+			}
+			*dst = &t
+		}
+	}
+
+	return q, nil
+}
+
+// saveUploadedFile writes a file unless it already exists and reports whether it created it.
+// Files are named after their content, so an existing file already holds the same bytes.
+func (svc *PicturesService) saveUploadedFile(src io.Reader, dst string) (bool, error) {
 	svc.mx.Lock()
 	defer svc.mx.Unlock()
 
-	out, err := os.Create(dst)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return false, nil
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, src)
-	return err
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return false, err
+	}
+	return true, nil
 }
 
 // parsePaginationParams extracts and validates pagination parameters from the request.
@@ -0,0 +1,355 @@
+package pictures
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/VicSobDev/anniversaryAPI/pkg/imaging"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// newTestService returns a PicturesService storing files in a temporary directory and records
+// in a fresh database.
+func newTestService(t *testing.T) *PicturesService {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	sqliteDB, err := db.NewSQLiteDB(filepath.Join(dir, "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqliteDB.Close() })
+	if err := sqliteDB.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	basePath := filepath.Join(dir, "images")
+	if err := os.Mkdir(basePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := zap.NewNop()
+	return &PicturesService{
+		basePath: basePath,
+		logger:   logger,
+		SQLiteDB: sqliteDB,
+		audit:    audit.NewRecorder(sqliteDB, clock.System{}, logger, 0),
+		config:   DefaultConfig(),
+	}
+}
+
+// createTestUser stores a user to upload pictures as.
+func createTestUser(t *testing.T, svc *PicturesService, username string) int {
+	t.Helper()
+
+	user, err := svc.SQLiteDB.CreateUser(username, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// testImage returns a picture of the given size filled with a single color.
+func testImage(width, height int, fill color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, fill)
+		}
+	}
+	return img
+}
+
+// newUpload returns a validated upload of the picture, stored as JPEG.
+func newUpload(t *testing.T, img image.Image) *upload {
+	t.Helper()
+
+	var data bytes.Buffer
+	if err := jpeg.Encode(&data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, name := contentFileName(data.Bytes(), ".jpg")
+	return &upload{
+		name: name,
+		hash: hash,
+		data: data.Bytes(),
+		img:  img,
+		meta: db.ImageMetadata{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()},
+	}
+}
+
+// deletePicture calls DeletePicture for the picture and fails the test unless it succeeds.
+func deletePicture(t *testing.T, svc *PicturesService, id int) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/pictures/%d", id), nil)
+	c.Params = gin.Params{{Key: "id", Value: fmt.Sprint(id)}}
+
+	svc.DeletePicture(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DeletePicture status = %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// fileExists reports whether the base path holds a file with the given name.
+func fileExists(t *testing.T, svc *PicturesService, name string) bool {
+	t.Helper()
+
+	_, err := os.Stat(filepath.Join(svc.basePath, name))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestDuplicateUploadsShareFiles(t *testing.T) {
+	svc := newTestService(t)
+	victor := createTestUser(t, svc, "victor")
+	ana := createTestUser(t, svc, "ana")
+	uploaded := newUpload(t, testImage(400, 300, color.White))
+
+	first, err := svc.storeUpload(victor, uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Duplicate {
+		t.Fatal("first upload reported as a duplicate")
+	}
+
+	again, err := svc.storeUpload(victor, uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Duplicate || again.ID != first.ID {
+		t.Fatalf("second upload by the same user = %+v, want a duplicate of %d", again, first.ID)
+	}
+
+	// Another uploader gets their own record of the same file
+	shared, err := svc.storeUpload(ana, uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared.Duplicate || shared.ID == first.ID || shared.Name != first.Name {
+		t.Fatalf("upload by another user = %+v, want a new record of %s", shared, first.Name)
+	}
+
+	thumbnail := variantName(first.Name, imaging.Sizes[0], imaging.FormatJPEG)
+	deletePicture(t, svc, first.ID)
+	if !fileExists(t, svc, first.Name) || !fileExists(t, svc, thumbnail) {
+		t.Fatal("shared files removed while another record uses them")
+	}
+
+	deletePicture(t, svc, shared.ID)
+	if fileExists(t, svc, first.Name) || fileExists(t, svc, thumbnail) {
+		t.Fatal("files kept after their last record was deleted")
+	}
+}
+
+func TestUnrecordedUploadRemoved(t *testing.T) {
+	svc := newTestService(t)
+	victor := createTestUser(t, svc, "victor")
+
+	stored := newUpload(t, testImage(10, 10, color.White))
+	if _, err := svc.storeUpload(victor, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recording fails once the database is gone
+	svc.SQLiteDB.Close()
+
+	fresh := newUpload(t, testImage(10, 10, color.Black))
+	if _, err := svc.storeUpload(victor, fresh); err == nil {
+		t.Fatal("upload recorded without a database")
+	}
+	if fileExists(t, svc, fresh.name) {
+		t.Fatal("file of the failed upload was kept")
+	}
+
+	// A file that was already stored belongs to its existing record
+	if _, err := svc.storeUpload(victor, stored); err == nil {
+		t.Fatal("upload recorded without a database")
+	}
+	if !fileExists(t, svc, stored.name) {
+		t.Fatal("file of an existing record was removed")
+	}
+}
+
+// variantFormats returns the formats variants can be written in by this build.
+func variantFormats() []string {
+	if imaging.EncodesWebP {
+		return []string{imaging.FormatJPEG, imaging.FormatWebP}
+	}
+	return []string{imaging.FormatJPEG}
+}
+
+// storeTestPicture writes a JPEG of the picture to the base path and records it without variants.
+func storeTestPicture(t *testing.T, svc *PicturesService, userID int, name string, img image.Image) int {
+	t.Helper()
+
+	var data bytes.Buffer
+	if err := jpeg.Encode(&data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(svc.basePath, name), data.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := svc.SQLiteDB.CreateImage(userID, name, "", time.Now().Unix(), db.ImageMetadata{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestWriteVariants(t *testing.T) {
+	for _, format := range variantFormats() {
+		t.Run(format, func(t *testing.T) {
+			svc := newTestService(t)
+			svc.config.VariantFormat = format
+			victor := createTestUser(t, svc, "victor")
+			img := testImage(1500, 1000, color.White)
+			id := storeTestPicture(t, svc, victor, "picture.jpg", img)
+
+			// The original fits in large, so only the smaller sizes get a copy
+			generated, err := svc.writeVariants(id, "picture.jpg", img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if generated != 2 {
+				t.Fatalf("generated %d variants, want 2", generated)
+			}
+
+			want := map[string]image.Rectangle{
+				"thumbnail": image.Rect(0, 0, 320, 213),
+				"medium":    image.Rect(0, 0, 1280, 853),
+			}
+			for size, bounds := range want {
+				variant, err := svc.SQLiteDB.GetImageVariant(id, size)
+				if err != nil {
+					t.Fatalf("%s: %v", size, err)
+				}
+				if variant.Format != format || variant.Name != "picture_"+size+imaging.Extension(format) {
+					t.Fatalf("%s variant = %+v, want a %s file", size, variant, format)
+				}
+				if variant.Width != bounds.Dx() || variant.Height != bounds.Dy() {
+					t.Fatalf("%s variant is %dx%d, want %dx%d", size, variant.Width, variant.Height, bounds.Dx(), bounds.Dy())
+				}
+
+				file, err := os.Open(filepath.Join(svc.basePath, variant.Name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, decodedFormat, err := image.Decode(file)
+				file.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if decodedFormat != format || decoded.Bounds() != bounds {
+					t.Fatalf("%s file is a %s of %v, want a %s of %v", size, decodedFormat, decoded.Bounds(), format, bounds)
+				}
+			}
+
+			if _, err := svc.SQLiteDB.GetImageVariant(id, "large"); err != sql.ErrNoRows {
+				t.Fatalf("large variant lookup = %v, want no rows", err)
+			}
+		})
+	}
+}
+
+func TestResolveVariant(t *testing.T) {
+	svc := newTestService(t)
+	victor := createTestUser(t, svc, "victor")
+	img := testImage(1500, 1000, color.White)
+	id := storeTestPicture(t, svc, victor, "picture.jpg", img)
+	if _, err := svc.writeVariants(id, "picture.jpg", img); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, size string
+		want       string
+		err        error
+	}{
+		{"picture.jpg", "thumbnail", "picture_thumbnail.jpg", nil},
+		{"picture.jpg", "medium", "picture_medium.jpg", nil},
+		{"picture.jpg", "large", "picture.jpg", nil},     // the original fits, so it has no variant
+		{"unknown.jpg", "thumbnail", "unknown.jpg", nil}, // left to the handler to report as not found
+		{"picture.jpg", "huge", "", errInvalidSize},
+	}
+
+	for _, tt := range tests {
+		got, err := svc.resolveVariant(tt.name, tt.size)
+		if got != tt.want || err != tt.err {
+			t.Errorf("resolveVariant(%q, %q) = %q, %v, want %q, %v", tt.name, tt.size, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestBackfillVariants(t *testing.T) {
+	svc := newTestService(t)
+	victor := createTestUser(t, svc, "victor")
+
+	missing := storeTestPicture(t, svc, victor, "missing.jpg", testImage(1500, 1000, color.White))
+	small := storeTestPicture(t, svc, victor, "small.jpg", testImage(100, 100, color.White))
+
+	done := testImage(1500, 1000, color.Black)
+	existing := storeTestPicture(t, svc, victor, "existing.jpg", done)
+	if _, err := svc.writeVariants(existing, "existing.jpg", done); err != nil {
+		t.Fatal(err)
+	}
+	// Pictures that already have variants are not decoded again
+	if err := os.Remove(filepath.Join(svc.basePath, "existing.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file that cannot be decoded is skipped without stopping the backfill
+	if err := os.WriteFile(filepath.Join(svc.basePath, "broken.jpg"), []byte("not a picture"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.SQLiteDB.CreateImage(victor, "broken.jpg", "", time.Now().Unix(), db.ImageMetadata{Width: 1, Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	generated, err := svc.BackfillVariants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated != 2 {
+		t.Fatalf("generated %d variants, want 2", generated)
+	}
+
+	images, err := svc.SQLiteDB.GetImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.SQLiteDB.AttachImageVariants(images); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]int{missing: 2, small: 0, existing: 2}
+	for _, image := range images {
+		if n, ok := want[image.ID]; ok && len(image.Variants) != n {
+			t.Errorf("%s has %d variants, want %d", image.Name, len(image.Variants), n)
+		}
+		if image.Name == "broken.jpg" && len(image.Variants) != 0 {
+			t.Errorf("broken.jpg has %d variants, want none", len(image.Variants))
+		}
+	}
+}
@@ -33,4 +33,18 @@ var (
 		},
 		[]string{"status"},
 	)
+	deletePictureRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pictures_delete_requests_total",
+			Help: "Total number of delete picture requests.",
+		},
+		[]string{"status"},
+	)
+	pictureVariants = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pictures_variants_total",
+			Help: "Total number of resized picture variants generated.",
+		},
+		[]string{"status"},
+	)
 )
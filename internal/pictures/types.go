@@ -1,10 +1,14 @@
 package pictures
 
 import (
+	"image"
 	"mime/multipart"
 	"sync"
 
+	"github.com/VicSobDev/anniversaryAPI/internal/access"
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
 	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/VicSobDev/anniversaryAPI/pkg/imaging"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
@@ -12,14 +16,62 @@ import (
 type PicturesService struct {
 	basePath string
 	mx       sync.Mutex
+	// files serialises storing uploads against deleting unused files, so a file is never removed
+	// once an upload has found it already stored. It is held while mx is taken for single file
+	// operations, hence separate from it.
+	files    sync.Mutex
 	logger   *zap.Logger
 	SQLiteDB *db.SQLiteDB
+	access   *access.Engine
+	audit    *audit.Recorder
+	config   Config
+}
+
+// Config controls how uploaded pictures are validated and stored
+type Config struct {
+	Limits imaging.Limits
+	// MaxFiles is the most pictures accepted in a single upload request
+	MaxFiles int
+	// StripMetadata stores originals upright and without their metadata instead of byte for byte
+	StripMetadata bool
+	// KeepPrivateMetadata stores the camera and location of pictures, which only their uploader can see
+	KeepPrivateMetadata bool
+	// VariantFormat is the format resized copies are encoded in, imaging.FormatJPEG or imaging.FormatWebP
+	VariantFormat string
+}
+
+// DefaultConfig returns the configuration used when none is set
+func DefaultConfig() Config {
+	return Config{
+		Limits:              imaging.DefaultLimits(),
+		MaxFiles:            20,
+		StripMetadata:       true,
+		KeepPrivateMetadata: true,
+		VariantFormat:       imaging.FormatJPEG,
+	}
 }
 
 type FileError struct {
 	Message string
 }
 
+// upload is a validated and saved picture
+type upload struct {
+	name string
+	hash string
+	data []byte
+	img  image.Image
+	meta db.ImageMetadata
+}
+
+// UploadedPicture is the record an uploaded file was stored as. Duplicate is set when the
+// uploader had already uploaded the same picture, whose existing record is returned.
+type UploadedPicture struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Duplicate bool   `json:"duplicate"`
+}
+
 type pagination struct {
 	limit  int
 	offset int
@@ -29,6 +81,15 @@ func (e *FileError) Error() string {
 	return e.Message
 }
 
+// RequestTooLargeError rejects an upload request before its pictures are read
+type RequestTooLargeError struct {
+	Message string
+}
+
+func (e *RequestTooLargeError) Error() string {
+	return e.Message
+}
+
 type ValidationError struct {
 	Message string
 }
@@ -41,11 +102,13 @@ type FileSaver interface {
 	SaveUploadedFile(*multipart.FileHeader, string) error
 }
 
-func NewPicturesService(basePath string, logger *zap.Logger, sqliteDB *db.SQLiteDB) *PicturesService {
+func NewPicturesService(basePath string, logger *zap.Logger, sqliteDB *db.SQLiteDB, engine *access.Engine, recorder *audit.Recorder, config Config) *PicturesService {
 	// Register metrics with Prometheus's default registry
 	prometheus.MustRegister(getPicturesRequests)
 	prometheus.MustRegister(getPictureRequests)
 	prometheus.MustRegister(uploadPictureRequests)
+	prometheus.MustRegister(deletePictureRequests)
+	prometheus.MustRegister(pictureVariants)
 
-	return &PicturesService{basePath: basePath, logger: logger, SQLiteDB: sqliteDB}
+	return &PicturesService{basePath: basePath, logger: logger, SQLiteDB: sqliteDB, access: engine, audit: recorder, config: config}
 }
@@ -0,0 +1,167 @@
+package pictures
+
+import (
+	"database/sql"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/VicSobDev/anniversaryAPI/pkg/imaging"
+	"go.uber.org/zap"
+)
+
+// resolveVariant returns the file name to serve for a picture at the given size. The original
+// is served when the picture fits in the size and therefore has no variant for it.
+func (svc *PicturesService) resolveVariant(name, size string) (string, error) {
+	if _, ok := imaging.SizeByName(size); !ok {
+		return "", errInvalidSize
+	}
+
+	image, err := svc.SQLiteDB.GetImageByName(name)
+	if err == sql.ErrNoRows {
+		return name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	variant, err := svc.SQLiteDB.GetImageVariant(image.ID, size)
+	if err == sql.ErrNoRows {
+		return name, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return variant.Name, nil
+}
+
+// variantName returns the file name of the variant of the given size and format, stored next to the original.
+func variantName(original string, size imaging.Size, format string) string {
+	return strings.TrimSuffix(original, filepath.Ext(original)) + "_" + size.Name + imaging.Extension(format)
+}
+
+// generateVariants decodes a stored picture and writes its variants, upright as variants
+// carry no EXIF orientation.
+func (svc *PicturesService) generateVariants(imageID int, name string) (int, error) {
+	svc.mx.Lock()
+	file, err := os.Open(filepath.Join(svc.basePath, filepath.Base(name)))
+	svc.mx.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	img, format, err := imaging.Decode(file, svc.config.Limits.MaxPixels)
+	if err != nil {
+		return 0, err
+	}
+
+	// Originals stored before metadata was stripped may still need rotating
+	img = imaging.Orient(img, imaging.ReadExif(file, format).Orientation)
+
+	return svc.writeVariants(imageID, name, img)
+}
+
+// writeVariants writes and records the resized copies of a decoded picture. Sizes the
+// original already fits in are skipped, since the original is served for them.
+func (svc *PicturesService) writeVariants(imageID int, name string, img image.Image) (int, error) {
+	generated := 0
+	for _, size := range imaging.Sizes {
+		if imaging.Fits(img, size) {
+			break
+		}
+
+		resized := imaging.Resize(img, size)
+		variant := db.ImageVariant{
+			ImageID: imageID,
+			Size:    size.Name,
+			Name:    variantName(filepath.Base(name), size, svc.config.VariantFormat),
+			Format:  svc.config.VariantFormat,
+			Width:   resized.Bounds().Dx(),
+			Height:  resized.Bounds().Dy(),
+		}
+
+		if err := svc.writeVariantFile(variant.Name, variant.Format, resized); err != nil {
+			return generated, err
+		}
+		if err := svc.SQLiteDB.SaveImageVariant(variant, time.Now()); err != nil {
+			return generated, err
+		}
+
+		generated++
+		pictureVariants.WithLabelValues("generated").Inc()
+	}
+
+	return generated, nil
+}
+
+// writeVariantFile encodes a resized picture in the given format to a file in the base path.
+func (svc *PicturesService) writeVariantFile(name, format string, img image.Image) error {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	out, err := os.Create(filepath.Join(svc.basePath, name))
+	if err != nil {
+		return err
+	}
+
+	if err := imaging.Encode(out, img, format); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// removeFiles deletes the files of a picture and its variants. Files that are already
+// gone are ignored.
+func (svc *PicturesService) removeFiles(image db.Image) error {
+	svc.mx.Lock()
+	defer svc.mx.Unlock()
+
+	var errs []error
+	for _, variant := range image.Variants {
+		if err := os.Remove(filepath.Join(svc.basePath, filepath.Base(variant.Name))); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(svc.basePath, filepath.Base(image.Name))); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// BackfillVariants generates the variants of pictures uploaded before variants existed
+// and reports how many were generated. Pictures that cannot be decoded are logged and skipped.
+func (svc *PicturesService) BackfillVariants() (int, error) {
+	images, err := svc.SQLiteDB.GetImages()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := svc.SQLiteDB.AttachImageVariants(images); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, image := range images {
+		if len(image.Variants) > 0 {
+			continue
+		}
+
+		generated, err := svc.generateVariants(image.ID, image.Name)
+		total += generated
+		if err != nil {
+			pictureVariants.WithLabelValues("error").Inc()
+			svc.logger.Warn("failed to generate picture variants", zap.Int("id", image.ID), zap.String("name", image.Name), zap.Error(err))
+		}
+	}
+
+	return total, nil
+}
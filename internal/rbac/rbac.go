@@ -0,0 +1,38 @@
+package rbac
+
+import "github.com/VicSobDev/anniversaryAPI/pkg/db"
+
+// Permissions guard individual operations; roles grant a set of them.
+const (
+	PicturesRead   = "pictures:read"
+	PicturesWrite  = "pictures:write"
+	PicturesDelete = "pictures:delete"
+	// KeysAdmin covers registration keys, JWT signing keys and listing or revoking service credentials
+	KeysAdmin = "keys:admin"
+	// AccessAdmin covers the access rules that unlock pictures
+	AccessAdmin = "access:admin"
+	// UsersAdmin covers lockouts, password resets, user management and creating service credentials
+	UsersAdmin = "users:admin"
+	// AuditRead covers querying and exporting the audit log
+	AuditRead = "audit:read"
+)
+
+var rolePermissions = map[string][]string{
+	db.RoleMember: {PicturesRead, PicturesWrite},
+	db.RoleAdmin:  {PicturesRead, PicturesWrite, PicturesDelete, KeysAdmin, AccessAdmin, UsersAdmin, AuditRead},
+}
+
+// Permissions returns the permissions granted to role; unknown roles have none.
+func Permissions(role string) []string {
+	return rolePermissions[role]
+}
+
+// Allows reports whether role grants permission.
+func Allows(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"go.uber.org/zap"
+)
+
+// Store tracks revoked token IDs, per-user token generations and disabled users. Revocations are
+// persisted in SQLite and mirrored in memory so lookups on every request stay cheap.
+type Store struct {
+	mx          sync.RWMutex
+	revoked     map[string]time.Time
+	generations map[int]int
+	disabled    map[int]bool
+	db          *db.SQLiteDB
+}
+
+// NewStore creates a Store backed by sqliteDB.
+func NewStore(sqliteDB *db.SQLiteDB) *Store {
+	return &Store{
+		revoked:     make(map[string]time.Time),
+		generations: make(map[int]int),
+		disabled:    make(map[int]bool),
+		db:          sqliteDB,
+	}
+}
+
+// Load fills the in-memory cache with the unexpired revocations and disabled users stored in the database.
+func (s *Store) Load() error {
+	revoked, err := s.db.GetRevokedTokens(time.Now())
+	if err != nil {
+		return err
+	}
+
+	ids, err := s.db.GetDisabledUserIDs()
+	if err != nil {
+		return err
+	}
+
+	disabled := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		disabled[id] = true
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.revoked = revoked
+	s.disabled = disabled
+	return nil
+}
+
+// Revoke marks the token ID as revoked until it would have expired anyway.
+func (s *Store) Revoke(jti string, expiresAt time.Time) error {
+	if err := s.db.RevokeToken(jti, expiresAt); err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+// IsRevoked reports whether the token ID has been revoked.
+func (s *Store) IsRevoked(jti string) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	_, ok := s.revoked[jti]
+	return ok
+}
+
+// Generation returns the user's current token generation.
+func (s *Store) Generation(userID int) (int, error) {
+	s.mx.RLock()
+	generation, ok := s.generations[userID]
+	s.mx.RUnlock()
+	if ok {
+		return generation, nil
+	}
+
+	generation, err := s.db.GetTokenGeneration(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.cacheGeneration(userID, generation), nil
+}
+
+// BumpGeneration increments the user's token generation, invalidating all of their outstanding tokens.
+func (s *Store) BumpGeneration(userID int) (int, error) {
+	generation, err := s.db.IncrementTokenGeneration(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.cacheGeneration(userID, generation), nil
+}
+
+// cacheGeneration stores a generation read from the database unless a higher one is already
+// cached, and returns the cached value. Generations only grow, so a value read before a
+// concurrent bump must not replace the bumped one.
+func (s *Store) cacheGeneration(userID, generation int) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if cached, ok := s.generations[userID]; ok && cached > generation {
+		return cached
+	}
+	s.generations[userID] = generation
+	return generation
+}
+
+// SetDisabled disables or enables the user and reports whether the user exists.
+// Tokens of a disabled user are rejected until the user is enabled again.
+func (s *Store) SetDisabled(userID int, disabled bool) (bool, error) {
+	found, err := s.db.SetUserDisabled(userID, disabled)
+	if err != nil || !found {
+		return found, err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if disabled {
+		s.disabled[userID] = true
+	} else {
+		delete(s.disabled, userID)
+	}
+	return true, nil
+}
+
+// IsDisabled reports whether the user has been disabled.
+func (s *Store) IsDisabled(userID int) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.disabled[userID]
+}
+
+// Forget drops everything cached about a deleted user.
+func (s *Store) Forget(userID int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.generations, userID)
+	delete(s.disabled, userID)
+}
+
+// Prune drops revocations for tokens that have expired, since they are rejected regardless,
+// along with expired refresh tokens, which are rejected before reuse is checked.
+func (s *Store) Prune(now time.Time) error {
+	if err := s.db.DeleteExpiredRevokedTokens(now); err != nil {
+		return err
+	}
+	if err := s.db.DeleteExpiredRefreshTokens(now); err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for jti, expiresAt := range s.revoked {
+		if expiresAt.Before(now) {
+			delete(s.revoked, jti)
+		}
+	}
+	return nil
+}
+
+// StartPruning prunes expired revocations and refresh tokens every interval in a background goroutine.
+func (s *Store) StartPruning(interval time.Duration, logger *zap.Logger) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			if err := s.Prune(now); err != nil {
+				logger.Error("failed to prune expired tokens", zap.Error(err))
+			}
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+package revocation
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+)
+
+// newTestStore returns a Store backed by a fresh database holding one user, and that user's ID.
+func newTestStore(t *testing.T) (*Store, int) {
+	t.Helper()
+
+	sqliteDB, err := db.NewSQLiteDB(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqliteDB.Close() })
+	if err := sqliteDB.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := sqliteDB.CreateUser("victor", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(sqliteDB), user.ID
+}
+
+func TestStaleGenerationDoesNotReplaceBump(t *testing.T) {
+	store, userID := newTestStore(t)
+
+	// A lookup that read the database just before the bump finishes after it
+	stale, err := store.db.GetTokenGeneration(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bumped, err := store.BumpGeneration(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.cacheGeneration(userID, stale); got != bumped {
+		t.Fatalf("cacheGeneration = %d, want %d", got, bumped)
+	}
+
+	if got, err := store.Generation(userID); err != nil || got != bumped {
+		t.Fatalf("Generation = %d, %v, want %d", got, err, bumped)
+	}
+}
+
+func TestConcurrentGenerationLookups(t *testing.T) {
+	store, userID := newTestStore(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := store.BumpGeneration(userID); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := store.Generation(userID); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	stored, err := store.db.GetTokenGeneration(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.Generation(userID); err != nil || got != stored {
+		t.Fatalf("Generation = %d, %v, want %d", got, err, stored)
+	}
+}
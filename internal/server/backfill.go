@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/pictures"
+	"go.uber.org/zap"
+)
+
+// BackfillVariants generates the resized variants of pictures uploaded before variants existed.
+func (a *Api) BackfillVariants() error {
+	logger, err := a.initializeLogger()
+	if err != nil {
+		return err
+	}
+
+	a.logger = logger
+
+	sqliteDB, err := a.initializeDatabase()
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	// Access rules and the audit log are not needed to process existing files
+	picturesService := pictures.NewPicturesService("images", logger, sqliteDB, nil, nil, a.picturesConfig)
+
+	generated, err := picturesService.BackfillVariants()
+	if err != nil {
+		return err
+	}
+
+	logger.Info("picture variants backfilled", zap.Int("generated", generated))
+	fmt.Printf("Generated %d picture variants\n", generated)
+	return nil
+}
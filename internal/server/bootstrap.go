@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// bootstrapKeyLabel marks the registration key that creates the first admin
+	bootstrapKeyLabel = "bootstrap"
+	// bootstrapKeyTTL is how long the bootstrap key can be redeemed
+	bootstrapKeyTTL = 7 * 24 * time.Hour
+)
+
+// Bootstrap replaces any bootstrap key with a new one and prints it, whether or not users exist.
+func (a *Api) Bootstrap() error {
+	logger, err := a.initializeLogger()
+	if err != nil {
+		return err
+	}
+
+	a.logger = logger
+
+	sqliteDB, err := a.initializeDatabase()
+	if err != nil {
+		return err
+	}
+	defer sqliteDB.Close()
+
+	return a.createBootstrapKey(sqliteDB)
+}
+
+// ensureBootstrapKey creates a bootstrap key on first run, while there are no users and no
+// redeemable bootstrap key. Once an account exists no keys are generated automatically.
+func (a *Api) ensureBootstrapKey(sqliteDB *db.SQLiteDB) error {
+	users, err := sqliteDB.CountUsers()
+	if err != nil {
+		return err
+	}
+
+	if users > 0 {
+		return nil
+	}
+
+	exists, err := sqliteDB.HasRedeemableKey(bootstrapKeyLabel, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		a.logger.Warn("no users registered yet; redeem the bootstrap key or run with --bootstrap to generate a new one")
+		return nil
+	}
+
+	return a.createBootstrapKey(sqliteDB)
+}
+
+// createBootstrapKey stores a single-use admin registration key and prints it to stdout.
+// The key is kept out of the structured log so it does not end up in log storage.
+func (a *Api) createBootstrapKey(sqliteDB *db.SQLiteDB) error {
+	if err := sqliteDB.DeleteKeysByLabel(bootstrapKeyLabel); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(bootstrapKeyTTL)
+	key, err := sqliteDB.CreateKey(db.RegistrationKey{
+		Key:       uuid.NewString(),
+		Label:     bootstrapKeyLabel,
+		CreatedBy: "bootstrap",
+		ExpiresAt: &expiresAt,
+		MaxUses:   1,
+		Role:      db.RoleAdmin,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	a.logger.Warn("bootstrap registration key created", zap.Int("key_id", key.ID), zap.Time("expires_at", expiresAt))
+	fmt.Printf("\nBootstrap admin registration key (single use, expires %s):\n\n    %s\n\n", expiresAt.Format(time.RFC3339), key.Key)
+	return nil
+}
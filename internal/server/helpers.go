@@ -1,74 +1,123 @@
 package server
 
 import (
-	"fmt"
+	"database/sql"
+	"errors"
+	"strings"
 
+	"github.com/VicSobDev/anniversaryAPI/internal/token"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
 	"github.com/VicSobDev/anniversaryAPI/pkg/db"
-	"github.com/dgrijalva/jwt-go"
-	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 )
 
-func (a *Api) validateToken(tokenString string, c *gin.Context) (db.User, bool) {
-	// Parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the token's algorithm matches what you expect
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+var (
+	errTokenRevoked       = errors.New("token revoked")
+	errTokenGenerationOld = errors.New("token generation is no longer valid")
+	errUserDisabled       = errors.New("user disabled")
+	errAccessTokenInvalid = errors.New("access token not found or expired")
+	errPasswordReset      = errors.New("password reset required")
+	errAuthHeaderMissing  = errors.New("Authorization header is missing")
+	errAuthHeaderScheme   = errors.New("Authorization header must start with Bearer")
+	errTokenMissing       = errors.New("Token is missing")
+)
+
+// bearerToken extracts the token from an Authorization header of the form "Bearer <token>".
+// The scheme is matched case-insensitively and surrounding whitespace is ignored.
+func bearerToken(header string) (string, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return "", errAuthHeaderMissing
+	}
 
-		return a.jwtKey, nil
-	})
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", errAuthHeaderScheme
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errTokenMissing
+	}
 
+	return token, nil
+}
+
+// validateToken verifies the token and checks it has not been revoked, returning its claims.
+func (a *Api) validateToken(tokenString string) (*token.Claims, error) {
+	claims, err := a.tokens.Parse(tokenString)
 	if err != nil {
-		a.logger.Error("failed to parse token", zap.Error(err))
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort()
-		return db.User{}, false
+		return nil, err
 	}
 
-	// Check if the token is valid
-	if !token.Valid {
-		a.logger.Error("invalid token")
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort()
-		return db.User{}, false
+	// Reject tokens revoked by logout
+	if a.revocations.IsRevoked(claims.ID) {
+		return nil, errTokenRevoked
 	}
 
-	// Extract claims from the token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		a.logger.Error("failed to extract claims from token")
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort()
-		return db.User{}, false
+	// Reject tokens of disabled users, however recently they were issued
+	if a.revocations.IsDisabled(claims.UserID) {
+		return nil, errUserDisabled
 	}
 
-	a.logger.Info("claims", zap.Any("claims", claims))
+	// Reject tokens issued before the user's last logout-all
+	current, err := a.revocations.Generation(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Generation < current {
+		return nil, errTokenGenerationOld
+	}
 
-	// Extract user ID and username from claims
-	userID, ok := claims["user_id"].(float64)
-	if !ok {
-		a.logger.Error("user_id not found in claims")
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort()
-		return db.User{}, false
+	return claims, nil
+}
+
+// validateAccessToken looks up a personal access token, returning it with its owner.
+func (a *Api) validateAccessToken(secret string) (*db.AccessToken, *db.User, error) {
+	accessToken, err := a.db.GetAccessToken(crypto.HashToken(secret))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, errAccessTokenInvalid
+		}
+		return nil, nil, err
+	}
+
+	now := a.clock.Now()
+	if !accessToken.ExpiresAt.After(now) {
+		return nil, nil, errAccessTokenInvalid
+	}
+
+	if a.revocations.IsDisabled(accessToken.UserID) {
+		return nil, nil, errUserDisabled
+	}
+
+	// The owner's current role applies, so a demotion narrows existing tokens too
+	user, err := a.db.GetUserByID(accessToken.UserID)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	username, ok := claims["username"].(string)
-	if !ok {
-		a.logger.Error("username not found in claims")
-		c.JSON(401, gin.H{"error": "Unauthorized"})
-		c.Abort()
-		return db.User{}, false
+	// Tokens are deleted when a reset is forced, but one created since must not work either
+	if user.PasswordResetRequired {
+		return nil, nil, errPasswordReset
 	}
 
-	// Construct the user object
-	user := db.User{
-		ID:       int(userID),
-		Username: username,
+	if err := a.db.UpdateAccessTokenUsage(accessToken.ID, now); err != nil {
+		return nil, nil, err
 	}
 
-	a.logger.Info("user validated", zap.String("username", user.Username))
-	return user, true
+	return &accessToken, user, nil
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to store and log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
 }
@@ -1,65 +1,193 @@
 package server
 
 import (
+	"slices"
 	"strings"
 
+	"github.com/VicSobDev/anniversaryAPI/internal/auth"
+	"github.com/VicSobDev/anniversaryAPI/internal/credentials"
+	"github.com/VicSobDev/anniversaryAPI/internal/rbac"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
+// requestIDHeader carries the request ID, either supplied by a proxy or generated here
+const requestIDHeader = "X-Request-ID"
+
+// RequestIDMiddleware stores the request ID in the context and echoes it in the response.
+// A client-supplied ID is kept when it is short and printable, otherwise a new one is generated.
+func (a *Api) RequestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = uuid.NewString()
+	}
+
+	c.Set("request_id", id)
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
 func (a *Api) AuthMiddleware(c *gin.Context) {
-	// Extract the token from the Authorization header
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		c.JSON(401, gin.H{"error": "Authorization header is missing"})
-		c.Abort()
+	if !a.authenticate(c) {
 		return
 	}
 
-	// Expect the header to be "Bearer <token>"
-	const prefix = "Bearer "
-	if !strings.HasPrefix(authHeader, prefix) {
-		c.JSON(401, gin.H{"error": "Authorization header must start with Bearer"})
-		c.Abort()
+	c.Next()
+}
+
+// SessionMiddleware authenticates account routes, which only a login session may use.
+// Personal access tokens are refused so a scoped token cannot widen its own access.
+func (a *Api) SessionMiddleware(c *gin.Context) {
+	if !a.authenticate(c) {
 		return
 	}
 
-	// Extract the JWT token from the header
-	jwtToken := authHeader[len(prefix):]
-	if jwtToken == "" {
-		c.JSON(401, gin.H{"error": "Token is missing"})
+	if _, ok := c.Get("scopes"); ok {
+		c.JSON(403, gin.H{"error": "personal access tokens cannot be used for this request"})
 		c.Abort()
 		return
 	}
 
+	c.Next()
+}
+
+// authenticate validates the bearer token, either a JWT or a personal access token, and stores
+// the user and token details in the context. It responds with 401 and aborts the request when
+// the token is not valid.
+func (a *Api) authenticate(c *gin.Context) bool {
+	// Extract the token from the "Authorization: Bearer <token>" header
+	jwtToken, err := bearerToken(c.GetHeader("Authorization"))
+	if err != nil {
+		c.JSON(401, gin.H{"error": err.Error()})
+		c.Abort()
+		return false
+	}
+
+	if strings.HasPrefix(jwtToken, auth.AccessTokenPrefix) {
+		return a.authenticateAccessToken(c, jwtToken)
+	}
+
 	// Validate the token
-	user, ok := a.validateToken(jwtToken, c)
-	if !ok {
-		// validateToken method handles response to client
-		return
+	claims, err := a.validateToken(jwtToken)
+	if err != nil {
+		a.logger.Error("failed to validate token", zap.Error(err))
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		c.Abort()
+		return false
+	}
+
+	// Set the user and token details in the context
+	c.Set("user_id", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("role", claims.Role)
+	c.Set("jti", claims.ID)
+	c.Set("token_expires_at", claims.Expiry())
+	return true
+}
+
+// authenticateAccessToken validates a personal access token and stores its owner and scopes in the context.
+func (a *Api) authenticateAccessToken(c *gin.Context, secret string) bool {
+	accessToken, user, err := a.validateAccessToken(secret)
+	if err != nil {
+		a.logger.Error("failed to validate access token", zap.Error(err))
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		c.Abort()
+		return false
 	}
 
-	// Set the user object in the context
 	c.Set("user_id", user.ID)
 	c.Set("username", user.Username)
+	c.Set("role", user.Role)
+	c.Set("scopes", accessToken.Scopes)
+	c.Set("access_token_id", accessToken.ID)
+	return true
+}
 
-	c.Next()
+// RequireRole restricts a route to users holding one of roles. It must run after AuthMiddleware.
+func (a *Api) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, r := range roles {
+			if role == r {
+				c.Next()
+				return
+			}
+		}
+
+		a.logger.Warn("role not allowed", zap.Int("user_id", c.GetInt("user_id")), zap.String("role", role), zap.Strings("required", roles))
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		c.Abort()
+	}
 }
 
-func (a *Api) PrometheusAuthMiddleware(c *gin.Context) {
-	apiKey := c.GetHeader("authorization")
+// RequirePermission restricts a route to users whose role grants permission and, for personal
+// access tokens, whose token is scoped to it. It must run after AuthMiddleware.
+func (a *Api) RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed := rbac.Allows(c.GetString("role"), permission)
+		if scopes, ok := c.Get("scopes"); ok {
+			allowed = allowed && slices.Contains(scopes.([]string), permission)
+		}
+
+		if !allowed {
+			a.logger.Warn("permission denied", zap.Int("user_id", c.GetInt("user_id")), zap.String("permission", permission))
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AdminMiddleware authenticates admin routes either with the api_key header or with a
+// bearer token whose role grants permission.
+func (a *Api) AdminMiddleware(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("api_key") != "" {
+			a.APIKeyMiddleware(c)
+			return
+		}
+
+		if !a.authenticate(c) {
+			return
+		}
+
+		a.RequirePermission(permission)(c)
+	}
+}
 
-	if !strings.Contains(apiKey, "Bearer") {
-		c.JSON(401, gin.H{"error": "Authorization header must start with Bearer"})
+// PrometheusAuthMiddleware restricts the metrics server to bearer tokens holding a metrics service credential.
+func (a *Api) PrometheusAuthMiddleware(c *gin.Context) {
+	key, err := bearerToken(c.GetHeader("Authorization"))
+	if err != nil {
+		c.JSON(401, gin.H{"error": err.Error()})
 		c.Abort()
 		return
 	}
 
-	apiKey = strings.Split(apiKey, "Bearer ")[1]
+	a.requireCredential(c, credentials.PurposeMetrics, key)
+}
+
+// APIKeyMiddleware restricts access to requests whose api_key header holds an admin service credential.
+func (a *Api) APIKeyMiddleware(c *gin.Context) {
+	a.requireCredential(c, credentials.PurposeAdmin, strings.TrimSpace(c.GetHeader("api_key")))
+}
 
-	if apiKey != a.prometheusKey {
+// requireCredential continues the request when key is a valid service credential for
+// purpose and responds with 401 otherwise.
+func (a *Api) requireCredential(c *gin.Context, purpose, key string) {
+	credential, err := a.credentials.Verify(purpose, key)
+	if err != nil {
+		if err != credentials.ErrInvalidCredential {
+			a.logger.Error("failed to verify service credential", zap.Error(err))
+		}
 		c.JSON(401, gin.H{"error": "Unauthorized"})
 		c.Abort()
 		return
 	}
 
+	c.Set("service_credential", credential.Name)
+	c.Next()
 }
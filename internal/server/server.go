@@ -1,32 +1,71 @@
 package server
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/access"
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
 	"github.com/VicSobDev/anniversaryAPI/internal/auth"
+	"github.com/VicSobDev/anniversaryAPI/internal/credentials"
+	"github.com/VicSobDev/anniversaryAPI/internal/keyring"
+	"github.com/VicSobDev/anniversaryAPI/internal/lockout"
 	"github.com/VicSobDev/anniversaryAPI/internal/pictures"
+	"github.com/VicSobDev/anniversaryAPI/internal/rbac"
+	"github.com/VicSobDev/anniversaryAPI/internal/revocation"
+	"github.com/VicSobDev/anniversaryAPI/internal/token"
+	"github.com/VicSobDev/anniversaryAPI/internal/users"
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
 	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
 	"github.com/VicSobDev/anniversaryAPI/pkg/db"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
 // Api struct definition
 type Api struct {
-	listenAddr    string
-	jwtKey        []byte
-	prometheusKey string
-	apiKey        string
-	logger        *zap.Logger
+	listenAddr     string
+	keyConfig      keyring.Config
+	hashConfig     crypto.Argon2Config
+	picturesConfig pictures.Config
+	tokenConfig    token.Config
+	prometheusKey  string
+	apiKey         string
+	totpKey        string
+	webAuthn       *webauthn.Config
+	location       *time.Location
+	retention      time.Duration
+	trustedProxies []string
+	logger         *zap.Logger
+	db             *db.SQLiteDB
+	revocations    *revocation.Store
+	credentials    *credentials.Store
+	audit          *audit.Recorder
+	guard          *lockout.Guard
+	keyring        *keyring.Keyring
+	tokens         *token.Manager
+	clock          clock.Clock
 }
 
 // NewApi constructor
-func NewApi(listenAddr string, jwtKey []byte, prometheusKey string, apiKey string) *Api {
+func NewApi(listenAddr string, keyConfig keyring.Config, hashConfig crypto.Argon2Config, picturesConfig pictures.Config, tokenConfig token.Config, prometheusKey string, apiKey string, totpKey string, webAuthn *webauthn.Config, location *time.Location, auditRetention time.Duration, trustedProxies []string) *Api {
 	return &Api{
-		listenAddr:    listenAddr,
-		jwtKey:        jwtKey,
-		prometheusKey: prometheusKey,
-		apiKey:        apiKey,
+		listenAddr:     listenAddr,
+		keyConfig:      keyConfig,
+		hashConfig:     hashConfig,
+		picturesConfig: picturesConfig,
+		tokenConfig:    tokenConfig,
+		prometheusKey:  prometheusKey,
+		apiKey:         apiKey,
+		totpKey:        totpKey,
+		webAuthn:       webAuthn,
+		location:       location,
+		retention:      auditRetention,
+		trustedProxies: trustedProxies,
+		clock:          clock.System{},
 	}
 }
 
@@ -45,12 +84,69 @@ func (a *Api) Start() error {
 		return err
 	}
 
+	a.db = sqliteDB
+
+	// Create the first admin's registration key on a fresh install
+	if err := a.ensureBootstrapKey(sqliteDB); err != nil {
+		return err
+	}
+
+	// Initialize the JWT signing keyring
+	keys, err := a.initializeKeyring(sqliteDB, logger)
+	if err != nil {
+		return err
+	}
+
+	a.keyring = keys
+	a.tokens = token.NewManager(keys, a.tokenConfig, a.clock)
+
+	// Initialize the token revocation store
+	revocations, err := a.initializeRevocationStore(sqliteDB, logger)
+	if err != nil {
+		return err
+	}
+
+	a.revocations = revocations
+
+	// Initialize the service credentials used by admin scripts and the metrics scraper
+	creds, err := a.initializeCredentialStore(sqliteDB)
+	if err != nil {
+		return err
+	}
+
+	a.credentials = creds
+
+	// Initialize the audit log
+	a.audit = a.initializeAuditRecorder(sqliteDB, logger)
+
+	// Initialize the login lockout guard
+	a.guard = a.initializeLockoutGuard(sqliteDB, logger)
+
+	// Initialize the access rules engine
+	engine, err := a.initializeAccessEngine(sqliteDB)
+	if err != nil {
+		return err
+	}
+
 	// Initialize services
-	argon := a.initializeCryptoService()
-	picturesService, authService := a.initializeServices(sqliteDB, argon, logger)
+	hasher := a.initializeCryptoService()
+	cipher, err := a.initializeCipher()
+	if err != nil {
+		return err
+	}
+
+	webAuthn, err := a.initializeWebAuthn()
+	if err != nil {
+		return err
+	}
+
+	picturesService, authService, accessService, usersService, auditService := a.initializeServices(sqliteDB, hasher, cipher, webAuthn, engine, logger)
 
 	// Setup and start the API server
-	r := a.setupServer(logger, picturesService, authService)
+	r, err := a.setupServer(logger, picturesService, authService, accessService, usersService, auditService)
+	if err != nil {
+		return err
+	}
 	return r.Run(a.listenAddr)
 }
 
@@ -73,38 +169,136 @@ func (a *Api) initializeDatabase() (*db.SQLiteDB, error) {
 	return sqliteDB, nil
 }
 
-// initializeCryptoService sets up the crypto service
-func (a *Api) initializeCryptoService() *crypto.Argon2 {
-	return crypto.NewArgon2(crypto.Argon2Config{
-		Time:    1,
-		Memory:  64 * 1024,
-		Threads: 4,
-		KeyLen:  32,
-	})
+// initializeKeyring loads the signing keys and starts scheduled rotation
+func (a *Api) initializeKeyring(sqliteDB *db.SQLiteDB, logger *zap.Logger) (*keyring.Keyring, error) {
+	keys, err := keyring.New(sqliteDB, a.keyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := keys.Load(); err != nil {
+		return nil, err
+	}
+
+	keys.StartRotation(time.Hour, logger)
+	return keys, nil
+}
+
+// initializeRevocationStore loads revoked tokens and starts pruning expired ones
+func (a *Api) initializeRevocationStore(sqliteDB *db.SQLiteDB, logger *zap.Logger) (*revocation.Store, error) {
+	store := revocation.NewStore(sqliteDB)
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+
+	store.StartPruning(time.Hour, logger)
+	return store, nil
+}
+
+// initializeCredentialStore loads the service credentials and registers the keys given by
+// the API_KEY and PROMETHEUS_KEY environment variables, if set
+func (a *Api) initializeCredentialStore(sqliteDB *db.SQLiteDB) (*credentials.Store, error) {
+	store := credentials.NewStore(sqliteDB, a.clock)
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+
+	if err := store.Sync(credentials.EnvAdminCredential, credentials.PurposeAdmin, a.apiKey); err != nil {
+		return nil, err
+	}
+	if err := store.Sync(credentials.EnvMetricsCredential, credentials.PurposeMetrics, a.prometheusKey); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// initializeAuditRecorder sets up the audit log and starts deleting events past the retention period
+func (a *Api) initializeAuditRecorder(sqliteDB *db.SQLiteDB, logger *zap.Logger) *audit.Recorder {
+	recorder := audit.NewRecorder(sqliteDB, a.clock, logger, a.retention)
+	recorder.StartPruning(time.Hour, logger)
+	return recorder
+}
+
+// initializeLockoutGuard sets up failed login tracking and starts pruning stale failures
+func (a *Api) initializeLockoutGuard(sqliteDB *db.SQLiteDB, logger *zap.Logger) *lockout.Guard {
+	guard := lockout.NewGuard(sqliteDB, a.clock, lockout.DefaultPolicies)
+	guard.StartPruning(time.Hour, logger)
+	return guard
+}
+
+// initializeAccessEngine sets up the access rules engine and loads the stored rules
+func (a *Api) initializeAccessEngine(sqliteDB *db.SQLiteDB) (*access.Engine, error) {
+	engine := access.NewEngine(sqliteDB, a.location, a.clock)
+	if err := engine.Reload(); err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+// initializeCryptoService sets up the password hasher with the configured hashing parameters.
+// Imported bcrypt, scrypt and PBKDF2 hashes are verified and replaced on the next login.
+func (a *Api) initializeCryptoService() crypto.PasswordHasher {
+	return crypto.NewPasswordHasher(crypto.NewArgon2(a.hashConfig))
+}
+
+// initializeCipher sets up the cipher encrypting TOTP secrets; two-factor
+// authentication is unavailable when no key is configured
+func (a *Api) initializeCipher() (*crypto.AESGCM, error) {
+	if a.totpKey == "" {
+		a.logger.Warn("TOTP_ENCRYPTION_KEY is not set, two-factor authentication is disabled")
+		return nil, nil
+	}
+
+	return crypto.NewAESGCM(a.totpKey)
+}
+
+// initializeWebAuthn sets up the passkey relying party; passkeys are
+// unavailable when no relying party is configured
+func (a *Api) initializeWebAuthn() (*webauthn.WebAuthn, error) {
+	if a.webAuthn == nil {
+		a.logger.Warn("WEBAUTHN_RP_ID is not set, passkeys are disabled")
+		return nil, nil
+	}
+
+	return webauthn.New(a.webAuthn)
 }
 
 // initializeServices sets up the application services
-func (a *Api) initializeServices(sqliteDB *db.SQLiteDB, argon *crypto.Argon2, logger *zap.Logger) (*pictures.PicturesService, *auth.AuthService) {
-	picturesService := pictures.NewPicturesService("images", logger, sqliteDB)
-	authService := auth.NewAuthService(logger, sqliteDB, argon, a.jwtKey, a.apiKey)
-	return picturesService, authService
+func (a *Api) initializeServices(sqliteDB *db.SQLiteDB, hasher crypto.PasswordHasher, cipher *crypto.AESGCM, webAuthn *webauthn.WebAuthn, engine *access.Engine, logger *zap.Logger) (*pictures.PicturesService, *auth.AuthService, *access.AccessService, *users.UsersService, *audit.AuditService) {
+	picturesService := pictures.NewPicturesService("images", logger, sqliteDB, engine, a.audit, a.picturesConfig)
+	authService := auth.NewAuthService(logger, sqliteDB, hasher, a.revocations, a.guard, a.credentials, a.audit, a.keyring, a.tokens, cipher, webAuthn, a.clock)
+	accessService := access.NewAccessService(logger, sqliteDB, engine)
+	usersService := users.NewUsersService(picturesService, logger, sqliteDB, a.revocations, hasher, a.audit, a.clock)
+	auditService := audit.NewAuditService(logger, sqliteDB)
+	return picturesService, authService, accessService, usersService, auditService
 }
 
 // setupServer configures and returns the Gin server
-func (a *Api) setupServer(logger *zap.Logger, picturesService *pictures.PicturesService, authService *auth.AuthService) *gin.Engine {
+func (a *Api) setupServer(logger *zap.Logger, picturesService *pictures.PicturesService, authService *auth.AuthService, accessService *access.AccessService, usersService *users.UsersService, auditService *audit.AuditService) (*gin.Engine, error) {
 	// Create a new Gin router
 	r := gin.Default()
 
+	// The client IP keys login lockouts and the audit log, so X-Forwarded-For is only
+	// honoured from the configured reverse proxies
+	if err := r.SetTrustedProxies(a.trustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
 	// Configure and apply CORS middleware
 	r.Use(a.configureCORS())
 
+	// Tag every request with an ID for logs and the audit trail
+	r.Use(a.RequestIDMiddleware)
+
 	// Setup API routes
-	a.setupRoutes(r, authService, picturesService)
+	a.setupRoutes(r, authService, picturesService, accessService, usersService, auditService)
 
 	// Setup and run the metrics server in a separate goroutine
 	a.setupMetricsServer(logger)
 
-	return r
+	return r, nil
 }
 
 // configureCORS returns the CORS middleware configuration
@@ -112,14 +306,17 @@ func (a *Api) configureCORS() gin.HandlerFunc {
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"*"} // Customize as needed
 	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"}
-	config.ExposeHeaders = []string{"Content-Length"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", requestIDHeader}
+	config.ExposeHeaders = []string{"Content-Length", "Retry-After", requestIDHeader}
 	config.AllowCredentials = true
 	return cors.New(config)
 }
 
 // setupRoutes configures the API endpoints
-func (a *Api) setupRoutes(r *gin.Engine, authService *auth.AuthService, picturesService *pictures.PicturesService) {
+func (a *Api) setupRoutes(r *gin.Engine, authService *auth.AuthService, picturesService *pictures.PicturesService, accessService *access.AccessService, usersService *users.UsersService, auditService *audit.AuditService) {
+	// Public keys for verifying issued tokens
+	r.GET("/.well-known/jwks.json", authService.JWKS)
+
 	api := r.Group("/api")
 
 	// Authentication routes
@@ -127,19 +324,89 @@ func (a *Api) setupRoutes(r *gin.Engine, authService *auth.AuthService, pictures
 	{
 		authRoutes.POST("/register", authService.Register)
 		authRoutes.POST("/login", authService.Login)
-		authRoutes.GET("/refresh", authService.Refresh)
-		authRoutes.POST("/keys", authService.AddKey)
-		authRoutes.GET("/keys", authService.GetKeys)
+		authRoutes.POST("/login/2fa", authService.LoginMFA)
+		authRoutes.POST("/refresh", authService.Refresh)
+		authRoutes.POST("/keys", a.AdminMiddleware(rbac.KeysAdmin), authService.AddKey)
+		authRoutes.GET("/keys", a.AdminMiddleware(rbac.KeysAdmin), authService.GetKeys)
+		authRoutes.DELETE("/keys/:id", a.AdminMiddleware(rbac.KeysAdmin), authService.DeleteKey)
+		authRoutes.POST("/logout", a.SessionMiddleware, authService.Logout)
+		authRoutes.POST("/logout-all", a.SessionMiddleware, authService.LogoutAll)
+		authRoutes.PUT("/password", a.SessionMiddleware, authService.ChangePassword)
+		authRoutes.POST("/password/reset", authService.ResetPassword)
+		authRoutes.POST("/signing-keys/rotate", a.AdminMiddleware(rbac.KeysAdmin), authService.RotateSigningKey)
+		// Credentials can act as an admin, so only an admin's login session may create them
+		authRoutes.POST("/service-credentials", a.SessionMiddleware, a.RequirePermission(rbac.UsersAdmin), authService.CreateServiceCredential)
+		authRoutes.GET("/service-credentials", a.AdminMiddleware(rbac.KeysAdmin), authService.GetServiceCredentials)
+		authRoutes.DELETE("/service-credentials/:id", a.AdminMiddleware(rbac.KeysAdmin), authService.DeleteServiceCredential)
+		authRoutes.GET("/lockouts", a.AdminMiddleware(rbac.UsersAdmin), authService.GetLockouts)
+		authRoutes.DELETE("/lockouts/:scope/:identifier", a.AdminMiddleware(rbac.UsersAdmin), authService.Unlock)
+	}
 
+	// Two-factor authentication routes
+	twoFactorRoutes := authRoutes.Group("/2fa", a.SessionMiddleware)
+	{
+		twoFactorRoutes.POST("/setup", authService.SetupTwoFactor)
+		twoFactorRoutes.POST("/verify", authService.VerifyTwoFactor)
+		twoFactorRoutes.POST("/disable", authService.DisableTwoFactor)
+	}
+
+	// Passkey (WebAuthn) routes
+	passkeyRoutes := authRoutes.Group("/passkeys")
+	{
+		passkeyRoutes.POST("/login/begin", authService.BeginPasskeyLogin)
+		passkeyRoutes.POST("/login/finish", authService.FinishPasskeyLogin)
+		passkeyRoutes.POST("/register/begin", a.SessionMiddleware, authService.BeginPasskeyRegistration)
+		passkeyRoutes.POST("/register/finish", a.SessionMiddleware, authService.FinishPasskeyRegistration)
+		passkeyRoutes.GET("", a.SessionMiddleware, authService.GetPasskeys)
+		passkeyRoutes.DELETE("/:id", a.SessionMiddleware, authService.DeletePasskey)
+	}
+
+	// Personal access token routes; a token cannot be used to create or revoke tokens
+	accessTokenRoutes := authRoutes.Group("/tokens", a.SessionMiddleware)
+	{
+		accessTokenRoutes.POST("", authService.CreateAccessToken)
+		accessTokenRoutes.GET("", authService.GetAccessTokens)
+		accessTokenRoutes.DELETE("/:id", authService.DeleteAccessToken)
+	}
+
+	// Access rule administration routes
+	accessRoutes := api.Group("/access/rules", a.AdminMiddleware(rbac.AccessAdmin))
+	{
+		accessRoutes.GET("", accessService.GetRules)
+		accessRoutes.POST("", accessService.CreateRule)
+		accessRoutes.PUT("/:id", accessService.UpdateRule)
+		accessRoutes.DELETE("/:id", accessService.DeleteRule)
+	}
+
+	// User administration routes
+	userRoutes := api.Group("/admin/users", a.AdminMiddleware(rbac.UsersAdmin))
+	{
+		userRoutes.GET("", usersService.GetUsers)
+		userRoutes.POST("/import", usersService.ImportUsers)
+		userRoutes.GET("/:id", usersService.GetUser)
+		userRoutes.POST("/:id/disable", usersService.DisableUser)
+		userRoutes.POST("/:id/enable", usersService.EnableUser)
+		userRoutes.POST("/:id/password-reset", usersService.ForcePasswordReset)
+		userRoutes.PUT("/:id/role", usersService.SetRole)
+		userRoutes.DELETE("/:id", usersService.DeleteUser)
+	}
+
+	// Audit log routes
+	auditRoutes := api.Group("/admin/audit", a.AdminMiddleware(rbac.AuditRead))
+	{
+		auditRoutes.GET("", auditService.GetEvents)
+		auditRoutes.GET("/export", auditService.ExportEvents)
 	}
 
 	// Protected routes
 	api.Use(a.AuthMiddleware)
 	{
-		api.GET("/pictures", picturesService.GetPictures)
-		api.GET("/picture", picturesService.GetPicture)
-		api.POST("/pictures", picturesService.UploadPictures)
-		api.GET("/pictures_total", picturesService.GetTotalPictures)
+		api.GET("/pictures", a.RequirePermission(rbac.PicturesRead), picturesService.GetPictures)
+		api.GET("/picture", a.RequirePermission(rbac.PicturesRead), picturesService.GetPicture)
+		api.POST("/pictures", a.RequirePermission(rbac.PicturesWrite), picturesService.UploadPictures)
+		api.DELETE("/pictures/:id", a.RequirePermission(rbac.PicturesDelete), picturesService.DeletePicture)
+		api.GET("/pictures_total", a.RequirePermission(rbac.PicturesRead), picturesService.GetTotalPictures)
+		api.GET("/unlock-status", accessService.UnlockStatus)
 	}
 
 }
@@ -148,6 +415,10 @@ func (a *Api) setupRoutes(r *gin.Engine, authService *auth.AuthService, pictures
 func (a *Api) setupMetricsServer(logger *zap.Logger) {
 	go func() {
 		metricsRouter := gin.Default()
+		if err := metricsRouter.SetTrustedProxies(a.trustedProxies); err != nil {
+			logger.Error("Failed to start metrics server", zap.Error(err))
+			return
+		}
 		metricsRouter.Use(a.PrometheusAuthMiddleware)
 
 		metricsRouter.GET("/metrics", gin.WrapH(promhttp.Handler()))
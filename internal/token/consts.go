@@ -0,0 +1,31 @@
+package token
+
+import (
+	"errors"
+	"time"
+)
+
+// Defaults applied to zero Config fields.
+const (
+	DefaultIssuer   = "anniversaryAPI"
+	DefaultAudience = "anniversaryAPI"
+	DefaultTTL      = 15 * time.Minute
+	DefaultLeeway   = 30 * time.Second
+)
+
+// ChallengeTTL is the lifetime of the MFA challenge token returned by the first login step.
+const ChallengeTTL = 5 * time.Minute
+
+// challengeAudienceSuffix gives challenge tokens their own audience, so they are
+// never accepted where an access token is expected.
+const challengeAudienceSuffix = "/mfa"
+
+// Compatibility policy: tokens must carry a kid naming a key still held by the
+// keyring, an exp, and the configured iss and aud. Tokens signed by the keyring
+// before the move to golang-jwt/jwt remain valid until they expire, since the
+// wire format is unchanged. Tokens issued before the keyring existed have no kid
+// and are rejected; clients recover by refreshing or logging in again.
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrMissingClaims = errors.New("token is missing required claims")
+)
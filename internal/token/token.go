@@ -0,0 +1,130 @@
+package token
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/keyring"
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// NewManager creates a Manager signing with keys, filling unset config fields with defaults.
+func NewManager(keys *keyring.Keyring, config Config, c clock.Clock) *Manager {
+	if config.Issuer == "" {
+		config.Issuer = DefaultIssuer
+	}
+	if config.Audience == "" {
+		config.Audience = DefaultAudience
+	}
+	if config.TTL == 0 {
+		config.TTL = DefaultTTL
+	}
+	if config.Leeway == 0 {
+		config.Leeway = DefaultLeeway
+	}
+	if c == nil {
+		c = clock.System{}
+	}
+
+	return &Manager{
+		config:          config,
+		keyring:         keys,
+		clock:           c,
+		parser:          newParser(config, config.Audience, c),
+		challengeParser: newParser(config, config.Audience+challengeAudienceSuffix, c),
+	}
+}
+
+// newParser builds a strict parser: only the keyring's algorithms, exp is mandatory,
+// and iat, nbf, iss and aud are all checked with the configured leeway.
+func newParser(config Config, audience string, c clock.Clock) *jwt.Parser {
+	return jwt.NewParser(
+		jwt.WithValidMethods(keyring.Algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(config.Leeway),
+		jwt.WithTimeFunc(c.Now),
+		jwt.WithStrictDecoding(),
+	)
+}
+
+// TTL returns the lifetime of issued tokens.
+func (m *Manager) TTL() time.Duration {
+	return m.config.TTL
+}
+
+// Issue creates and signs an access token for the user.
+func (m *Manager) Issue(userID int, username, role string, generation int) (string, *Claims, error) {
+	return m.issue(userID, username, role, generation, m.config.Audience, m.config.TTL)
+}
+
+// IssueChallenge creates a short-lived token proving the user passed the password
+// step of login; it can only be redeemed for an access token with a second factor.
+func (m *Manager) IssueChallenge(userID int, username string) (string, *Claims, error) {
+	return m.issue(userID, username, "", 0, m.config.Audience+challengeAudienceSuffix, ChallengeTTL)
+}
+
+func (m *Manager) issue(userID int, username, role string, generation int, audience string, ttl time.Duration) (string, *Claims, error) {
+	now := m.clock.Now()
+
+	claims := &Claims{
+		Username:   username,
+		UserID:     userID,
+		Role:       role,
+		Generation: generation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    m.config.Issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	tokenString, err := m.keyring.Sign(claims)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return tokenString, claims, nil
+}
+
+// Parse verifies an access token's signature and claims and returns the claims if it is valid.
+func (m *Manager) Parse(tokenString string) (*Claims, error) {
+	return m.parse(m.parser, tokenString)
+}
+
+// ParseChallenge verifies an MFA challenge token and returns its claims.
+func (m *Manager) ParseChallenge(tokenString string) (*Claims, error) {
+	return m.parse(m.challengeParser, tokenString)
+}
+
+func (m *Manager) parse(parser *jwt.Parser, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if _, err := parser.ParseWithClaims(tokenString, claims, m.keyring.Keyfunc); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return claims, nil
+}
+
+// Validate checks the application claims; registered claims are validated by the parser.
+func (c *Claims) Validate() error {
+	if c.ID == "" || c.UserID == 0 {
+		return ErrMissingClaims
+	}
+	return nil
+}
+
+// Expiry returns the expiry time of the token.
+func (c *Claims) Expiry() time.Time {
+	if c.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return c.ExpiresAt.Time
+}
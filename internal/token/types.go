@@ -0,0 +1,38 @@
+package token
+
+import (
+	"time"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/keyring"
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config controls the claims of issued tokens and how strictly they are validated.
+type Config struct {
+	Issuer   string
+	Audience string
+	// TTL is the lifetime of issued access tokens.
+	TTL time.Duration
+	// Leeway tolerates clock skew between issuer and verifier for exp, nbf and iat.
+	Leeway time.Duration
+}
+
+// Claims are the claims carried by access tokens.
+type Claims struct {
+	Username   string `json:"username"`
+	UserID     int    `json:"user_id"`
+	Role       string `json:"role,omitempty"`
+	Generation int    `json:"gen"`
+	jwt.RegisteredClaims
+}
+
+// Manager issues and verifies access tokens signed by a keyring.
+type Manager struct {
+	config  Config
+	keyring *keyring.Keyring
+	clock   clock.Clock
+	parser  *jwt.Parser
+	// challengeParser validates MFA challenge tokens
+	challengeParser *jwt.Parser
+}
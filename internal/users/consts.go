@@ -0,0 +1,28 @@
+package users
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	// maxImportSize limits the number of users imported per request
+	maxImportSize = 1000
+
+	// passwordResetTokenTTL is how long an admin-issued reset token can be redeemed
+	passwordResetTokenTTL = 24 * time.Hour
+)
+
+var (
+	errUserNotFound  = errors.New("user not found")
+	errSelfAction    = errors.New("admins cannot disable, demote or delete themselves")
+	errInvalidRole   = errors.New("role must be member or admin")
+	errInvalidImages = errors.New("images must be delete or reassign")
+	errInvalidTarget = errors.New("images can only be reassigned to another existing user")
+	errInvalidImport = errors.New("users must list between 1 and 1000 users")
+	errUsernameEmpty = errors.New("username is required")
+	errImportFailed  = errors.New("failed to import user")
+)
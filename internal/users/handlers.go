@@ -0,0 +1,353 @@
+package users
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// GetUsers lists users a page at a time, with their upload counts and last login.
+func (svc *UsersService) GetUsers(c *gin.Context) {
+	svc.logger.Info("GetUsers called")
+
+	limit, offset := parsePagination(c)
+
+	users, err := svc.db.ListUsers(limit, offset)
+	if err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to list users"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	total, err := svc.db.CountUsers()
+	if err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to count users"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if users == nil {
+		users = []db.UserInfo{}
+	}
+
+	userRequests.WithLabelValues("successful").Inc()
+	c.JSON(http.StatusOK, gin.H{"users": users, "total": total, "limit": limit, "offset": offset})
+}
+
+// GetUser returns the details of a single user.
+func (svc *UsersService) GetUser(c *gin.Context) {
+	svc.logger.Info("GetUser called")
+
+	id, err := parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := svc.db.GetUserInfo(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+			return
+		}
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	userRequests.WithLabelValues("successful").Inc()
+	c.JSON(http.StatusOK, user)
+}
+
+// DisableUser blocks a user from logging in and rejects the tokens they already hold.
+func (svc *UsersService) DisableUser(c *gin.Context) {
+	svc.logger.Info("DisableUser called")
+	svc.setDisabled(c, true)
+}
+
+// EnableUser lets a disabled user log in again. Tokens revoked while disabled stay revoked.
+func (svc *UsersService) EnableUser(c *gin.Context) {
+	svc.logger.Info("EnableUser called")
+	svc.setDisabled(c, false)
+}
+
+func (svc *UsersService) setDisabled(c *gin.Context, disabled bool) {
+	id, err := parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if disabled && isSelf(c, id) {
+		userRequests.WithLabelValues("self_action").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errSelfAction.Error()})
+		return
+	}
+
+	found, err := svc.revocations.SetDisabled(id, disabled)
+	if err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to update user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+		return
+	}
+
+	// Sessions of a disabled user must not come back when the user is enabled again
+	if disabled {
+		if err := svc.revokeAllSessions(id); err != nil {
+			svc.ErrorHandler(userRequests, err, zap.String("error", "failed to revoke sessions"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+	}
+
+	action := audit.ActionUserEnable
+	if disabled {
+		action = audit.ActionUserDisable
+	}
+
+	userRequests.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{Action: action, Outcome: audit.OutcomeSuccess, Target: fmt.Sprintf("user:%d", id)})
+	svc.logger.Info("user disabled state changed", zap.Int("user_id", id), zap.Bool("disabled", disabled), zap.Int("changed_by", c.GetInt("user_id")))
+	c.JSON(http.StatusOK, gin.H{"id": id, "disabled": disabled})
+}
+
+// ForcePasswordReset blocks password login for a user, revokes their sessions and returns
+// a reset token the admin hands to the user.
+func (svc *UsersService) ForcePasswordReset(c *gin.Context) {
+	svc.logger.Info("ForcePasswordReset called")
+
+	id, err := parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := svc.db.GetUserByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+			return
+		}
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	resetToken, err := crypto.GenerateToken()
+	if err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to generate reset token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	now := svc.clock.Now()
+	expiresAt := now.Add(passwordResetTokenTTL)
+	if err := svc.db.CreatePasswordResetToken(id, crypto.HashToken(resetToken), now, expiresAt); err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to store reset token"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := svc.db.RequirePasswordReset(id); err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to require password reset"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	if err := svc.revokeAllSessions(id); err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to revoke sessions"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	userRequests.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionUserPasswordReset, Outcome: audit.OutcomeSuccess, Target: fmt.Sprintf("user:%d", id)})
+	svc.logger.Info("password reset forced", zap.Int("user_id", id), zap.Int("forced_by", c.GetInt("user_id")))
+	c.JSON(http.StatusCreated, gin.H{"reset_token": resetToken, "expires_at": expiresAt})
+}
+
+// SetRole changes a user's role. Outstanding tokens are invalidated so the new role
+// takes effect on the user's next refresh.
+func (svc *UsersService) SetRole(c *gin.Context) {
+	svc.logger.Info("SetRole called")
+
+	id, err := parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		userRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if !db.ValidRole(req.Role) {
+		userRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidRole.Error()})
+		return
+	}
+
+	if isSelf(c, id) && req.Role != db.RoleAdmin {
+		userRequests.WithLabelValues("self_action").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errSelfAction.Error()})
+		return
+	}
+
+	found, err := svc.db.SetUserRole(id, req.Role)
+	if err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to update role"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+		return
+	}
+
+	// Access tokens carry the role, so the old ones must not outlive the change
+	if _, err := svc.revocations.BumpGeneration(id); err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to bump token generation"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	userRequests.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{Action: audit.ActionUserRole, Outcome: audit.OutcomeSuccess, Target: fmt.Sprintf("user:%d", id), Details: map[string]any{"role": req.Role}})
+	svc.logger.Info("user role changed", zap.Int("user_id", id), zap.String("role", req.Role), zap.Int("changed_by", c.GetInt("user_id")))
+	c.JSON(http.StatusOK, gin.H{"id": id, "role": req.Role})
+}
+
+// DeleteUser deletes a user with their sessions and credentials. The images query parameter
+// chooses whether their pictures are deleted or reassigned to the user given by to.
+func (svc *UsersService) DeleteUser(c *gin.Context) {
+	svc.logger.Info("DeleteUser called")
+
+	id, err := parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if isSelf(c, id) {
+		userRequests.WithLabelValues("self_action").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errSelfAction.Error()})
+		return
+	}
+
+	reassignTo := 0
+	switch c.Query("images") {
+	case "delete":
+	case "reassign":
+		reassignTo, err = strconv.Atoi(c.Query("to"))
+		if err != nil || reassignTo <= 0 || reassignTo == id {
+			userRequests.WithLabelValues("invalid_request").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidTarget.Error()})
+			return
+		}
+		if _, err := svc.db.GetUserByID(reassignTo); err != nil {
+			if err == sql.ErrNoRows {
+				userRequests.WithLabelValues("invalid_request").Inc()
+				c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidTarget.Error()})
+				return
+			}
+			svc.ErrorHandler(userRequests, err, zap.String("error", "failed to get user"))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+	default:
+		userRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidImages.Error()})
+		return
+	}
+
+	if _, err := svc.db.GetUserByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": errUserNotFound.Error()})
+			return
+		}
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to get user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Files of the deleted images are removed unless another image still uses them
+	var deleted int
+	err = svc.pictures.ReleaseFiles(func() ([]db.Image, error) {
+		var unused []db.Image
+		var err error
+		deleted, unused, err = svc.db.DeleteUser(id, reassignTo)
+		return unused, err
+	})
+	if err != nil {
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to delete user"))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return
+	}
+
+	// Tokens of the deleted user now fail the generation lookup
+	svc.revocations.Forget(id)
+
+	userRequests.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{
+		Action:  audit.ActionUserDelete,
+		Outcome: audit.OutcomeSuccess,
+		Target:  fmt.Sprintf("user:%d", id),
+		Details: map[string]any{"images_deleted": deleted, "images_reassigned_to": reassignTo},
+	})
+	svc.logger.Info("user deleted", zap.Int("user_id", id), zap.Int("images_deleted", deleted), zap.Int("images_reassigned_to", reassignTo), zap.Int("deleted_by", c.GetInt("user_id")))
+	c.JSON(http.StatusOK, gin.H{"message": "user deleted", "images_deleted": deleted})
+}
+
+// ImportUsers creates users with password hashes exported from another system. Argon2id,
+// bcrypt, scrypt and PBKDF2 hashes are accepted; the others are replaced by Argon2id on
+// the user's next login. Users that cannot be imported are reported without failing the rest.
+func (svc *UsersService) ImportUsers(c *gin.Context) {
+	svc.logger.Info("ImportUsers called")
+
+	var req ImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		userRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	if len(req.Users) == 0 || len(req.Users) > maxImportSize {
+		userRequests.WithLabelValues("invalid_request").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidImport.Error()})
+		return
+	}
+
+	imported := []string{}
+	failed := []ImportFailure{}
+	for _, u := range req.Users {
+		username := strings.ToLower(u.Username)
+		if err := svc.importUser(username, u.PasswordHash, u.Role); err != nil {
+			failed = append(failed, ImportFailure{Username: username, Error: err.Error()})
+			continue
+		}
+		imported = append(imported, username)
+	}
+
+	userRequests.WithLabelValues("successful").Inc()
+	svc.audit.Record(c, audit.Event{
+		Action:  audit.ActionUserImport,
+		Outcome: audit.OutcomeSuccess,
+		Details: map[string]any{"imported": imported, "failed": len(failed)},
+	})
+	svc.logger.Info("users imported", zap.Int("imported", len(imported)), zap.Int("failed", len(failed)), zap.Int("imported_by", c.GetInt("user_id")))
+	c.JSON(http.StatusOK, gin.H{"imported": imported, "failed": failed})
+}
@@ -0,0 +1,88 @@
+package users
+
+import (
+	"strconv"
+
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ErrorHandler increments a Prometheus counter for tracking errors and logs the error with additional fields.
+func (svc *UsersService) ErrorHandler(cv *prometheus.CounterVec, err error, fields ...zapcore.Field) {
+	cv.WithLabelValues("error").Inc()
+	svc.logger.Error(err.Error(), fields...)
+}
+
+// parseUserID extracts the user ID from the route parameters.
+func parseUserID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		return 0, errUserNotFound
+	}
+	return id, nil
+}
+
+// parsePagination reads limit and offset from the query, falling back to defaults on invalid values.
+func parsePagination(c *gin.Context) (int, int) {
+	limit, offset := defaultPageSize, 0
+
+	if v, err := strconv.Atoi(c.Query("limit")); err == nil && v > 0 {
+		limit = min(v, maxPageSize)
+	}
+	if v, err := strconv.Atoi(c.Query("offset")); err == nil && v >= 0 {
+		offset = v
+	}
+
+	return limit, offset
+}
+
+// isSelf reports whether the target is the admin making the request. Requests
+// authenticated with the api_key header carry no user.
+func isSelf(c *gin.Context, userID int) bool {
+	return c.GetInt("user_id") == userID
+}
+
+// revokeAllSessions bumps the user's token generation and revokes all of their refresh
+// and personal access tokens.
+func (svc *UsersService) revokeAllSessions(userID int) error {
+	if _, err := svc.revocations.BumpGeneration(userID); err != nil {
+		return err
+	}
+	if err := svc.db.RevokeUserRefreshTokens(userID, svc.clock.Now()); err != nil {
+		return err
+	}
+	return svc.db.DeleteUserAccessTokens(userID)
+}
+
+// importUser validates and creates one imported user. Errors are safe to report to the admin.
+func (svc *UsersService) importUser(username, passwordHash, role string) error {
+	if username == "" {
+		return errUsernameEmpty
+	}
+
+	if role == "" {
+		role = db.RoleMember
+	}
+	if !db.ValidRole(role) {
+		return errInvalidRole
+	}
+
+	// Only hashes that can be verified at login are accepted
+	if _, err := svc.hasher.NeedsRehash(passwordHash); err != nil {
+		return crypto.ErrUnsupportedHash
+	}
+
+	if _, err := svc.db.ImportUser(username, passwordHash, role, svc.clock.Now()); err != nil {
+		if err == db.ErrUsernameTaken {
+			return err
+		}
+		svc.ErrorHandler(userRequests, err, zap.String("error", "failed to import user"), zap.String("username", username))
+		return errImportFailed
+	}
+
+	return nil
+}
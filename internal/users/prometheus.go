@@ -0,0 +1,13 @@
+package users
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	userRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "admin_user_requests_total",
+			Help: "Total number of admin user management requests.",
+		},
+		[]string{"status"},
+	)
+)
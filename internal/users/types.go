@@ -0,0 +1,48 @@
+package users
+
+import (
+	"github.com/VicSobDev/anniversaryAPI/internal/audit"
+	"github.com/VicSobDev/anniversaryAPI/internal/pictures"
+	"github.com/VicSobDev/anniversaryAPI/internal/revocation"
+	"github.com/VicSobDev/anniversaryAPI/pkg/clock"
+	"github.com/VicSobDev/anniversaryAPI/pkg/crypto"
+	"github.com/VicSobDev/anniversaryAPI/pkg/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type UsersService struct {
+	pictures    *pictures.PicturesService
+	logger      *zap.Logger
+	db          *db.SQLiteDB
+	revocations *revocation.Store
+	hasher      crypto.PasswordHasher
+	audit       *audit.Recorder
+	clock       clock.Clock
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// ImportRequest lists users exported from another system with their password hashes
+type ImportRequest struct {
+	Users []ImportedUser `json:"users"`
+}
+
+type ImportedUser struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+}
+
+// ImportFailure explains why a user was not imported
+type ImportFailure struct {
+	Username string `json:"username"`
+	Error    string `json:"error"`
+}
+
+func NewUsersService(picturesService *pictures.PicturesService, logger *zap.Logger, sqliteDB *db.SQLiteDB, revocations *revocation.Store, hasher crypto.PasswordHasher, recorder *audit.Recorder, clock clock.Clock) *UsersService {
+	prometheus.MustRegister(userRequests)
+	return &UsersService{pictures: picturesService, logger: logger, db: sqliteDB, revocations: revocations, hasher: hasher, audit: recorder, clock: clock}
+}
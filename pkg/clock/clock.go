@@ -0,0 +1,24 @@
+package clock
+
+import "time"
+
+// Clock provides the current time, allowing time-dependent logic to be tested deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is a Clock backed by time.Now.
+type System struct{}
+
+// Now returns the current system time.
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+// Fixed is a Clock that always returns the same instant.
+type Fixed time.Time
+
+// Now returns the fixed instant.
+func (c Fixed) Now() time.Time {
+	return time.Time(c)
+}
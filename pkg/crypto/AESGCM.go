@@ -0,0 +1,63 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// AESGCM encrypts small secrets at rest using AES-256-GCM
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM creates a new AESGCM instance; the key is derived from the specified passphrase with SHA-256
+func NewAESGCM(passphrase string) (*AESGCM, error) {
+	key := sha256.Sum256([]byte(passphrase))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return &AESGCM{aead: aead}, nil
+}
+
+// Encrypt encrypts the plaintext and returns the base64-encoded nonce and ciphertext
+func (a *AESGCM) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, a.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := a.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt
+func (a *AESGCM) Decrypt(encoded string) (string, error) {
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+
+	if len(sealed) < a.aead.NonceSize() {
+		return "", fmt.Errorf("invalid ciphertext")
+	}
+
+	nonce, ciphertext := sealed[:a.aead.NonceSize()], sealed[a.aead.NonceSize():]
+	plaintext, err := a.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return string(plaintext), nil
+}
@@ -2,9 +2,11 @@ package crypto
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/argon2"
 )
@@ -17,6 +19,49 @@ type Argon2Config struct {
 	KeyLen  uint32
 }
 
+// DefaultArgon2Config returns the parameters used when none are configured
+func DefaultArgon2Config() Argon2Config {
+	return Argon2Config{
+		Time:    1,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+	}
+}
+
+// Bounds on the parameters of stored hashes, so a malformed or hostile hash cannot make
+// verifying a login fail, exhaust memory or run for minutes
+const (
+	maxArgon2Time    = 32
+	maxArgon2Threads = 64
+	// maxArgon2Memory is 1 GiB, in KiB
+	maxArgon2Memory = 1 << 20
+	// minSaltLen is the shortest salt Argon2 allows, in bytes
+	minSaltLen = 8
+	// minKeyLen and maxKeyLen bound the length of stored keys, in bytes
+	minKeyLen = 16
+	maxKeyLen = 128
+)
+
+// Validate checks that hashes created with the configuration can be verified
+func (c Argon2Config) Validate() error {
+
+	if c.Time < 1 || c.Time > maxArgon2Time {
+		return fmt.Errorf("argon2 passes must be between 1 and %d", maxArgon2Time)
+	}
+	if c.Threads < 1 || c.Threads > maxArgon2Threads {
+		return fmt.Errorf("argon2 threads must be between 1 and %d", maxArgon2Threads)
+	}
+	// Argon2 needs at least 8 KiB of memory per thread
+	if c.Memory < 8*uint32(c.Threads) || c.Memory > maxArgon2Memory {
+		return fmt.Errorf("argon2 memory must be between 8 KiB per thread and %d KiB", maxArgon2Memory)
+	}
+	if c.KeyLen < minKeyLen || c.KeyLen > maxKeyLen {
+		return fmt.Errorf("argon2 key length must be between %d and %d bytes", minKeyLen, maxKeyLen)
+	}
+	return nil
+}
+
 // Argon2 is a wrapper around the argon2id algorithm
 type Argon2 struct {
 	config Argon2Config
@@ -48,35 +93,101 @@ func (a *Argon2) Hash(password string) (string, error) {
 // Verify compares the specified password with the specified hash
 func (a *Argon2) Verify(hash, password string) (bool, error) {
 
+	config, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	// Generate a new hash using the password and the decoded salt
+	newHash := argon2.IDKey([]byte(password), salt, config.Time, config.Memory, config.Threads, config.KeyLen)
+
+	// Compare the generated hash with the stored one in constant time
+	return subtle.ConstantTimeCompare(newHash, key) == 1, nil
+}
+
+// NeedsRehash reports whether the hash was created with weaker parameters than the
+// configured ones, so it should be replaced once the password is known. Hashes that
+// could not be verified are reported as errors.
+func (a *Argon2) NeedsRehash(hash string) (bool, error) {
+
+	config, _, _, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	return config.Time < a.config.Time || config.Memory < a.config.Memory, nil
+}
+
+// parseArgon2Hash decodes an encoded Argon2id hash into its parameters, salt and key,
+// rejecting parameters argon2 cannot use or that would make verifying it too costly.
+// The key length is whatever the stored hash was created with.
+func parseArgon2Hash(hash string) (Argon2Config, []byte, []byte, error) {
+
+	var config Argon2Config
 	parts := strings.Split(hash, "$")
-	if len(parts) != 6 {
-		return false, fmt.Errorf("invalid hash format")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return config, nil, nil, fmt.Errorf("invalid hash format")
 	}
 
+	// Only the current version is implemented; others would never verify
 	var version int
-	var config Argon2Config
-	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse version: %w", err)
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return config, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
 	}
 
-	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &config.Memory, &config.Time, &config.Threads)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse hash: %w", err)
+	var memory, passes, threads uint64
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &passes, &threads); err != nil {
+		return config, nil, nil, fmt.Errorf("failed to parse hash: %w", err)
+	}
+	if passes < 1 || passes > maxArgon2Time || threads < 1 || threads > maxArgon2Threads ||
+		memory < 8*threads || memory > maxArgon2Memory {
+		return config, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
 	}
+	config.Memory, config.Time, config.Threads = uint32(memory), uint32(passes), uint8(threads)
 
-	// Extract the salt part and decode it
 	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
-	if err != nil {
-		return false, fmt.Errorf("failed to decode salt: %w", err)
+	if err != nil || len(salt) < minSaltLen {
+		return config, nil, nil, fmt.Errorf("failed to decode salt")
 	}
 
-	// Determine the key length from the hash part of the string, assuming base64 encoding
-	config.KeyLen = uint32(len(parts[5]) * 3 / 4) // Approximation of base64 decoding size
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < minKeyLen || len(key) > maxKeyLen {
+		return config, nil, nil, fmt.Errorf("failed to decode hash")
+	}
+	config.KeyLen = uint32(len(key))
 
-	// Generate a new hash using the password and the decoded salt
-	newHash := argon2.IDKey([]byte(password), salt, config.Time, config.Memory, config.Threads, config.KeyLen)
+	return config, salt, key, nil
+}
+
+// maxRecommendedTime caps the number of passes RecommendArgon2 tries
+const maxRecommendedTime = 16
+
+// RecommendArgon2 benchmarks the host and returns parameters using the given memory and
+// threads whose hashing latency reaches target, along with the measured latency. The
+// number of passes is raised until the target is met; when a single pass is already
+// slower than the target, the memory is halved down to 8 MiB instead.
+func RecommendArgon2(target time.Duration, memory uint32, threads uint8) (Argon2Config, time.Duration) {
+
+	config := Argon2Config{Time: 1, Memory: memory, Threads: threads, KeyLen: 32}
+	password := []byte("benchmark")
+	salt := make([]byte, 16)
+
+	measure := func() time.Duration {
+		start := time.Now()
+		argon2.IDKey(password, salt, config.Time, config.Memory, config.Threads, config.KeyLen)
+		return time.Since(start)
+	}
+
+	elapsed := measure()
+	for elapsed > target && config.Memory/2 >= 8*1024 {
+		config.Memory /= 2
+		elapsed = measure()
+	}
+
+	for elapsed < target && config.Time < maxRecommendedTime {
+		config.Time++
+		elapsed = measure()
+	}
 
-	// Compare the generated hash with the hash part of the original string
-	return base64.RawStdEncoding.EncodeToString(newHash) == parts[5], nil
+	return config, elapsed
 }
@@ -0,0 +1,215 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Bounds on the cost of legacy hashes, so an imported hash cannot make a login exhaust
+// memory or run for minutes
+const (
+	maxBcryptCost = 16
+	// maxScryptMemory is 1 GiB; scrypt uses 128 * r * N bytes
+	maxScryptMemory = 1 << 30
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxPBKDF2Rounds = 5000000
+	bcryptHashLen   = 60
+	bcryptAlphabet  = "./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// parseBcrypt checks a $2a$, $2b$ or $2y$ bcrypt hash for its cost and encoding
+func parseBcrypt(hash string) error {
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+	if cost > maxBcryptCost {
+		return fmt.Errorf("bcrypt cost %d is above %d", cost, maxBcryptCost)
+	}
+
+	// bcrypt.Cost only reads the prefix; the salt and hash are decoded at comparison
+	if len(hash) != bcryptHashLen || strings.Trim(hash[7:], bcryptAlphabet) != "" {
+		return fmt.Errorf("invalid bcrypt hash")
+	}
+	return nil
+}
+
+// verifyBcrypt compares the password with a $2a$, $2b$ or $2y$ bcrypt hash
+func verifyBcrypt(hash, password string) (bool, error) {
+
+	if err := parseBcrypt(hash); err != nil {
+		return false, err
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+
+	return true, nil
+}
+
+// scryptHash is a decoded $scrypt$ln=15,r=8,p=1$salt$hash
+type scryptHash struct {
+	ln, r, p  int
+	salt, key []byte
+}
+
+// parseScrypt decodes a scrypt hash, rejecting parameters scrypt cannot use or that need
+// more memory than allowed
+func parseScrypt(hash string) (*scryptHash, error) {
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return nil, fmt.Errorf("invalid scrypt hash format")
+	}
+
+	params, err := parsePHCParams(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	ln, r, p := params["ln"], params["r"], params["p"]
+	if ln <= 0 || ln > 30 || r <= 0 || r > maxScryptR || p <= 0 || p > maxScryptP || 128*r<<ln > maxScryptMemory {
+		return nil, fmt.Errorf("invalid scrypt parameters")
+	}
+
+	salt, key, err := decodeSaltAndKey(parts[3], parts[4])
+	if err != nil {
+		return nil, err
+	}
+
+	return &scryptHash{ln: ln, r: r, p: p, salt: salt, key: key}, nil
+}
+
+// verifyScrypt compares the password with a hash in the form $scrypt$ln=15,r=8,p=1$salt$hash
+func verifyScrypt(hash, password string) (bool, error) {
+
+	parsed, err := parseScrypt(hash)
+	if err != nil {
+		return false, err
+	}
+
+	newKey, err := scrypt.Key([]byte(password), parsed.salt, 1<<parsed.ln, parsed.r, parsed.p, len(parsed.key))
+	if err != nil {
+		return false, fmt.Errorf("failed to compute scrypt hash: %w", err)
+	}
+
+	return subtle.ConstantTimeCompare(newKey, parsed.key) == 1, nil
+}
+
+// pbkdf2Hash is a decoded $pbkdf2-sha256$i=29000$salt$hash
+type pbkdf2Hash struct {
+	digest     func() hash.Hash
+	iterations int
+	salt, key  []byte
+}
+
+// parsePBKDF2 decodes a hash in the form $pbkdf2-sha256$i=29000$salt$hash. Passlib's form
+// with the bare iteration count, $pbkdf2-sha256$29000$salt$hash, is accepted too.
+func parsePBKDF2(encoded string) (*pbkdf2Hash, error) {
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid pbkdf2 hash format")
+	}
+
+	var digest func() hash.Hash
+	switch parts[1] {
+	case "pbkdf2-sha256":
+		digest = sha256.New
+	case "pbkdf2-sha512":
+		digest = sha512.New
+	default:
+		return nil, ErrUnsupportedHash
+	}
+
+	iterations, err := strconv.Atoi(parts[2])
+	if err != nil {
+		params, err := parsePHCParams(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		iterations = params["i"]
+	}
+	if iterations <= 0 || iterations > maxPBKDF2Rounds {
+		return nil, fmt.Errorf("invalid pbkdf2 iterations")
+	}
+
+	salt, key, err := decodeSaltAndKey(parts[3], parts[4])
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbkdf2Hash{digest: digest, iterations: iterations, salt: salt, key: key}, nil
+}
+
+// verifyPBKDF2 compares the password with a PBKDF2-SHA256 or PBKDF2-SHA512 hash
+func verifyPBKDF2(encoded, password string) (bool, error) {
+
+	parsed, err := parsePBKDF2(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	newKey := pbkdf2.Key([]byte(password), parsed.salt, parsed.iterations, len(parsed.key), parsed.digest)
+
+	return subtle.ConstantTimeCompare(newKey, parsed.key) == 1, nil
+}
+
+// parsePHCParams parses comma-separated name=value parameters with integer values
+func parsePHCParams(s string) (map[string]int, error) {
+
+	params := make(map[string]int)
+	for _, param := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid hash parameter %q", param)
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash parameter %q", param)
+		}
+		params[name] = n
+	}
+
+	return params, nil
+}
+
+// decodeSaltAndKey decodes the salt and hash of a PHC string. Padding and passlib's
+// adapted alphabet, which uses "." in place of "+", are accepted.
+func decodeSaltAndKey(encodedSalt, encodedKey string) ([]byte, []byte, error) {
+
+	decode := func(s string) ([]byte, error) {
+		s = strings.TrimRight(strings.ReplaceAll(s, ".", "+"), "=")
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+
+	salt, err := decode(encodedSalt)
+	if err != nil || len(salt) == 0 {
+		return nil, nil, fmt.Errorf("failed to decode salt")
+	}
+
+	key, err := decode(encodedKey)
+	if err != nil || len(key) < minKeyLen || len(key) > maxKeyLen {
+		return nil, nil, fmt.Errorf("failed to decode hash")
+	}
+
+	return salt, key, nil
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnsupportedHash is returned for password hashes in a format no hasher recognizes
+var ErrUnsupportedHash = errors.New("unsupported password hash format")
+
+// PasswordHasher hashes new passwords and verifies them against stored hashes
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+	// Verify reports whether password matches the encoded hash
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether hash should be replaced by a new Hash of the password
+	NeedsRehash(hash string) (bool, error)
+}
+
+// verifyFunc checks a password against a hash in a format that is only verified, never created
+type verifyFunc func(hash, password string) (bool, error)
+
+// legacyFormat parses and verifies hashes in a format that is only verified, never created.
+// parse runs the same checks as verify without computing the hash.
+type legacyFormat struct {
+	parse  func(hash string) error
+	verify verifyFunc
+}
+
+var (
+	bcryptFormat = legacyFormat{parse: parseBcrypt, verify: verifyBcrypt}
+	scryptFormat = legacyFormat{
+		parse:  func(hash string) error { _, err := parseScrypt(hash); return err },
+		verify: verifyScrypt,
+	}
+	pbkdf2Format = legacyFormat{
+		parse:  func(hash string) error { _, err := parsePBKDF2(hash); return err },
+		verify: verifyPBKDF2,
+	}
+)
+
+// legacyFormats maps hash prefixes of imported passwords to their formats
+var legacyFormats = map[string]legacyFormat{
+	"$2a$":            bcryptFormat,
+	"$2b$":            bcryptFormat,
+	"$2y$":            bcryptFormat,
+	"$scrypt$":        scryptFormat,
+	"$pbkdf2-sha256$": pbkdf2Format,
+	"$pbkdf2-sha512$": pbkdf2Format,
+}
+
+// legacyHasher hashes with Argon2id and also verifies bcrypt, scrypt and PBKDF2 hashes,
+// which always need a rehash
+type legacyHasher struct {
+	*Argon2
+}
+
+// NewPasswordHasher returns a PasswordHasher creating Argon2id hashes that also accepts
+// bcrypt, scrypt and PBKDF2 hashes imported from other systems
+func NewPasswordHasher(argon *Argon2) PasswordHasher {
+
+	return legacyHasher{Argon2: argon}
+}
+
+// Verify compares the password with an Argon2id or legacy hash
+func (h legacyHasher) Verify(hash, password string) (bool, error) {
+
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return h.Argon2.Verify(hash, password)
+	}
+
+	format, err := legacyFormatOf(hash)
+	if err != nil {
+		return false, err
+	}
+
+	return format.verify(hash, password)
+}
+
+// NeedsRehash reports true for every legacy hash and for Argon2id hashes with weaker
+// parameters. Hashes that could never verify are reported as ErrUnsupportedHash.
+func (h legacyHasher) NeedsRehash(hash string) (bool, error) {
+
+	if strings.HasPrefix(hash, "$argon2id$") {
+		rehash, err := h.Argon2.NeedsRehash(hash)
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrUnsupportedHash, err)
+		}
+		return rehash, nil
+	}
+
+	format, err := legacyFormatOf(hash)
+	if err != nil {
+		return false, err
+	}
+	if err := format.parse(hash); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrUnsupportedHash, err)
+	}
+
+	return true, nil
+}
+
+// legacyFormatOf returns the legacy format of hash
+func legacyFormatOf(hash string) (legacyFormat, error) {
+
+	for prefix, format := range legacyFormats {
+		if strings.HasPrefix(hash, prefix) {
+			return format, nil
+		}
+	}
+
+	return legacyFormat{}, ErrUnsupportedHash
+}
@@ -0,0 +1,148 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+)
+
+const testPassword = "correct horse battery"
+
+// testArgon2Config is cheap enough for tests and passes Validate
+var testArgon2Config = Argon2Config{Time: 1, Memory: 64, Threads: 1, KeyLen: 32}
+
+// Vectors in passlib's format, computed with Python's hashlib. The salt decodes to bytes
+// that encode with passlib's "." in place of "+".
+const (
+	pbkdf2SHA256Vector = "$pbkdf2-sha256$29000$....IHNhbHRzYWx0oLE$Pc4cllpd2YrAVNHcC//Mmssla5v6U/wxcxlzGAdlhOo"
+	pbkdf2SHA512Vector = "$pbkdf2-sha512$i=25000$....IHNhbHRzYWx0oLE$6sPp/nIMHYjf9dGQd9qpcZloMkzU7Iowl1WhmcqwF/MWKPRlvXhMx24jDFS7RrpKtGJSTCvui0dmlTqd3raxnw"
+	scryptVector       = "$scrypt$ln=10,r=8,p=1$....IHNhbHRzYWx0oLE$7eW8Or84OFB20VvNvAhEZUjRHDn0ECg9MAzLzD75pZ8"
+	// bcryptVector is the OpenBSD test vector for the password "U*U"
+	bcryptVector = "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"
+)
+
+func TestArgon2RoundTrip(t *testing.T) {
+	hasher := NewPasswordHasher(NewArgon2(testArgon2Config))
+
+	hash, err := hasher.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hasher.Verify(hash, testPassword); err != nil || !ok {
+		t.Fatalf("Verify = %v, %v, want true", ok, err)
+	}
+	if ok, err := hasher.Verify(hash, "wrong"); err != nil || ok {
+		t.Fatalf("Verify with the wrong password = %v, %v, want false", ok, err)
+	}
+	if rehash, err := hasher.NeedsRehash(hash); err != nil || rehash {
+		t.Fatalf("NeedsRehash = %v, %v, want false", rehash, err)
+	}
+
+	// Stronger configured parameters ask for a rehash
+	stronger := testArgon2Config
+	stronger.Time = 2
+	if rehash, err := NewPasswordHasher(NewArgon2(stronger)).NeedsRehash(hash); err != nil || !rehash {
+		t.Fatalf("NeedsRehash with more passes = %v, %v, want true", rehash, err)
+	}
+}
+
+func TestLegacyVectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+	}{
+		{"bcrypt", bcryptVector, "U*U"},
+		{"bcrypt 2b", "$2b$" + bcryptVector[4:], "U*U"},
+		{"scrypt", scryptVector, testPassword},
+		{"pbkdf2 sha256 with bare iterations", pbkdf2SHA256Vector, testPassword},
+		{"pbkdf2 sha512", pbkdf2SHA512Vector, testPassword},
+	}
+
+	hasher := NewPasswordHasher(NewArgon2(testArgon2Config))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok, err := hasher.Verify(tt.hash, tt.password); err != nil || !ok {
+				t.Fatalf("Verify = %v, %v, want true", ok, err)
+			}
+			if ok, err := hasher.Verify(tt.hash, "wrong"); err != nil || ok {
+				t.Fatalf("Verify with the wrong password = %v, %v, want false", ok, err)
+			}
+			if rehash, err := hasher.NeedsRehash(tt.hash); err != nil || !rehash {
+				t.Fatalf("NeedsRehash = %v, %v, want true", rehash, err)
+			}
+		})
+	}
+}
+
+func TestUnverifiableHashesRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"argon2 without key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$"},
+		{"argon2 without passes", "$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"argon2 without threads", "$argon2id$v=19$m=1024,t=1,p=0$c2FsdHNhbHQ$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"argon2 memory below threads", "$argon2id$v=19$m=8,t=1,p=4$c2FsdHNhbHQ$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"argon2 memory above bound", "$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdHNhbHQ$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"argon2 passes above bound", "$argon2id$v=19$m=1024,t=4294967295,p=1$c2FsdHNhbHQ$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"argon2 short key", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$c2FsdA"},
+		{"argon2 old version", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"argon2 missing field", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ"},
+		{"pbkdf2 without key", "$pbkdf2-sha256$i=1$c2FsdA$"},
+		{"pbkdf2 without iterations", "$pbkdf2-sha256$i=0$c2FsdA$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"pbkdf2 iterations above bound", "$pbkdf2-sha256$i=2000000000$c2FsdA$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"pbkdf2 without salt", "$pbkdf2-sha256$i=1000$$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"pbkdf2 unknown digest", "$pbkdf2-sha256x$i=1000$c2FsdA$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"scrypt without key", "$scrypt$ln=10,r=8,p=1$c2FsdA$"},
+		{"scrypt without parameters", "$scrypt$$c2FsdA$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"scrypt r above bound", "$scrypt$ln=10,r=100000,p=1$c2FsdA$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"scrypt p above bound", "$scrypt$ln=10,r=8,p=100000$c2FsdA$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"scrypt memory above bound", "$scrypt$ln=21,r=8,p=1$c2FsdA$c2FsdHNhbHRzYWx0c2FsdA"},
+		{"bcrypt truncated", bcryptVector[:40]},
+		{"bcrypt cost above bound", "$2a$31$" + bcryptVector[7:]},
+		{"bcrypt invalid characters", bcryptVector[:59] + "!"},
+		{"unknown format", "$1$saltsalt$hash"},
+		{"plain text", "hunter2"},
+	}
+
+	hasher := NewPasswordHasher(NewArgon2(testArgon2Config))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := hasher.NeedsRehash(tt.hash); !errors.Is(err, ErrUnsupportedHash) {
+				t.Fatalf("NeedsRehash error = %v, want ErrUnsupportedHash", err)
+			}
+			if ok, err := hasher.Verify(tt.hash, testPassword); err == nil || ok {
+				t.Fatalf("Verify = %v, %v, want an error", ok, err)
+			}
+		})
+	}
+}
+
+func TestArgon2ConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Argon2Config)
+		valid  bool
+	}{
+		{"default", func(c *Argon2Config) { *c = DefaultArgon2Config() }, true},
+		{"test config", func(c *Argon2Config) {}, true},
+		{"no passes", func(c *Argon2Config) { c.Time = 0 }, false},
+		{"too many passes", func(c *Argon2Config) { c.Time = maxArgon2Time + 1 }, false},
+		{"no threads", func(c *Argon2Config) { c.Threads = 0 }, false},
+		{"too many threads", func(c *Argon2Config) { c.Threads = maxArgon2Threads + 1 }, false},
+		{"memory below 8 KiB per thread", func(c *Argon2Config) { c.Threads = 9 }, false},
+		{"too much memory", func(c *Argon2Config) { c.Memory = maxArgon2Memory + 1 }, false},
+		{"short key", func(c *Argon2Config) { c.KeyLen = minKeyLen - 1 }, false},
+		{"long key", func(c *Argon2Config) { c.KeyLen = maxKeyLen + 1 }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testArgon2Config
+			tt.modify(&config)
+			if err := config.Validate(); (err == nil) != tt.valid {
+				t.Fatalf("Validate = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// AccessToken is a personal access token. Only the hash of the token is stored.
+type AccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+const accessTokenColumns = "id, user_id, name, token_hash, scopes, created_at, expires_at, last_used_at"
+
+func scanAccessToken(row rowScanner) (AccessToken, error) {
+	var token AccessToken
+	var scopes string
+	var createdAt, expiresAt int64
+	var lastUsedAt sql.NullInt64
+
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.TokenHash, &scopes, &createdAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return token, err
+	}
+
+	token.Scopes = []string{}
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	token.CreatedAt = time.Unix(createdAt, 0)
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	token.LastUsedAt = nullUnix(lastUsedAt)
+	return token, nil
+}
+
+// CreateAccessToken stores a personal access token and returns it with its ID.
+func (s *SQLiteDB) CreateAccessToken(token AccessToken) (AccessToken, error) {
+	err := s.db.QueryRow("INSERT INTO access_tokens (user_id, name, token_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id",
+		token.UserID, token.Name, token.TokenHash, strings.Join(token.Scopes, ","), token.CreatedAt.Unix(), token.ExpiresAt.Unix()).Scan(&token.ID)
+	return token, err
+}
+
+func (s *SQLiteDB) GetAccessToken(tokenHash string) (AccessToken, error) {
+	return scanAccessToken(s.db.QueryRow("SELECT "+accessTokenColumns+" FROM access_tokens WHERE token_hash = ?", tokenHash))
+}
+
+func (s *SQLiteDB) GetAccessTokensByUser(userID int) ([]AccessToken, error) {
+	rows, err := s.db.Query("SELECT "+accessTokenColumns+" FROM access_tokens WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []AccessToken
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *SQLiteDB) UpdateAccessTokenUsage(id int, usedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE access_tokens SET last_used_at = ? WHERE id = ?", usedAt.Unix(), id)
+	return err
+}
+
+// DeleteUserAccessTokens revokes all of the user's access tokens.
+func (s *SQLiteDB) DeleteUserAccessTokens(userID int) error {
+	_, err := s.db.Exec("DELETE FROM access_tokens WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteAccessToken revokes one of the user's access tokens and reports whether it existed.
+func (s *SQLiteDB) DeleteAccessToken(userID, id int) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM access_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// AuditEvent is a recorded authentication or admin event.
+type AuditEvent struct {
+	ID        int             `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Action    string          `json:"action"`
+	Outcome   string          `json:"outcome"`
+	ActorID   *int            `json:"actor_id,omitempty"`
+	Actor     string          `json:"actor,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Target    string          `json:"target,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
+
+// AuditFilter selects audit events; zero fields match everything.
+type AuditFilter struct {
+	Action    string
+	Outcome   string
+	ActorID   int
+	Actor     string
+	IP        string
+	RequestID string
+	Since     *time.Time
+	Until     *time.Time
+}
+
+const auditEventColumns = "id, created_at, action, outcome, actor_id, actor, ip, user_agent, request_id, target, details"
+
+func scanAuditEvent(row rowScanner) (AuditEvent, error) {
+	var event AuditEvent
+	var createdAt int64
+	var actorID sql.NullInt64
+	var details string
+
+	err := row.Scan(&event.ID, &createdAt, &event.Action, &event.Outcome, &actorID, &event.Actor, &event.IP,
+		&event.UserAgent, &event.RequestID, &event.Target, &details)
+	if err != nil {
+		return event, err
+	}
+
+	event.CreatedAt = time.Unix(createdAt, 0)
+	if actorID.Valid {
+		id := int(actorID.Int64)
+		event.ActorID = &id
+	}
+	if details != "" {
+		event.Details = json.RawMessage(details)
+	}
+	return event, nil
+}
+
+// where builds the WHERE clause and arguments matching the filter.
+func (f AuditFilter) where() (string, []any) {
+	var conditions []string
+	var args []any
+
+	add := func(condition string, arg any) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.Outcome != "" {
+		add("outcome = ?", f.Outcome)
+	}
+	if f.ActorID != 0 {
+		add("actor_id = ?", f.ActorID)
+	}
+	if f.Actor != "" {
+		add("actor = ?", f.Actor)
+	}
+	if f.IP != "" {
+		add("ip = ?", f.IP)
+	}
+	if f.RequestID != "" {
+		add("request_id = ?", f.RequestID)
+	}
+	if f.Since != nil {
+		add("created_at >= ?", f.Since.Unix())
+	}
+	if f.Until != nil {
+		add("created_at < ?", f.Until.Unix())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (s *SQLiteDB) CreateAuditEvent(event AuditEvent) error {
+	var actorID sql.NullInt64
+	if event.ActorID != nil {
+		actorID = sql.NullInt64{Int64: int64(*event.ActorID), Valid: true}
+	}
+
+	_, err := s.db.Exec(`INSERT INTO audit_events (created_at, action, outcome, actor_id, actor, ip, user_agent, request_id, target, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.CreatedAt.Unix(), event.Action, event.Outcome, actorID, event.Actor, event.IP, event.UserAgent, event.RequestID,
+		event.Target, string(event.Details))
+	return err
+}
+
+// GetAuditEvents returns a page of events matching the filter, newest first, with the total number of matches.
+func (s *SQLiteDB) GetAuditEvents(filter AuditFilter, limit, offset int) ([]AuditEvent, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM audit_events"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query("SELECT "+auditEventColumns+" FROM audit_events"+where+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, event)
+	}
+	return events, total, rows.Err()
+}
+
+// EachAuditEvent calls fn for every event matching the filter, oldest first, stopping at the first error.
+func (s *SQLiteDB) EachAuditEvent(filter AuditFilter, fn func(AuditEvent) error) error {
+	where, args := filter.where()
+
+	rows, err := s.db.Query("SELECT "+auditEventColumns+" FROM audit_events"+where+" ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteAuditEventsBefore removes events older than cutoff.
+func (s *SQLiteDB) DeleteAuditEventsBefore(cutoff time.Time) error {
+	_, err := s.db.Exec("DELETE FROM audit_events WHERE created_at < ?", cutoff.Unix())
+	return err
+}
@@ -0,0 +1,39 @@
+package db
+
+import "database/sql"
+
+// Stored picture files are named after the SHA-256 of their content, so several image records
+// can share one file. The blobs table counts the records using each file, so a file is only
+// removed once the last of them is deleted.
+
+// retainBlob records one more image using the file with the given content hash.
+func retainBlob(tx *sql.Tx, hash, name string, createdAt int64) error {
+	if hash == "" {
+		return nil
+	}
+
+	_, err := tx.Exec(`INSERT INTO blobs (hash, name, ref_count, created_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT(hash) DO UPDATE SET ref_count = ref_count + 1`, hash, name, createdAt)
+	return err
+}
+
+// releaseBlob records that an image no longer uses the file with the given content hash and
+// reports whether the file is now unused. Images stored before files were named after their
+// content have no hash and always own their file.
+func releaseBlob(tx *sql.Tx, hash string) (bool, error) {
+	if hash == "" {
+		return true, nil
+	}
+
+	var refs int
+	err := tx.QueryRow("UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = ? RETURNING ref_count", hash).Scan(&refs)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil || refs > 0 {
+		return false, err
+	}
+
+	_, err = tx.Exec("DELETE FROM blobs WHERE hash = ?", hash)
+	return err == nil, err
+}
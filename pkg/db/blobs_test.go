@@ -0,0 +1,156 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDB returns a migrated database in a temporary directory.
+func newTestDB(t *testing.T) *SQLiteDB {
+	t.Helper()
+
+	sqliteDB, err := NewSQLiteDB(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqliteDB.Close() })
+	if err := sqliteDB.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return sqliteDB
+}
+
+// createTestImage stores an image record using the file with the given content hash.
+func createTestImage(t *testing.T, s *SQLiteDB, userID int, name, hash string) int {
+	t.Helper()
+
+	id, err := s.CreateImage(userID, name, hash, time.Now().Unix(), ImageMetadata{Width: 1, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// blobRefs returns the reference count stored for the hash, or 0 when it has no row.
+func blobRefs(t *testing.T, s *SQLiteDB, hash string) int {
+	t.Helper()
+
+	var refs int
+	if err := s.db.QueryRow("SELECT COALESCE(SUM(ref_count), 0) FROM blobs WHERE hash = ?", hash).Scan(&refs); err != nil {
+		t.Fatal(err)
+	}
+	return refs
+}
+
+func TestSharedBlobSurvivesUntilLastDelete(t *testing.T) {
+	s := newTestDB(t)
+	victor, err := s.CreateUser("victor", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ana, err := s.CreateUser("ana", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := createTestImage(t, s, victor.ID, "shared.jpg", "shared")
+	second := createTestImage(t, s, ana.ID, "shared.jpg", "shared")
+	other := createTestImage(t, s, victor.ID, "other.jpg", "other")
+	if refs := blobRefs(t, s, "shared"); refs != 2 {
+		t.Fatalf("ref_count = %d, want 2", refs)
+	}
+
+	unused, err := s.DeleteImage(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unused {
+		t.Fatal("file reported unused while another image uses it")
+	}
+	if refs := blobRefs(t, s, "shared"); refs != 1 {
+		t.Fatalf("ref_count = %d, want 1", refs)
+	}
+
+	unused, err = s.DeleteImage(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unused {
+		t.Fatal("file still reported used after its last image was deleted")
+	}
+	if refs := blobRefs(t, s, "shared"); refs != 0 {
+		t.Fatalf("ref_count = %d, want the row removed", refs)
+	}
+
+	// Other files are untouched
+	if refs := blobRefs(t, s, "other"); refs != 1 {
+		t.Fatalf("ref_count of another file = %d, want 1", refs)
+	}
+	if _, err := s.GetImage(other); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImagesWithoutBlobOwnTheirFile(t *testing.T) {
+	s := newTestDB(t)
+	user, err := s.CreateUser("victor", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Images stored before files were named after their content have no hash
+	id := createTestImage(t, s, user.ID, "legacy.jpg", "")
+	unused, err := s.DeleteImage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unused {
+		t.Fatal("legacy image file reported used")
+	}
+
+	// A hash without a blob row, e.g. a record written before the table existed, is unused too
+	tx, err := s.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if unused, err := releaseBlob(tx, "missing"); err != nil || !unused {
+		t.Fatalf("releaseBlob = %v, %v, want true", unused, err)
+	}
+}
+
+func TestDeleteUserReleasesSharedBlobs(t *testing.T) {
+	s := newTestDB(t)
+	victor, err := s.CreateUser("victor", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ana, err := s.CreateUser("ana", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createTestImage(t, s, victor.ID, "shared.jpg", "shared")
+	createTestImage(t, s, victor.ID, "own.jpg", "own")
+	createTestImage(t, s, ana.ID, "shared.jpg", "shared")
+
+	deleted, unused, err := s.DeleteUser(victor.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("deleted %d images, want 2", deleted)
+	}
+	if len(unused) != 1 || unused[0].Name != "own.jpg" {
+		t.Fatalf("unused files = %+v, want only own.jpg", unused)
+	}
+
+	_, unused, err = s.DeleteUser(ana.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unused) != 1 || unused[0].Name != "shared.jpg" {
+		t.Fatalf("unused files = %+v, want shared.jpg", unused)
+	}
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ImageVariant is a resized copy of an image, stored next to the original
+type ImageVariant struct {
+	ImageID int    `json:"-"`
+	Size    string `json:"size"`
+	Name    string `json:"name"`
+	Format  string `json:"format"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+const imageVariantColumns = "image_id, size, name, format, width, height"
+
+func scanImageVariant(row rowScanner) (ImageVariant, error) {
+	var v ImageVariant
+	err := row.Scan(&v.ImageID, &v.Size, &v.Name, &v.Format, &v.Width, &v.Height)
+	return v, err
+}
+
+// SaveImageVariant records a variant, replacing an earlier one of the same size.
+func (s *SQLiteDB) SaveImageVariant(v ImageVariant, createdAt time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO image_variants (image_id, size, name, format, width, height, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(image_id, size) DO UPDATE SET name = excluded.name, format = excluded.format,
+			width = excluded.width, height = excluded.height, created_at = excluded.created_at`,
+		v.ImageID, v.Size, v.Name, v.Format, v.Width, v.Height, createdAt.Unix())
+	return err
+}
+
+// GetImageVariant returns the variant of the given size of an image.
+func (s *SQLiteDB) GetImageVariant(imageID int, size string) (ImageVariant, error) {
+	return scanImageVariant(s.db.QueryRow("SELECT "+imageVariantColumns+" FROM image_variants WHERE image_id = ? AND size = ?", imageID, size))
+}
+
+// AttachImageVariants fills in the variants of each image.
+func (s *SQLiteDB) AttachImageVariants(images []Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(images))
+	index := make(map[int]int, len(images))
+	for i, image := range images {
+		args[i] = image.ID
+		index[image.ID] = i
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(images)), ", ")
+	rows, err := s.db.Query("SELECT "+imageVariantColumns+" FROM image_variants WHERE image_id IN ("+placeholders+") ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+
+	return collectImageVariants(rows, images, index)
+}
+
+// collectImageVariants appends the variants read from rows to the image they belong to.
+func collectImageVariants(rows *sql.Rows, images []Image, index map[int]int) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		v, err := scanImageVariant(rows)
+		if err != nil {
+			return err
+		}
+		if i, ok := index[v.ImageID]; ok {
+			images[i].Variants = append(images[i].Variants, v)
+		}
+	}
+	return rows.Err()
+}
+
+// CopyImageVariants records the variants of one image for another stored in the same file.
+func (s *SQLiteDB) CopyImageVariants(fromID, toID int) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO image_variants (image_id, size, name, format, width, height, created_at)
+		SELECT ?, size, name, format, width, height, created_at FROM image_variants WHERE image_id = ? ORDER BY id`, toID, fromID)
+	return err
+}
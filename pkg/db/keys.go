@@ -0,0 +1,149 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidKey    = errors.New("invalid registration key")
+	ErrUsernameTaken = errors.New("username already exists")
+)
+
+// RegistrationKey is a key that allows creating accounts with a given role.
+type RegistrationKey struct {
+	ID            int        `json:"id"`
+	Key           string     `json:"key"`
+	Label         string     `json:"label"`
+	CreatedBy     string     `json:"created_by"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxUses       int        `json:"max_uses"`
+	RemainingUses int        `json:"remaining_uses"`
+	Role          string     `json:"role"`
+}
+
+const keyColumns = "id, key, label, created_by, created_at, expires_at, max_uses, remaining_uses, role"
+
+func scanKey(row rowScanner) (*RegistrationKey, error) {
+	var key RegistrationKey
+	var createdAt, expiresAt sql.NullInt64
+
+	err := row.Scan(&key.ID, &key.Key, &key.Label, &key.CreatedBy, &createdAt, &expiresAt, &key.MaxUses, &key.RemainingUses, &key.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	key.CreatedAt = nullUnix(createdAt)
+	key.ExpiresAt = nullUnix(expiresAt)
+	return &key, nil
+}
+
+func (s *SQLiteDB) CreateKey(key RegistrationKey, createdAt time.Time) (*RegistrationKey, error) {
+	var expiresAt sql.NullInt64
+	if key.ExpiresAt != nil {
+		expiresAt = sql.NullInt64{Int64: key.ExpiresAt.Unix(), Valid: true}
+	}
+
+	res, err := s.db.Exec("INSERT INTO keys (key, label, created_by, created_at, expires_at, max_uses, remaining_uses, role) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		key.Key, key.Label, key.CreatedBy, createdAt.Unix(), expiresAt, key.MaxUses, key.MaxUses, key.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return scanKey(s.db.QueryRow("SELECT "+keyColumns+" FROM keys WHERE id = ?", id))
+}
+
+func (s *SQLiteDB) GetKeys() ([]RegistrationKey, error) {
+	rows, err := s.db.Query("SELECT " + keyColumns + " FROM keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []RegistrationKey
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteKey revokes a registration key and reports whether it existed.
+func (s *SQLiteDB) DeleteKey(id int) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM keys WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// HasRedeemableKey reports whether a key with the given label can still be redeemed at now.
+func (s *SQLiteDB) HasRedeemableKey(label string, now time.Time) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM keys WHERE label = ? AND remaining_uses > 0 AND (expires_at IS NULL OR expires_at > ?)",
+		label, now.Unix()).Scan(&count)
+	return count > 0, err
+}
+
+func (s *SQLiteDB) DeleteKeysByLabel(label string) error {
+	_, err := s.db.Exec("DELETE FROM keys WHERE label = ?", label)
+	return err
+}
+
+// RegisterUser redeems one use of a registration key and creates the user with the key's role
+// in a single transaction. It returns ErrInvalidKey when the key is unknown, expired or used up,
+// and ErrUsernameTaken without consuming the key when the username exists.
+func (s *SQLiteDB) RegisterUser(key, username, passwordHash string, now time.Time) (*User, *RegistrationKey, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	redeemed, err := scanKey(tx.QueryRow(`UPDATE keys SET remaining_uses = remaining_uses - 1
+		WHERE key = ? AND remaining_uses > 0 AND (expires_at IS NULL OR expires_at > ?)
+		RETURNING `+keyColumns, key, now.Unix()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrInvalidKey
+		}
+		return nil, nil, err
+	}
+
+	var taken int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&taken); err != nil {
+		return nil, nil, err
+	}
+	if taken > 0 {
+		return nil, nil, ErrUsernameTaken
+	}
+
+	// Generations start at the creation time so tokens of a deleted user whose ID is reused stay invalid
+	res, err := tx.Exec("INSERT INTO users (username, password, role, created_at, token_generation) VALUES (?, ?, ?, ?, ?)", username, passwordHash, redeemed.Role, now.Unix(), now.Unix())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, redeemed, tx.Commit()
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginFailure tracks consecutive failed logins for a username or client IP.
+type LoginFailure struct {
+	Scope         string     `json:"scope"`
+	Identifier    string     `json:"identifier"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`
+}
+
+const loginFailureColumns = "scope, identifier, failures, last_failure_at, blocked_until"
+
+func scanLoginFailure(row rowScanner) (*LoginFailure, error) {
+	var failure LoginFailure
+	var lastFailureAt int64
+	var blockedUntil sql.NullInt64
+
+	if err := row.Scan(&failure.Scope, &failure.Identifier, &failure.Failures, &lastFailureAt, &blockedUntil); err != nil {
+		return nil, err
+	}
+
+	failure.LastFailureAt = time.Unix(lastFailureAt, 0)
+	failure.BlockedUntil = nullUnix(blockedUntil)
+	return &failure, nil
+}
+
+func (s *SQLiteDB) GetLoginFailure(scope, identifier string) (*LoginFailure, error) {
+	return scanLoginFailure(s.db.QueryRow("SELECT "+loginFailureColumns+" FROM login_failures WHERE scope = ? AND identifier = ?", scope, identifier))
+}
+
+// RecordLoginFailure counts a failed login and returns the number of consecutive failures.
+// The count starts over when the previous failure happened before windowStart.
+func (s *SQLiteDB) RecordLoginFailure(scope, identifier string, now, windowStart time.Time) (int, error) {
+	var failures int
+	err := s.db.QueryRow(`INSERT INTO login_failures (scope, identifier, failures, last_failure_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT(scope, identifier) DO UPDATE SET
+			failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END,
+			last_failure_at = excluded.last_failure_at
+		RETURNING failures`,
+		scope, identifier, now.Unix(), windowStart.Unix()).Scan(&failures)
+	return failures, err
+}
+
+func (s *SQLiteDB) SetLoginBlockedUntil(scope, identifier string, blockedUntil time.Time) error {
+	_, err := s.db.Exec("UPDATE login_failures SET blocked_until = ? WHERE scope = ? AND identifier = ?", blockedUntil.Unix(), scope, identifier)
+	return err
+}
+
+// DeleteLoginFailure forgets the failures of an identifier and reports whether any were recorded.
+func (s *SQLiteDB) DeleteLoginFailure(scope, identifier string) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM login_failures WHERE scope = ? AND identifier = ?", scope, identifier)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// GetBlockedLogins returns the identifiers that are blocked at now.
+func (s *SQLiteDB) GetBlockedLogins(now time.Time) ([]LoginFailure, error) {
+	rows, err := s.db.Query("SELECT "+loginFailureColumns+" FROM login_failures WHERE blocked_until > ? ORDER BY blocked_until DESC", now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []LoginFailure
+	for rows.Next() {
+		failure, err := scanLoginFailure(rows)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, *failure)
+	}
+	return failures, rows.Err()
+}
+
+// DeleteStaleLoginFailures removes unblocked entries whose last failure happened before windowStart.
+func (s *SQLiteDB) DeleteStaleLoginFailures(windowStart, now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM login_failures WHERE last_failure_at < ? AND (blocked_until IS NULL OR blocked_until <= ?)", windowStart.Unix(), now.Unix())
+	return err
+}
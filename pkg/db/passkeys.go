@@ -0,0 +1,122 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// PasskeyCredential is a WebAuthn credential registered by a user.
+type PasskeyCredential struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"-"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"-"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	Transports      []string   `json:"transports"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	Name            string     `json:"name"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+}
+
+const passkeyColumns = "id, user_id, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, name, created_at, last_used_at"
+
+func scanPasskey(row rowScanner) (PasskeyCredential, error) {
+	var credential PasskeyCredential
+	var transports string
+	var createdAt int64
+	var lastUsedAt sql.NullInt64
+
+	err := row.Scan(&credential.ID, &credential.UserID, &credential.CredentialID, &credential.PublicKey, &credential.AttestationType,
+		&credential.AAGUID, &credential.SignCount, &transports, &credential.BackupEligible, &credential.BackupState, &credential.Name,
+		&createdAt, &lastUsedAt)
+	if err != nil {
+		return credential, err
+	}
+
+	credential.Transports = []string{}
+	if transports != "" {
+		credential.Transports = strings.Split(transports, ",")
+	}
+	credential.CreatedAt = time.Unix(createdAt, 0)
+	credential.LastUsedAt = nullUnix(lastUsedAt)
+	return credential, nil
+}
+
+func (s *SQLiteDB) CreatePasskey(credential PasskeyCredential) error {
+	_, err := s.db.Exec(`INSERT INTO passkeys (user_id, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, name, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		credential.UserID, credential.CredentialID, credential.PublicKey, credential.AttestationType, credential.AAGUID, credential.SignCount,
+		strings.Join(credential.Transports, ","), credential.BackupEligible, credential.BackupState, credential.Name, credential.CreatedAt.Unix())
+	return err
+}
+
+func (s *SQLiteDB) GetPasskeysByUser(userID int) ([]PasskeyCredential, error) {
+	rows, err := s.db.Query("SELECT "+passkeyColumns+" FROM passkeys WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []PasskeyCredential
+	for rows.Next() {
+		credential, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+// UpdatePasskeyUsage records a successful assertion and the authenticator's new signature counter.
+func (s *SQLiteDB) UpdatePasskeyUsage(credentialID []byte, signCount uint32, backupState bool, usedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE passkeys SET sign_count = ?, backup_state = ?, last_used_at = ? WHERE credential_id = ?",
+		signCount, backupState, usedAt.Unix(), credentialID)
+	return err
+}
+
+// DeletePasskey removes a credential owned by userID and reports whether one was deleted.
+func (s *SQLiteDB) DeletePasskey(userID, id int) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM passkeys WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// CreateWebAuthnSession stores the state of a pending WebAuthn ceremony.
+func (s *SQLiteDB) CreateWebAuthnSession(id, purpose string, userID int, data []byte, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO webauthn_sessions (id, purpose, user_id, data, expires_at) VALUES (?, ?, ?, ?, ?)",
+		id, purpose, userID, data, expiresAt.Unix())
+	return err
+}
+
+// TakeWebAuthnSession returns and deletes an unexpired ceremony session, so each session is used once.
+func (s *SQLiteDB) TakeWebAuthnSession(id, purpose string, now time.Time) (int, []byte, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var data []byte
+	err = tx.QueryRow("SELECT user_id, data FROM webauthn_sessions WHERE id = ? AND purpose = ? AND expires_at >= ?", id, purpose, now.Unix()).
+		Scan(&userID, &data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM webauthn_sessions WHERE id = ? OR expires_at < ?", id, now.Unix()); err != nil {
+		return 0, nil, err
+	}
+
+	return userID, data, tx.Commit()
+}
@@ -0,0 +1,51 @@
+package db
+
+import "time"
+
+func (s *SQLiteDB) UpdatePassword(userID int, passwordHash string) error {
+	_, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, userID)
+	return err
+}
+
+// CreatePasswordResetToken stores a hashed reset token, replacing any unused tokens of the user.
+func (s *SQLiteDB) CreatePasswordResetToken(userID int, tokenHash string, createdAt, expiresAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM password_reset_tokens WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT INTO password_reset_tokens (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		userID, tokenHash, createdAt.Unix(), expiresAt.Unix()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResetPassword redeems an unused, unexpired reset token and sets the user's new password hash
+// in one transaction, returning the user ID. It returns sql.ErrNoRows when the token is not valid.
+func (s *SQLiteDB) ResetPassword(tokenHash, passwordHash string, now time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow("UPDATE password_reset_tokens SET used_at = ? WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? RETURNING user_id",
+		now.Unix(), tokenHash, now.Unix()).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("UPDATE users SET password = ?, password_reset_required = 0 WHERE id = ?", passwordHash, userID); err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -1,16 +1,143 @@
 package db
 
-import "time"
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Image sort orders
+const (
+	SortUploaded = "uploaded"
+	SortTaken    = "taken"
+)
 
 type Image struct {
 	ID         int       `json:"id,omitempty"`
 	UploadedBy int       `json:"uploaded_by,omitempty"`
 	Name       string    `json:"name,omitempty"`
 	CreatedAt  time.Time `json:"created_at,omitempty"`
+	// ContentHash is the SHA-256 of the stored file, empty for images stored before it was recorded
+	ContentHash string `json:"-"`
+	ImageMetadata
+	// Variants are only filled in by AttachImageVariants and DeleteUser
+	Variants []ImageVariant `json:"variants,omitempty"`
+}
+
+// ImageMetadata is read from the picture on upload. Images uploaded before it was recorded
+// have none, and the EXIF fields are empty for pictures without EXIF data.
+type ImageMetadata struct {
+	Width       int        `json:"width,omitempty"`
+	Height      int        `json:"height,omitempty"`
+	TakenAt     *time.Time `json:"taken_at,omitempty"`
+	CameraMake  string     `json:"camera_make,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty"`
+	Orientation int        `json:"orientation,omitempty"`
+	Latitude    *float64   `json:"latitude,omitempty"`
+	Longitude   *float64   `json:"longitude,omitempty"`
+}
+
+// ImageQuery selects and orders the images of a page
+type ImageQuery struct {
+	// Sort is SortUploaded (default) or SortTaken. Images without a capture time are
+	// sorted by their upload time.
+	Sort        string
+	Descending  bool
+	TakenAfter  *time.Time
+	TakenBefore *time.Time
+	// Camera only matches the images uploaded by Viewer, as the camera is private to the uploader
+	Camera string
+	Viewer int
+}
+
+const imageColumns = "id, uploaded_by, name, created_at, content_hash, width, height, taken_at, camera_make, camera_model, orientation, latitude, longitude"
+
+// scanImage reads an image row. created_at is declared TEXT but holds unix seconds,
+// so it is parsed here rather than scanned into a time.Time.
+func scanImage(row rowScanner) (Image, error) {
+	var image Image
+	var createdAt string
+	var contentHash sql.NullString
+	var takenAt sql.NullInt64
+	var latitude, longitude sql.NullFloat64
+	if err := row.Scan(&image.ID, &image.UploadedBy, &image.Name, &createdAt, &contentHash, &image.Width, &image.Height, &takenAt,
+		&image.CameraMake, &image.CameraModel, &image.Orientation, &latitude, &longitude); err != nil {
+		return image, err
+	}
+
+	if seconds, err := strconv.ParseInt(createdAt, 10, 64); err == nil {
+		image.CreatedAt = time.Unix(seconds, 0)
+	}
+	image.ContentHash = contentHash.String
+	image.TakenAt = nullUnix(takenAt)
+	if latitude.Valid && longitude.Valid {
+		image.Latitude, image.Longitude = &latitude.Float64, &longitude.Float64
+	}
+	return image, nil
+}
+
+// where returns the conditions and arguments selecting the images of q.
+func (q ImageQuery) where() (string, []any) {
+	var conditions []string
+	var args []any
+
+	if q.TakenAfter != nil {
+		conditions = append(conditions, "taken_at >= ?")
+		args = append(args, q.TakenAfter.Unix())
+	}
+	if q.TakenBefore != nil {
+		conditions = append(conditions, "taken_at < ?")
+		args = append(args, q.TakenBefore.Unix())
+	}
+	if q.Camera != "" {
+		conditions = append(conditions, "(camera_make || ' ' || camera_model) LIKE ?", "uploaded_by = ?")
+		args = append(args, "%"+q.Camera+"%", q.Viewer)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// orderBy returns the ORDER BY clause of q, with the ID breaking ties.
+func (q ImageQuery) orderBy() string {
+	direction := " ASC"
+	if q.Descending {
+		direction = " DESC"
+	}
+
+	if q.Sort == SortTaken {
+		return " ORDER BY COALESCE(taken_at, CAST(created_at AS INTEGER))" + direction + ", id" + direction
+	}
+	return " ORDER BY id" + direction
 }
 
 func (s *SQLiteDB) GetImages() ([]Image, error) {
-	rows, err := s.db.Query("SELECT id, uploaded_by, name, created_at FROM images")
+	rows, err := s.db.Query("SELECT " + imageColumns + " FROM images")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []Image
+	for rows.Next() {
+		image, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// GetImagesPaginated returns a page of the images selected by q.
+func (s *SQLiteDB) GetImagesPaginated(limit, offset int, q ImageQuery) ([]Image, error) {
+	where, args := q.where()
+	args = append(args, limit, offset)
+
+	rows, err := s.db.Query("SELECT "+imageColumns+" FROM images"+where+q.orderBy()+" LIMIT ? OFFSET ?", args...)
 	if err != nil {
 		return nil, err
 	}
@@ -18,8 +145,7 @@ func (s *SQLiteDB) GetImages() ([]Image, error) {
 
 	var images []Image
 	for rows.Next() {
-		var image Image
-		err := rows.Scan(&image.ID, &image.UploadedBy, &image.Name, &image.CreatedAt)
+		image, err := scanImage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -28,8 +154,39 @@ func (s *SQLiteDB) GetImages() ([]Image, error) {
 	return images, nil
 }
 
-func (s *SQLiteDB) GetImagesPaginated(limit, offset int) ([]Image, error) {
-	rows, err := s.db.Query("SELECT id, uploaded_by, name, created_at FROM images LIMIT ? OFFSET ?", limit, offset)
+// CreateImage records an uploaded image with its metadata and returns its ID. The image counts
+// as a reference to the file with the given content hash.
+func (s *SQLiteDB) CreateImage(uploadedBy int, name, contentHash string, createdAt int64, meta ImageMetadata) (int, error) {
+	var takenAt *int64
+	if meta.TakenAt != nil {
+		t := meta.TakenAt.Unix()
+		takenAt = &t
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`INSERT INTO images (uploaded_by, name, created_at, content_hash, width, height, taken_at, camera_make, camera_model, orientation, latitude, longitude)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		uploadedBy, name, createdAt, sql.NullString{String: contentHash, Valid: contentHash != ""}, meta.Width, meta.Height, takenAt, meta.CameraMake, meta.CameraModel, meta.Orientation, meta.Latitude, meta.Longitude).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := retainBlob(tx, contentHash, name, createdAt); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetImagesByContentHash returns the images stored in the file with the given content hash.
+func (s *SQLiteDB) GetImagesByContentHash(contentHash string) ([]Image, error) {
+	rows, err := s.db.Query("SELECT "+imageColumns+" FROM images WHERE content_hash = ? ORDER BY id", contentHash)
 	if err != nil {
 		return nil, err
 	}
@@ -37,8 +194,7 @@ func (s *SQLiteDB) GetImagesPaginated(limit, offset int) ([]Image, error) {
 
 	var images []Image
 	for rows.Next() {
-		var image Image
-		err := rows.Scan(&image.ID, &image.UploadedBy, &image.Name, &image.CreatedAt)
+		image, err := scanImage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -47,24 +203,46 @@ func (s *SQLiteDB) GetImagesPaginated(limit, offset int) ([]Image, error) {
 	return images, nil
 }
 
-func (s *SQLiteDB) CreateImage(uploadedBy int, name string, createdAt int64) error {
-	_, err := s.db.Exec("INSERT INTO images (uploaded_by, name, created_at) VALUES (?, ?, ?)", uploadedBy, name, createdAt)
-	return err
+// DeleteImage deletes an image together with the records of its variants, and reports whether
+// its files are no longer used by another image and can be removed.
+func (s *SQLiteDB) DeleteImage(id int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var contentHash sql.NullString
+	if err := tx.QueryRow("SELECT content_hash FROM images WHERE id = ?", id).Scan(&contentHash); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM image_variants WHERE image_id = ?", id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM images WHERE id = ?", id); err != nil {
+		return false, err
+	}
+
+	unused, err := releaseBlob(tx, contentHash.String)
+	if err != nil {
+		return false, err
+	}
+
+	return unused, tx.Commit()
 }
 
-func (s *SQLiteDB) DeleteImage(id int) error {
-	_, err := s.db.Exec("DELETE FROM images WHERE id = ?", id)
-	return err
+// GetImageByName returns the image stored under the given file name.
+func (s *SQLiteDB) GetImageByName(name string) (Image, error) {
+	return scanImage(s.db.QueryRow("SELECT "+imageColumns+" FROM images WHERE name = ?", name))
 }
 
 func (s *SQLiteDB) GetImage(id int) (Image, error) {
-	var image Image
-	err := s.db.QueryRow("SELECT id, uploaded_by, name, created_at FROM images WHERE id = ?", id).Scan(&image.ID, &image.UploadedBy, &image.Name, &image.CreatedAt)
-	return image, err
+	return scanImage(s.db.QueryRow("SELECT "+imageColumns+" FROM images WHERE id = ?", id))
 }
 
 func (s *SQLiteDB) GetImagesByUser(userID int) ([]Image, error) {
-	rows, err := s.db.Query("SELECT id, uploaded_by, name, created_at FROM images WHERE uploaded_by = ?", userID)
+	rows, err := s.db.Query("SELECT "+imageColumns+" FROM images WHERE uploaded_by = ?", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -72,8 +250,7 @@ func (s *SQLiteDB) GetImagesByUser(userID int) ([]Image, error) {
 
 	var images []Image
 	for rows.Next() {
-		var image Image
-		err := rows.Scan(&image.ID, &image.UploadedBy, &image.Name, &image.CreatedAt)
+		image, err := scanImage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,122 @@
+package db
+
+import "errors"
+
+// ErrRuleNameTaken is returned when another access rule already has the name.
+var ErrRuleNameTaken = errors.New("rule name already exists")
+
+// AccessRule is a named rule describing the days on which gated content is unlocked.
+type AccessRule struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Year     int    `json:"year,omitempty"`
+	Month    int    `json:"month,omitempty"`
+	Day      int    `json:"day,omitempty"`
+	EndYear  int    `json:"end_year,omitempty"`
+	EndMonth int    `json:"end_month,omitempty"`
+	EndDay   int    `json:"end_day,omitempty"`
+	Weekday  int    `json:"weekday"`
+	Nth      int    `json:"nth,omitempty"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// defaultAccessRules are seeded the first time the access_rules table is created,
+// preserving the original monthly anniversary and Valentine's Day behaviour.
+var defaultAccessRules = []AccessRule{
+	{Name: "anniversary", Kind: "day_of_month", Day: 13, Enabled: true},
+	{Name: "valentine", Kind: "fixed_date", Month: 2, Day: 14, Enabled: true},
+}
+
+const accessRuleColumns = "id, name, kind, year, month, day, end_year, end_month, end_day, weekday, nth, enabled"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAccessRule(row rowScanner) (AccessRule, error) {
+	var rule AccessRule
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Kind, &rule.Year, &rule.Month, &rule.Day,
+		&rule.EndYear, &rule.EndMonth, &rule.EndDay, &rule.Weekday, &rule.Nth, &rule.Enabled)
+	return rule, err
+}
+
+func (s *SQLiteDB) GetAccessRules() ([]AccessRule, error) {
+	rows, err := s.db.Query("SELECT " + accessRuleColumns + " FROM access_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []AccessRule
+	for rows.Next() {
+		rule, err := scanAccessRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *SQLiteDB) GetAccessRule(id int) (AccessRule, error) {
+	return scanAccessRule(s.db.QueryRow("SELECT "+accessRuleColumns+" FROM access_rules WHERE id = ?", id))
+}
+
+// CreateAccessRule stores a new rule. It returns ErrRuleNameTaken when the name exists.
+func (s *SQLiteDB) CreateAccessRule(rule AccessRule) (AccessRule, error) {
+	res, err := s.db.Exec(`INSERT INTO access_rules (name, kind, year, month, day, end_year, end_month, end_day, weekday, nth, enabled)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM access_rules WHERE name = ?)`,
+		rule.Name, rule.Kind, rule.Year, rule.Month, rule.Day, rule.EndYear, rule.EndMonth, rule.EndDay, rule.Weekday, rule.Nth, rule.Enabled, rule.Name)
+	if err != nil {
+		return AccessRule{}, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return AccessRule{}, err
+	} else if n == 0 {
+		return AccessRule{}, ErrRuleNameTaken
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return AccessRule{}, err
+	}
+	return s.GetAccessRule(int(id))
+}
+
+// UpdateAccessRule replaces a rule and reports whether it exists. It returns ErrRuleNameTaken
+// when another rule has the name.
+func (s *SQLiteDB) UpdateAccessRule(rule AccessRule) (bool, error) {
+	res, err := s.db.Exec(`UPDATE access_rules SET name = ?, kind = ?, year = ?, month = ?, day = ?, end_year = ?, end_month = ?, end_day = ?, weekday = ?, nth = ?, enabled = ?
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM access_rules WHERE name = ? AND id != ?)`,
+		rule.Name, rule.Kind, rule.Year, rule.Month, rule.Day, rule.EndYear, rule.EndMonth, rule.EndDay, rule.Weekday, rule.Nth, rule.Enabled, rule.ID, rule.Name, rule.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return n == 1, err
+	}
+
+	// Nothing was updated: either the rule does not exist or its new name is taken
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM access_rules WHERE id = ?)", rule.ID).Scan(&exists); err != nil {
+		return false, err
+	}
+	if exists {
+		return true, ErrRuleNameTaken
+	}
+	return false, nil
+}
+
+// DeleteAccessRule removes a rule and reports whether it existed.
+func (s *SQLiteDB) DeleteAccessRule(id int) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM access_rules WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
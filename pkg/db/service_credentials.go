@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ServiceCredential is a named key that lets a service call admin or metrics endpoints.
+// Only the hash of the key is stored.
+type ServiceCredential struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Purpose    string     `json:"purpose"`
+	KeyHash    string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+const serviceCredentialColumns = "id, name, purpose, key_hash, created_at, expires_at, last_used_at"
+
+func scanServiceCredential(row rowScanner) (ServiceCredential, error) {
+	var credential ServiceCredential
+	var createdAt int64
+	var expiresAt, lastUsedAt sql.NullInt64
+
+	err := row.Scan(&credential.ID, &credential.Name, &credential.Purpose, &credential.KeyHash, &createdAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return credential, err
+	}
+
+	credential.CreatedAt = time.Unix(createdAt, 0)
+	credential.ExpiresAt = nullUnix(expiresAt)
+	credential.LastUsedAt = nullUnix(lastUsedAt)
+	return credential, nil
+}
+
+// CreateServiceCredential stores a credential and returns it with its ID. A credential
+// with the same name is replaced.
+func (s *SQLiteDB) CreateServiceCredential(credential ServiceCredential) (ServiceCredential, error) {
+	var expiresAt sql.NullInt64
+	if credential.ExpiresAt != nil {
+		expiresAt = sql.NullInt64{Int64: credential.ExpiresAt.Unix(), Valid: true}
+	}
+
+	err := s.db.QueryRow(`INSERT INTO service_credentials (name, purpose, key_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET purpose = excluded.purpose, key_hash = excluded.key_hash, created_at = excluded.created_at,
+			expires_at = excluded.expires_at, last_used_at = NULL
+		RETURNING id`,
+		credential.Name, credential.Purpose, credential.KeyHash, credential.CreatedAt.Unix(), expiresAt).Scan(&credential.ID)
+	return credential, err
+}
+
+func (s *SQLiteDB) GetServiceCredentials() ([]ServiceCredential, error) {
+	rows, err := s.db.Query("SELECT " + serviceCredentialColumns + " FROM service_credentials ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []ServiceCredential
+	for rows.Next() {
+		credential, err := scanServiceCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func (s *SQLiteDB) UpdateServiceCredentialUsage(id int, usedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE service_credentials SET last_used_at = ? WHERE id = ?", usedAt.Unix(), id)
+	return err
+}
+
+// DeleteServiceCredential revokes a credential and reports whether it existed.
+func (s *SQLiteDB) DeleteServiceCredential(id int) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM service_credentials WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLiteDB) DeleteServiceCredentialByName(name string) error {
+	_, err := s.db.Exec("DELETE FROM service_credentials WHERE name = ?", name)
+	return err
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SigningKey is a persisted JWT signing key. PrivateKey holds a PKCS#8 DER key for
+// asymmetric algorithms or the raw secret for HMAC.
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey []byte
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+}
+
+func (s *SQLiteDB) CreateSigningKey(key SigningKey) error {
+	_, err := s.db.Exec("INSERT INTO signing_keys (kid, algorithm, private_key, created_at) VALUES (?, ?, ?, ?)",
+		key.ID, key.Algorithm, key.PrivateKey, key.CreatedAt.Unix())
+	return err
+}
+
+func (s *SQLiteDB) GetSigningKeys() ([]SigningKey, error) {
+	rows, err := s.db.Query("SELECT kid, algorithm, private_key, created_at, retired_at FROM signing_keys ORDER BY created_at, rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SigningKey
+	for rows.Next() {
+		var key SigningKey
+		var createdAt int64
+		var retiredAt sql.NullInt64
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &createdAt, &retiredAt); err != nil {
+			return nil, err
+		}
+		key.CreatedAt = time.Unix(createdAt, 0)
+		key.RetiredAt = nullUnix(retiredAt)
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RetireSigningKeys retires every active key except the one identified by keep.
+func (s *SQLiteDB) RetireSigningKeys(keep string, retiredAt time.Time) error {
+	_, err := s.db.Exec("UPDATE signing_keys SET retired_at = ? WHERE kid != ? AND retired_at IS NULL", retiredAt.Unix(), keep)
+	return err
+}
+
+func (s *SQLiteDB) DeleteSigningKeysRetiredBefore(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM signing_keys WHERE retired_at IS NOT NULL AND retired_at < ?", before.Unix())
+	return err
+}
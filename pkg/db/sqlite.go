@@ -6,7 +6,6 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -44,12 +43,48 @@ func (s *SQLiteDB) Close() error {
 	return s.db.Close()
 }
 
+// addedColumns lists columns added to existing tables after the initial schema.
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"users", "token_generation", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "totp_secret", "TEXT"},
+	{"users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "role", "TEXT NOT NULL DEFAULT 'member'"},
+	{"users", "disabled", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "password_reset_required", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "created_at", "INTEGER"},
+	{"users", "last_login_at", "INTEGER"},
+	{"images", "width", "INTEGER NOT NULL DEFAULT 0"},
+	{"images", "height", "INTEGER NOT NULL DEFAULT 0"},
+	{"images", "taken_at", "INTEGER"},
+	{"images", "camera_make", "TEXT NOT NULL DEFAULT ''"},
+	{"images", "camera_model", "TEXT NOT NULL DEFAULT ''"},
+	{"images", "orientation", "INTEGER NOT NULL DEFAULT 0"},
+	{"images", "latitude", "REAL"},
+	{"images", "longitude", "REAL"},
+	{"images", "content_hash", "TEXT"},
+	{"keys", "label", "TEXT NOT NULL DEFAULT ''"},
+	{"keys", "created_by", "TEXT NOT NULL DEFAULT ''"},
+	{"keys", "created_at", "INTEGER"},
+	{"keys", "expires_at", "INTEGER"},
+	{"keys", "max_uses", "INTEGER NOT NULL DEFAULT 1"},
+	{"keys", "remaining_uses", "INTEGER NOT NULL DEFAULT 1"},
+	{"keys", "role", "TEXT NOT NULL DEFAULT 'member'"},
+}
+
 // Migrate creates the necessary tables in the database.
 func (s *SQLiteDB) Migrate() error {
 
-	keys := genKeys(3)
+	seedRules, err := s.tableMissing("access_rules")
+	if err != nil {
+		return err
+	}
 
-	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS users (
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY,
 		username TEXT NOT NULL,
 		password TEXT NOT NULL
@@ -64,29 +99,208 @@ func (s *SQLiteDB) Migrate() error {
 		name TEXT NOT NULL,
 		created_at TEXT NOT NULL,
 		FOREIGN KEY(uploaded_by) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS access_rules (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		kind TEXT NOT NULL,
+		year INTEGER NOT NULL DEFAULT 0,
+		month INTEGER NOT NULL DEFAULT 0,
+		day INTEGER NOT NULL DEFAULT 0,
+		end_year INTEGER NOT NULL DEFAULT 0,
+		end_month INTEGER NOT NULL DEFAULT 0,
+		end_day INTEGER NOT NULL DEFAULT 0,
+		weekday INTEGER NOT NULL DEFAULT 0,
+		nth INTEGER NOT NULL DEFAULT 0,
+		enabled INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		family_id TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		used_at INTEGER,
+		revoked_at INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS signing_keys (
+		kid TEXT PRIMARY KEY,
+		algorithm TEXT NOT NULL,
+		private_key BLOB NOT NULL,
+		created_at INTEGER NOT NULL,
+		retired_at INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS passkeys (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		credential_id BLOB NOT NULL UNIQUE,
+		public_key BLOB NOT NULL,
+		attestation_type TEXT NOT NULL,
+		aaguid BLOB,
+		sign_count INTEGER NOT NULL DEFAULT 0,
+		transports TEXT NOT NULL DEFAULT '',
+		backup_eligible INTEGER NOT NULL DEFAULT 0,
+		backup_state INTEGER NOT NULL DEFAULT 0,
+		name TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS webauthn_sessions (
+		id TEXT PRIMARY KEY,
+		purpose TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS login_failures (
+		scope TEXT NOT NULL,
+		identifier TEXT NOT NULL,
+		failures INTEGER NOT NULL,
+		last_failure_at INTEGER NOT NULL,
+		blocked_until INTEGER,
+		PRIMARY KEY(scope, identifier)
+	);
+	CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		used_at INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS access_tokens (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	CREATE TABLE IF NOT EXISTS service_credentials (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		purpose TEXT NOT NULL,
+		key_hash TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER,
+		last_used_at INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id INTEGER PRIMARY KEY,
+		created_at INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		outcome TEXT NOT NULL,
+		actor_id INTEGER,
+		actor TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL DEFAULT '',
+		details TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
+	CREATE TABLE IF NOT EXISTS image_variants (
+		id INTEGER PRIMARY KEY,
+		image_id INTEGER NOT NULL,
+		size TEXT NOT NULL,
+		name TEXT NOT NULL,
+		format TEXT NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		UNIQUE(image_id, size),
+		FOREIGN KEY(image_id) REFERENCES images(id)
+	);
+	CREATE TABLE IF NOT EXISTS blobs (
+		hash TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		ref_count INTEGER NOT NULL,
+		created_at INTEGER NOT NULL
 	);`)
 
 	if err != nil {
 		return err
 	}
 
-	for _, key := range keys {
-		_, err = s.db.Exec("INSERT INTO keys (key) VALUES (?)", key)
-		if err != nil {
+	// Columns added after the initial schema
+	for _, c := range addedColumns {
+		if err := s.addColumn(c.table, c.column, c.definition); err != nil {
 			return err
 		}
 	}
 
+	// Indexes on added columns can only be created once the columns exist
+	if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_images_taken_at ON images(taken_at)"); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_images_content_hash ON images(content_hash)"); err != nil {
+		return err
+	}
+
+	// Seed the default access rules only when the table is created, so rules
+	// removed through the admin API are not restored on the next start.
+	if seedRules {
+		for _, rule := range defaultAccessRules {
+			if _, err := s.CreateAccessRule(rule); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-func genKeys(n int) []string {
+// addColumn adds a column to an existing table unless it is already present.
+func (s *SQLiteDB) addColumn(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-	keys := make([]string, n)
-	for i := 0; i < n; i++ {
-		keys[i] = uuid.NewString()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
 	}
-	return keys
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// tableMissing reports whether the named table does not exist yet.
+func (s *SQLiteDB) tableMissing(name string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&count)
+	return count == 0, err
 }
 
 func (s *SQLiteDB) GetTotalPictures() (int, error) {
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RefreshToken is a persisted, hashed refresh token belonging to a rotation family.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func (s *SQLiteDB) CreateRefreshToken(userID int, familyID, tokenHash string, createdAt, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO refresh_tokens (user_id, family_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		userID, familyID, tokenHash, createdAt.Unix(), expiresAt.Unix())
+	return err
+}
+
+func (s *SQLiteDB) GetRefreshToken(tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	var createdAt, expiresAt int64
+	var usedAt, revokedAt sql.NullInt64
+
+	err := s.db.QueryRow("SELECT id, user_id, family_id, token_hash, created_at, expires_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = ?", tokenHash).
+		Scan(&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &createdAt, &expiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	token.CreatedAt = time.Unix(createdAt, 0)
+	token.ExpiresAt = time.Unix(expiresAt, 0)
+	token.UsedAt = nullUnix(usedAt)
+	token.RevokedAt = nullUnix(revokedAt)
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed marks the token as used and reports whether this call was the one
+// that did so, making rotation safe against concurrent redemption of the same token.
+func (s *SQLiteDB) MarkRefreshTokenUsed(id int, usedAt time.Time) (bool, error) {
+	res, err := s.db.Exec("UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL AND revoked_at IS NULL", usedAt.Unix(), id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *SQLiteDB) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL", revokedAt.Unix(), familyID)
+	return err
+}
+
+func (s *SQLiteDB) RevokeUserRefreshTokens(userID int, revokedAt time.Time) error {
+	_, err := s.db.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", revokedAt.Unix(), userID)
+	return err
+}
+
+func (s *SQLiteDB) DeleteExpiredRefreshTokens(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM refresh_tokens WHERE expires_at < ?", now.Unix())
+	return err
+}
+
+func (s *SQLiteDB) RevokeToken(jti string, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO revoked_tokens (jti, expires_at) VALUES (?, ?)", jti, expiresAt.Unix())
+	return err
+}
+
+// GetRevokedTokens returns the revoked token IDs that have not yet expired, mapped to their expiry.
+func (s *SQLiteDB) GetRevokedTokens(now time.Time) (map[string]time.Time, error) {
+	rows, err := s.db.Query("SELECT jti, expires_at FROM revoked_tokens WHERE expires_at >= ?", now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt int64
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = time.Unix(expiresAt, 0)
+	}
+	return revoked, rows.Err()
+}
+
+func (s *SQLiteDB) DeleteExpiredRevokedTokens(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", now.Unix())
+	return err
+}
+
+func nullUnix(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.Unix(v.Int64, 0)
+	return &t
+}
@@ -1,13 +1,45 @@
 package db
 
+import (
+	"database/sql"
+	"time"
+)
+
+// Roles a user can hold
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
 type User struct {
-	ID       int
-	Username string
-	Password string
+	ID                    int
+	Username              string
+	Password              string
+	Role                  string
+	Disabled              bool
+	PasswordResetRequired bool
+	TOTPSecret            string
+	TOTPEnabled           bool
+}
+
+const userColumns = "id, username, password, role, disabled, password_reset_required, totp_secret, totp_enabled"
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	return role == RoleMember || role == RoleAdmin
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	user := &User{}
+	var totpSecret sql.NullString
+	err := row.Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.Disabled, &user.PasswordResetRequired, &totpSecret, &user.TOTPEnabled)
+	user.TOTPSecret = totpSecret.String
+	return user, err
 }
 
 func (s *SQLiteDB) CreateUser(username, password string) (*User, error) {
-	_, err := s.db.Exec("INSERT INTO users (username, password) VALUES (?, ?)", username, password)
+	now := time.Now().Unix()
+	_, err := s.db.Exec("INSERT INTO users (username, password, created_at, token_generation) VALUES (?, ?, ?, ?)", username, password, now, now)
 	if err != nil {
 		return nil, err
 	}
@@ -15,46 +47,312 @@ func (s *SQLiteDB) CreateUser(username, password string) (*User, error) {
 }
 
 func (s *SQLiteDB) GetUser(username string) (*User, error) {
-	user := &User{}
-	err := s.db.QueryRow("SELECT id, username, password FROM users WHERE username = ?", username).Scan(&user.ID, &user.Username, &user.Password)
-	return user, err
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
 }
 
-func (s *SQLiteDB) CreateKey(key string) error {
-	_, err := s.db.Exec("INSERT INTO keys (key) VALUES (?)", key)
-	return err
+func (s *SQLiteDB) GetUserByID(id int) (*User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
 }
 
-func (s *SQLiteDB) ContainsKey(key string) (bool, error) {
+func (s *SQLiteDB) CountUsers() (int, error) {
 	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM keys WHERE key = ?", key).Scan(&count)
-	return count > 0, err
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
 }
 
-func (s *SQLiteDB) DeleteKey(key string) error {
-	_, err := s.db.Exec("DELETE FROM keys WHERE key = ?", key)
+func (s *SQLiteDB) GetTokenGeneration(userID int) (int, error) {
+	var generation int
+	err := s.db.QueryRow("SELECT token_generation FROM users WHERE id = ?", userID).Scan(&generation)
+	return generation, err
+}
+
+// IncrementTokenGeneration bumps the user's token generation, invalidating every token issued before it.
+func (s *SQLiteDB) IncrementTokenGeneration(userID int) (int, error) {
+	if _, err := s.db.Exec("UPDATE users SET token_generation = token_generation + 1 WHERE id = ?", userID); err != nil {
+		return 0, err
+	}
+	return s.GetTokenGeneration(userID)
+}
+
+// SetPendingTOTPSecret stores an encrypted TOTP secret that is not enabled until verified.
+func (s *SQLiteDB) SetPendingTOTPSecret(userID int, encryptedSecret string) error {
+	_, err := s.db.Exec("UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE id = ?", encryptedSecret, userID)
 	return err
 }
 
-func (s *SQLiteDB) GetKeys() ([]string, error) {
-	rows, err := s.db.Query("SELECT key FROM keys")
+// EnableTOTP enables two-factor authentication and replaces the user's recovery codes atomically.
+func (s *SQLiteDB) EnableTOTP(userID int, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 1 WHERE id = ?", userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP removes the user's TOTP secret and recovery codes.
+func (s *SQLiteDB) DisableTOTP(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records the last accepted TOTP step and reports false if the step was
+// already used, preventing a code from being replayed.
+func (s *SQLiteDB) UseTOTPStep(userID int, step int64) (bool, error) {
+	res, err := s.db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode consumes an unused recovery code and reports whether one matched.
+func (s *SQLiteDB) UseRecoveryCode(userID int, codeHash string, usedAt time.Time) (bool, error) {
+	res, err := s.db.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL", usedAt.Unix(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UserInfo is the admin view of a user, with activity counts.
+type UserInfo struct {
+	ID                    int        `json:"id"`
+	Username              string     `json:"username"`
+	Role                  string     `json:"role"`
+	Disabled              bool       `json:"disabled"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	TOTPEnabled           bool       `json:"totp_enabled"`
+	CreatedAt             *time.Time `json:"created_at,omitempty"`
+	LastLoginAt           *time.Time `json:"last_login_at,omitempty"`
+	Uploads               int        `json:"uploads"`
+	Passkeys              int        `json:"passkeys"`
+}
+
+const userInfoQuery = `SELECT u.id, u.username, u.role, u.disabled, u.password_reset_required, u.totp_enabled, u.created_at, u.last_login_at,
+		(SELECT COUNT(*) FROM images WHERE uploaded_by = u.id),
+		(SELECT COUNT(*) FROM passkeys WHERE user_id = u.id)
+	FROM users u`
+
+func scanUserInfo(row rowScanner) (*UserInfo, error) {
+	var info UserInfo
+	var createdAt, lastLoginAt sql.NullInt64
+
+	err := row.Scan(&info.ID, &info.Username, &info.Role, &info.Disabled, &info.PasswordResetRequired, &info.TOTPEnabled,
+		&createdAt, &lastLoginAt, &info.Uploads, &info.Passkeys)
+	if err != nil {
+		return nil, err
+	}
+
+	info.CreatedAt = nullUnix(createdAt)
+	info.LastLoginAt = nullUnix(lastLoginAt)
+	return &info, nil
+}
+
+// ListUsers returns a page of users ordered by ID.
+func (s *SQLiteDB) ListUsers(limit, offset int) ([]UserInfo, error) {
+	rows, err := s.db.Query(userInfoQuery+" ORDER BY u.id LIMIT ? OFFSET ?", limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var keys []string
+	var users []UserInfo
 	for rows.Next() {
-		var key string
-		if err := rows.Scan(&key); err != nil {
+		info, err := scanUserInfo(rows)
+		if err != nil {
 			return nil, err
 		}
-		keys = append(keys, key)
+		users = append(users, *info)
 	}
-	return keys, nil
+	return users, rows.Err()
 }
 
-func (s *SQLiteDB) AddKey(key string) error {
-	_, err := s.db.Exec("INSERT INTO keys (key) VALUES (?)", key)
+func (s *SQLiteDB) GetUserInfo(id int) (*UserInfo, error) {
+	return scanUserInfo(s.db.QueryRow(userInfoQuery+" WHERE u.id = ?", id))
+}
+
+func (s *SQLiteDB) RecordLogin(userID int, at time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET last_login_at = ? WHERE id = ?", at.Unix(), userID)
 	return err
 }
+
+// GetDisabledUserIDs returns the IDs of all disabled users.
+func (s *SQLiteDB) GetDisabledUserIDs() ([]int, error) {
+	rows, err := s.db.Query("SELECT id FROM users WHERE disabled = 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetUserDisabled disables or enables a user and reports whether the user exists.
+func (s *SQLiteDB) SetUserDisabled(userID int, disabled bool) (bool, error) {
+	res, err := s.db.Exec("UPDATE users SET disabled = ? WHERE id = ?", disabled, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// SetUserRole changes a user's role and reports whether the user exists.
+func (s *SQLiteDB) SetUserRole(userID int, role string) (bool, error) {
+	res, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ImportUser creates a user with a password hash from another system. It returns
+// ErrUsernameTaken when the username exists.
+func (s *SQLiteDB) ImportUser(username, passwordHash, role string, now time.Time) (*User, error) {
+	res, err := s.db.Exec(`INSERT INTO users (username, password, role, created_at, token_generation)
+		SELECT ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM users WHERE username = ?)`,
+		username, passwordHash, role, now.Unix(), now.Unix(), username)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrUsernameTaken
+	}
+
+	return s.GetUser(username)
+}
+
+// ReplacePasswordHash stores a rehash of the user's current password. It does nothing
+// if the password was changed since oldHash was read.
+func (s *SQLiteDB) ReplacePasswordHash(userID int, oldHash, newHash string) error {
+	_, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ? AND password = ?", newHash, userID, oldHash)
+	return err
+}
+
+// RequirePasswordReset blocks password login for the user until a reset token is redeemed.
+func (s *SQLiteDB) RequirePasswordReset(userID int) error {
+	_, err := s.db.Exec("UPDATE users SET password_reset_required = 1 WHERE id = ?", userID)
+	return err
+}
+
+// DeleteUser deletes a user and everything that belongs to them in one transaction. Their images
+// are reassigned to reassignTo, or deleted when reassignTo is 0. The number of deleted images is
+// returned along with those whose files no other image uses, so the caller can remove the files.
+func (s *SQLiteDB) DeleteUser(userID, reassignTo int) (int, []Image, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	var deleted, unused []Image
+	if reassignTo != 0 {
+		if _, err := tx.Exec("UPDATE images SET uploaded_by = ? WHERE uploaded_by = ?", reassignTo, userID); err != nil {
+			return 0, nil, err
+		}
+	} else {
+		rows, err := tx.Query("SELECT "+imageColumns+" FROM images WHERE uploaded_by = ?", userID)
+		if err != nil {
+			return 0, nil, err
+		}
+		for rows.Next() {
+			image, err := scanImage(rows)
+			if err != nil {
+				rows.Close()
+				return 0, nil, err
+			}
+			deleted = append(deleted, image)
+		}
+		rows.Close()
+
+		// The variant files are deleted along with the originals
+		index := make(map[int]int, len(deleted))
+		for i, image := range deleted {
+			index[image.ID] = i
+		}
+		rows, err = tx.Query("SELECT "+imageVariantColumns+" FROM image_variants WHERE image_id IN (SELECT id FROM images WHERE uploaded_by = ?)", userID)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := collectImageVariants(rows, deleted, index); err != nil {
+			return 0, nil, err
+		}
+
+		if _, err := tx.Exec("DELETE FROM image_variants WHERE image_id IN (SELECT id FROM images WHERE uploaded_by = ?)", userID); err != nil {
+			return 0, nil, err
+		}
+		if _, err := tx.Exec("DELETE FROM images WHERE uploaded_by = ?", userID); err != nil {
+			return 0, nil, err
+		}
+
+		// Files shared with the images of other users are kept
+		for _, image := range deleted {
+			ok, err := releaseBlob(tx, image.ContentHash)
+			if err != nil {
+				return 0, nil, err
+			}
+			if ok {
+				unused = append(unused, image)
+			}
+		}
+	}
+
+	for _, table := range []string{"refresh_tokens", "recovery_codes", "passkeys", "webauthn_sessions", "password_reset_tokens", "access_tokens"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", userID); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", userID); err != nil {
+		return 0, nil, err
+	}
+
+	return len(deleted), unused, tx.Commit()
+}
@@ -0,0 +1,137 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// maxChunkSize bounds the PNG and WebP chunks read while looking for EXIF data
+const maxChunkSize = 1 << 20
+
+// Exif is the subset of a picture's EXIF metadata that is stored
+type Exif struct {
+	TakenAt     *time.Time
+	CameraMake  string
+	CameraModel string
+	// Orientation is the EXIF orientation from 1 to 8, or 0 when absent
+	Orientation int
+	Latitude    *float64
+	Longitude   *float64
+}
+
+// ReadExif extracts the EXIF metadata of an image in the given format. Missing or unreadable
+// metadata is not an error; the fields that could not be read are left empty.
+func ReadExif(r io.ReadSeeker, format string) (meta Exif) {
+	// EXIF data comes from the uploader, so a parser panic must not take the request down
+	defer func() {
+		if recover() != nil {
+			meta = Exif{}
+		}
+	}()
+
+	var source io.Reader
+	switch format {
+	case FormatJPEG:
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return meta
+		}
+		source = r
+	case FormatPNG:
+		source = findChunk(r, 8, "eXIf", binary.BigEndian, false)
+	case FormatWebP:
+		source = findChunk(r, 12, "EXIF", binary.LittleEndian, true)
+	}
+	if source == nil {
+		return meta
+	}
+
+	x, err := exif.Decode(source)
+	if err != nil && x == nil {
+		return meta
+	}
+
+	if t, err := x.DateTime(); err == nil && t.Year() > 1900 {
+		meta.TakenAt = &t
+	}
+	meta.CameraMake = exifString(x, exif.Make)
+	meta.CameraModel = exifString(x, exif.Model)
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if v, err := tag.Int(0); err == nil && v >= 1 && v <= 8 {
+			meta.Orientation = v
+		}
+	}
+	if lat, long, err := x.LatLong(); err == nil && validCoordinates(lat, long) {
+		meta.Latitude, meta.Longitude = &lat, &long
+	}
+
+	return meta
+}
+
+// exifString returns a string tag without the padding some cameras add
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// validCoordinates rejects the zero and out of range positions some cameras write without a fix
+func validCoordinates(lat, long float64) bool {
+	if math.IsNaN(lat) || math.IsNaN(long) || (lat == 0 && long == 0) {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && long >= -180 && long <= 180
+}
+
+// findChunk walks the chunks of a PNG (type after length) or RIFF (type before length, padded to
+// even sizes) file starting at offset and returns the data of the first chunk of the given type.
+func findChunk(r io.ReadSeeker, offset int64, chunkType string, order binary.ByteOrder, riff bool) io.Reader {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil
+		}
+
+		typ, size := string(header[4:]), order.Uint32(header[:4])
+		if riff {
+			typ, size = string(header[:4]), order.Uint32(header[4:])
+		}
+
+		if typ == chunkType {
+			if size > maxChunkSize {
+				return nil
+			}
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil
+			}
+			return bytes.NewReader(data)
+		}
+
+		// Skip the data, the CRC of PNG chunks and the padding byte of odd-sized RIFF chunks
+		skip := int64(size)
+		if riff {
+			skip += int64(size % 2)
+		} else {
+			skip += 4
+		}
+		if _, err := r.Seek(skip, io.SeekCurrent); err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+
+	"golang.org/x/image/draw"
+
+	// Register the decoders for the formats pictures are uploaded in
+	_ "golang.org/x/image/webp"
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Formats pictures are accepted in, named as reported by image.Decode
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+)
+
+const (
+	// SniffLen is the number of leading bytes Sniff needs to identify a format
+	SniffLen = 12
+	// jpegQuality is the quality derivatives are encoded with
+	jpegQuality = 85
+)
+
+var (
+	// ErrTooLarge is returned for images with more pixels than allowed
+	ErrTooLarge = errors.New("image dimensions too large")
+	// ErrUnsupportedFormat is returned for files that are not in an accepted format
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrFormatMismatch is returned when the decoded format differs from the sniffed one
+	ErrFormatMismatch = errors.New("image content does not match its format")
+)
+
+// extensions maps accepted formats to the extension files are stored with
+var extensions = map[string]string{
+	FormatJPEG: ".jpg",
+	FormatPNG:  ".png",
+	FormatGIF:  ".gif",
+	FormatWebP: ".webp",
+}
+
+// Limits bound the pictures that are accepted, so a small file with huge dimensions
+// cannot exhaust memory when it is decoded
+type Limits struct {
+	MaxBytes  int64
+	MaxPixels int
+}
+
+// DefaultLimits returns the limits used when none are configured
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBytes:  25 << 20,
+		MaxPixels: 64 * 1000 * 1000,
+	}
+}
+
+// Sniff identifies the format of an image from its leading bytes, regardless of
+// its name or declared content type
+func Sniff(header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte("\xff\xd8\xff")):
+		return FormatJPEG, nil
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG, nil
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return FormatGIF, nil
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return FormatWebP, nil
+	}
+	return "", ErrUnsupportedFormat
+}
+
+// Extension returns the file extension of an accepted format
+func Extension(format string) string {
+	return extensions[format]
+}
+
+// Size is a derivative of a picture, scaled so its longest edge is at most MaxEdge pixels
+type Size struct {
+	Name    string
+	MaxEdge int
+}
+
+// Sizes lists the derivatives generated for every picture, smallest first
+var Sizes = []Size{
+	{Name: "thumbnail", MaxEdge: 320},
+	{Name: "medium", MaxEdge: 1280},
+	{Name: "large", MaxEdge: 2048},
+}
+
+// SizeByName returns the size with the given name
+func SizeByName(name string) (Size, bool) {
+	for _, size := range Sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return Size{}, false
+}
+
+// Decode reads an image and returns it with its format, after checking that its
+// dimensions are within maxPixels
+func Decode(r io.ReadSeeker, maxPixels int) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image header: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, "", fmt.Errorf("invalid image dimensions %dx%d", config.Width, config.Height)
+	}
+	if config.Width > maxPixels/config.Height {
+		return nil, "", ErrTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, format, nil
+}
+
+// Fits reports whether img is already no larger than size, so scaling it would only upscale
+func Fits(img image.Image, size Size) bool {
+	b := img.Bounds()
+	return b.Dx() <= size.MaxEdge && b.Dy() <= size.MaxEdge
+}
+
+// Resize scales img down so its longest edge is size.MaxEdge, keeping the aspect ratio.
+// Transparent areas are filled with white, as JPEG has no alpha channel.
+func Resize(img image.Image, size Size) image.Image {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width >= height {
+		height = max(1, height*size.MaxEdge/width)
+		width = size.MaxEdge
+	} else {
+		width = max(1, width*size.MaxEdge/height)
+		height = size.MaxEdge
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// EncodeJPEG writes img as a JPEG
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+}
+
+// Encode writes img in one of the formats derivatives can be stored in: JPEG, or WebP
+// when EncodesWebP is set
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FormatJPEG:
+		return EncodeJPEG(w, img)
+	case FormatWebP:
+		return EncodeWebP(w, img)
+	}
+	return ErrUnsupportedFormat
+}
@@ -0,0 +1,17 @@
+//go:build !webp
+
+package imaging
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// EncodesWebP reports whether this build can encode WebP, which needs libwebp and the webp build tag
+const EncodesWebP = false
+
+// EncodeWebP fails, as the binary was built without the webp build tag.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	return errors.New("WebP encoding needs a build with the webp tag")
+}
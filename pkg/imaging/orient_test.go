@@ -0,0 +1,77 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// letterImage returns an image whose pixels encode the letters of rows, one row per string,
+// in their red channel.
+func letterImage(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, letter := range row {
+			img.Set(x, y, color.RGBA{R: uint8(letter), A: 255})
+		}
+	}
+	return img
+}
+
+// letters returns the rows of letters encoded in an image by letterImage.
+func letters(img image.Image) string {
+	b := img.Bounds()
+	rows := make([]string, 0, b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			row.WriteByte(byte(r >> 8))
+		}
+		rows = append(rows, row.String())
+	}
+	return strings.Join(rows, "/")
+}
+
+func TestOrient(t *testing.T) {
+	// The stored picture, as a camera saved it:
+	//   abc
+	//   def
+	// Each orientation says where the stored first row and column are meant to be displayed.
+	tests := []struct {
+		orientation int
+		upright     string
+	}{
+		{0, "abc/def"},
+		{1, "abc/def"},
+		{2, "cba/fed"},  // first row at the top, first column on the right
+		{3, "fed/cba"},  // first row at the bottom, first column on the right
+		{4, "def/abc"},  // first row at the bottom, first column on the left
+		{5, "ad/be/cf"}, // first row on the left, first column at the top
+		{6, "da/eb/fc"}, // first row on the right, first column at the top
+		{7, "fc/eb/da"}, // first row on the right, first column at the bottom
+		{8, "cf/be/ad"}, // first row on the left, first column at the bottom
+		{9, "abc/def"},  // unknown values are ignored
+	}
+
+	for _, tt := range tests {
+		stored := letterImage("abc", "def")
+		if got := letters(Orient(stored, tt.orientation)); got != tt.upright {
+			t.Errorf("orientation %d: got %s, want %s", tt.orientation, got, tt.upright)
+		}
+	}
+}
+
+func TestOrientOffsetBounds(t *testing.T) {
+	// Sub-images keep the bounds of their parent; the result starts at the origin
+	stored := letterImage("xxxx", "xabc", "xdef").SubImage(image.Rect(1, 1, 4, 3))
+
+	oriented := Orient(stored, 6)
+	if oriented.Bounds() != image.Rect(0, 0, 2, 3) {
+		t.Fatalf("bounds = %v, want %v", oriented.Bounds(), image.Rect(0, 0, 2, 3))
+	}
+	if got := letters(oriented); got != "da/eb/fc" {
+		t.Fatalf("got %s, want da/eb/fc", got)
+	}
+}
@@ -0,0 +1,311 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// originalQuality is the quality originals are encoded with when they have to be rotated
+const originalQuality = 95
+
+// errMalformed is returned when the container of a picture cannot be walked to remove its metadata
+var errMalformed = errors.New("malformed image container")
+
+// pngMetadataChunks are the PNG chunks holding EXIF data, free text or the modification time
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// gifApplicationsKept are the GIF application extensions that affect how a picture plays or
+// displays: animation looping and the colour profile. Others, such as XMP, are metadata.
+var gifApplicationsKept = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+	"ICCRGBG1012": true,
+}
+
+// Sanitize writes a copy of the picture read from r to w without its metadata, and returns the
+// format written. Pictures with an EXIF orientation other than 1 are re-encoded from oriented,
+// the decoded picture after Orient, so they display upright without the tag; WebP has no
+// encoder and is written as PNG in that case. Other pictures are copied with their metadata
+// segments removed, leaving the image data untouched. GIF has no EXIF; its comments and
+// metadata application extensions are removed.
+func Sanitize(w io.Writer, r io.Reader, format string, oriented image.Image, orientation int) (string, error) {
+	if orientation >= 2 && orientation <= 8 {
+		switch format {
+		case FormatJPEG:
+			return format, jpeg.Encode(w, oriented, &jpeg.Options{Quality: originalQuality})
+		case FormatPNG, FormatWebP:
+			return FormatPNG, png.Encode(w, oriented)
+		}
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case FormatJPEG:
+		data, err = stripJPEG(data)
+	case FormatPNG:
+		data, err = stripPNG(data)
+	case FormatWebP:
+		data, err = stripWebP(data)
+	case FormatGIF:
+		data, err = stripGIF(data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = w.Write(data)
+	return format, err
+}
+
+// stripJPEG removes the EXIF, XMP, IPTC, vendor and comment segments of a JPEG, along with
+// anything after its end, such as the secondary images some phones append. The JFIF header,
+// the colour profile and the Adobe segment are kept as they affect how the picture displays.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+
+	i := 2
+	for i+1 < len(data) {
+		if data[i] != 0xFF {
+			return nil, errMalformed
+		}
+
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker
+			i++
+			continue
+		case marker == 0xD9:
+			return append(out, 0xFF, 0xD9), nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Markers without a length
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errMalformed
+		}
+
+		if keepJPEGSegment(marker, data[i+4:end]) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+
+		if marker == 0xDA {
+			// Copy the entropy-coded scan up to the next marker; 0xFF is followed by a zero
+			// byte or a restart marker within the scan
+			j := i
+			for j+1 < len(data) && (data[j] != 0xFF || data[j+1] == 0x00 || (data[j+1] >= 0xD0 && data[j+1] <= 0xD7)) {
+				j++
+			}
+			if j+1 >= len(data) {
+				// No end marker; keep the remaining scan data as the decoder accepted it
+				return append(out, data[i:]...), nil
+			}
+			out = append(out, data[i:j]...)
+			i = j
+		}
+	}
+
+	return out, nil
+}
+
+// keepJPEGSegment reports whether a JPEG segment is kept by stripJPEG
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0, marker == 0xEE:
+		// JFIF and Adobe colour transform
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+	return true
+}
+
+// stripPNG removes the EXIF, text and time chunks of a PNG and anything after its end
+func stripPNG(data []byte) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:8]...)
+
+	i := 8
+	for i+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		end := i + 12 + size
+		if size < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+
+		if !pngMetadataChunks[typ] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+
+		if typ == "IEND" {
+			return out, nil
+		}
+	}
+
+	return nil, errMalformed
+}
+
+// stripWebP removes the EXIF and XMP chunks of a WebP and clears their flags in the extended header
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+
+	// The RIFF size may be smaller than the file when data follows the container
+	riffEnd := min(len(data), 8+int(binary.LittleEndian.Uint32(data[4:])))
+
+	i := 12
+	for i+8 <= riffEnd {
+		typ := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if size < 0 || i+8+size > riffEnd {
+			return nil, errMalformed
+		}
+		end = min(end, riffEnd)
+
+		switch typ {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[i:end]...)
+			if size > 0 {
+				// Bit 3 flags EXIF and bit 2 XMP metadata
+				out[start+8] &^= 0x08 | 0x04
+			}
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// stripGIF removes the comment extensions of a GIF, the application extensions not listed in
+// gifApplicationsKept and anything after its trailer
+func stripGIF(data []byte) ([]byte, error) {
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return nil, errMalformed
+	}
+	i := 13 + gifColorTableSize(data[10])
+	if i > len(data) {
+		return nil, errMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:i]...)
+
+	for i < len(data) {
+		start := i
+		switch data[i] {
+		case 0x3B:
+			// Trailer
+			return append(out, 0x3B), nil
+		case 0x2C:
+			// Image descriptor, local colour table, LZW code size and image data
+			if i+11 > len(data) {
+				return nil, errMalformed
+			}
+			end, err := gifSubBlocksEnd(data, i+11+gifColorTableSize(data[i+9]))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[start:end]...)
+			i = end
+		case 0x21:
+			if i+2 > len(data) {
+				return nil, errMalformed
+			}
+			label := data[i+1]
+			end, err := gifSubBlocksEnd(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			if keepGIFExtension(label, data[i+2:end]) {
+				out = append(out, data[start:end]...)
+			}
+			i = end
+		default:
+			return nil, errMalformed
+		}
+	}
+
+	return nil, errMalformed
+}
+
+// keepGIFExtension reports whether an extension with the given label and sub-blocks is kept by stripGIF
+func keepGIFExtension(label byte, blocks []byte) bool {
+	switch label {
+	case 0xFE:
+		// Comment
+		return false
+	case 0xFF:
+		// The first sub-block of an application extension holds its 11 byte identifier
+		return len(blocks) >= 12 && blocks[0] == 11 && gifApplicationsKept[string(blocks[1:12])]
+	}
+	return true
+}
+
+// gifColorTableSize returns the size of the colour table flagged in a GIF descriptor's packed byte
+func gifColorTableSize(packed byte) int {
+	if packed&0x80 == 0 {
+		return 0
+	}
+	return 3 << (packed&0x07 + 1)
+}
+
+// gifSubBlocksEnd returns the offset after the sub-blocks starting at i and their terminator
+func gifSubBlocksEnd(data []byte, i int) (int, error) {
+	for i < len(data) {
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+	return 0, errMalformed
+}
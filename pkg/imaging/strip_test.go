@@ -0,0 +1,362 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// Metadata payloads the fixtures carry, which must not survive stripping
+var (
+	xmpPacket   = []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF/></x:xmpmeta>`)
+	commentText = []byte("taken at home")
+	iccProfile  = []byte("ICC_PROFILE\x00\x01\x01colour profile data")
+	trailer     = []byte("appended secondary image")
+)
+
+// exifTIFF returns a big-endian TIFF holding an orientation and a GPS position in Madrid.
+func exifTIFF(orientation uint16) []byte {
+	var b bytes.Buffer
+	w := func(v any) { binary.Write(&b, binary.BigEndian, v) }
+	entry := func(tag, typ uint16, count, value uint32) { w(tag); w(typ); w(count); w(value) }
+
+	// Header, then IFD0 at 8, the GPS IFD at 38 and the coordinates at 92 and 116
+	b.WriteString("MM")
+	w(uint16(42))
+	w(uint32(8))
+
+	w(uint16(2))
+	entry(0x0112, 3, 1, uint32(orientation)<<16)
+	entry(0x8825, 4, 1, 38)
+	w(uint32(0))
+
+	w(uint16(4))
+	entry(0x0001, 2, 2, 'N'<<24)
+	entry(0x0002, 5, 3, 92)
+	entry(0x0003, 2, 2, 'W'<<24)
+	entry(0x0004, 5, 3, 116)
+	w(uint32(0))
+
+	for _, v := range []uint32{40, 1, 25, 1, 0, 1, 3, 1, 42, 1, 0, 1} {
+		w(v)
+	}
+	return b.Bytes()
+}
+
+// testPicture returns a small picture with some variation, so encoders cannot collapse it.
+func testPicture() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 32), 128, 255})
+		}
+	}
+	return img
+}
+
+// jpegSegment returns a JPEG marker segment with the payload.
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// jpegFixture returns a JPEG with EXIF holding GPS, XMP, an ICC profile, a comment and data after its end.
+func jpegFixture(t *testing.T) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testPicture(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xD8})
+	b.Write(jpegSegment(0xE1, append([]byte("Exif\x00\x00"), exifTIFF(1)...)))
+	b.Write(jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmpPacket...)))
+	b.Write(jpegSegment(0xE2, iccProfile))
+	b.Write(jpegSegment(0xFE, commentText))
+	b.Write(encoded.Bytes()[2:])
+	b.Write(trailer)
+	return b.Bytes()
+}
+
+// pngChunk returns a PNG chunk with its CRC.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// pngFixture returns a PNG with EXIF holding GPS, XMP, text, a time, an ICC profile and data after its end.
+func pngFixture(t *testing.T) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testPicture()); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	// Ancillary chunks go between IHDR, which ends at 33, and the image data
+	var b bytes.Buffer
+	b.Write(data[:33])
+	b.Write(pngChunk("iCCP", []byte("profile\x00\x00compressed profile")))
+	b.Write(pngChunk("eXIf", exifTIFF(1)))
+	b.Write(pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmpPacket...)))
+	b.Write(pngChunk("tEXt", append([]byte("Comment\x00"), commentText...)))
+	b.Write(pngChunk("tIME", []byte{0x07, 0xE8, 2, 14, 12, 0, 0}))
+	b.Write(data[33:])
+	b.Write(trailer)
+	return b.Bytes()
+}
+
+// riffChunk returns a WebP chunk, padded to an even size.
+func riffChunk(typ string, data []byte) []byte {
+	chunk := append([]byte(typ), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webpFixture returns an extended WebP whose header flags EXIF, XMP and an ICC profile, with
+// those chunks around an odd-sized image chunk. The image data is not a valid bitstream; only
+// the container is walked.
+func webpFixture() []byte {
+	vp8x := []byte{0x20 | 0x08 | 0x04, 0, 0, 0, 15, 0, 0, 7, 0, 0}
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	body.Write(riffChunk("VP8X", vp8x))
+	body.Write(riffChunk("ICCP", iccProfile))
+	body.Write(riffChunk("VP8L", []byte("odd sized image data")[:19]))
+	body.Write(riffChunk("EXIF", exifTIFF(1)))
+	body.Write(riffChunk("XMP ", xmpPacket))
+
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(body.Len()))...)
+	return append(data, body.Bytes()...)
+}
+
+// gifFixture returns a looping GIF with a comment and an XMP application extension before its
+// first frame, and data after its trailer.
+func gifFixture(t *testing.T) []byte {
+	t.Helper()
+
+	frame := image.NewPaletted(image.Rect(0, 0, 16, 8), palette.Plan9)
+	var encoded bytes.Buffer
+	if err := gif.EncodeAll(&encoded, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+	start := 13 + gifColorTableSize(data[10])
+
+	var b bytes.Buffer
+	b.Write(data[:start])
+	b.Write([]byte{0x21, 0xFE, byte(len(commentText))})
+	b.Write(commentText)
+	b.WriteByte(0)
+	b.Write([]byte{0x21, 0xFF, 11})
+	b.WriteString("XMP DataXMP")
+	b.WriteByte(byte(len(xmpPacket)))
+	b.Write(xmpPacket)
+	b.WriteByte(0)
+	b.Write(data[start:])
+	b.Write(trailer)
+	return b.Bytes()
+}
+
+// assertNoMetadata fails the test if data still holds a metadata payload or the trailer.
+func assertNoMetadata(t *testing.T, data []byte) {
+	t.Helper()
+
+	for _, payload := range [][]byte{xmpPacket, commentText, trailer, []byte("Exif\x00\x00")} {
+		if bytes.Contains(data, payload) {
+			t.Errorf("stripped data still contains %q", payload)
+		}
+	}
+}
+
+func TestStripJPEG(t *testing.T) {
+	data := jpegFixture(t)
+	if exif := ReadExif(bytes.NewReader(data), FormatJPEG); exif.Latitude == nil {
+		t.Fatal("fixture has no readable GPS position")
+	}
+
+	stripped, err := stripJPEG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, stripped)
+	if exif := ReadExif(bytes.NewReader(stripped), FormatJPEG); exif.Latitude != nil {
+		t.Fatal("GPS position survived")
+	}
+	if !bytes.Contains(stripped, iccProfile) {
+		t.Fatal("ICC profile removed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatalf("stripped JPEG does not decode: %v", err)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	data := pngFixture(t)
+	if exif := ReadExif(bytes.NewReader(data), FormatPNG); exif.Latitude == nil {
+		t.Fatal("fixture has no readable GPS position")
+	}
+
+	stripped, err := stripPNG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, stripped)
+	for _, typ := range []string{"eXIf", "iTXt", "tEXt", "tIME"} {
+		if bytes.Contains(stripped, []byte(typ)) {
+			t.Errorf("%s chunk survived", typ)
+		}
+	}
+	if !bytes.Contains(stripped, []byte("iCCP")) {
+		t.Fatal("ICC profile removed")
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatalf("stripped PNG does not decode: %v", err)
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	data := webpFixture()
+	if exif := ReadExif(bytes.NewReader(data), FormatWebP); exif.Latitude == nil {
+		t.Fatal("fixture has no readable GPS position")
+	}
+
+	stripped, err := stripWebP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, stripped)
+	if bytes.Contains(stripped, []byte("EXIF")) || bytes.Contains(stripped, []byte("XMP ")) {
+		t.Fatal("metadata chunk survived")
+	}
+	if !bytes.Contains(stripped, iccProfile) || !bytes.Contains(stripped, []byte("VP8L")) {
+		t.Fatal("image or ICC chunk removed")
+	}
+
+	// Only the ICC flag remains in the extended header
+	if flags := stripped[20]; flags != 0x20 {
+		t.Fatalf("VP8X flags = %#x, want %#x", flags, 0x20)
+	}
+	if size := binary.LittleEndian.Uint32(stripped[4:]); int(size) != len(stripped)-8 {
+		t.Fatalf("RIFF size = %d, want %d", size, len(stripped)-8)
+	}
+}
+
+func TestStripGIF(t *testing.T) {
+	stripped, err := stripGIF(gifFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertNoMetadata(t, stripped)
+	if bytes.Contains(stripped, []byte("XMP DataXMP")) {
+		t.Fatal("XMP application extension survived")
+	}
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("stripped GIF does not decode: %v", err)
+	}
+	if len(decoded.Image) != 2 || decoded.LoopCount != 0 {
+		t.Fatalf("got %d frames looping %d, want 2 frames looping forever", len(decoded.Image), decoded.LoopCount)
+	}
+}
+
+func TestStripMalformed(t *testing.T) {
+	jpegData := jpegFixture(t)
+	pngData := pngFixture(t)
+	webpData := webpFixture()
+	gifData := gifFixture(t)
+
+	// lie returns a copy of data with a big-endian or little-endian length replaced
+	lie := func(data []byte, offset int, order binary.ByteOrder, width int, value uint32) []byte {
+		lied := bytes.Clone(data)
+		if width == 2 {
+			order.PutUint16(lied[offset:], uint16(value))
+		} else {
+			order.PutUint32(lied[offset:], value)
+		}
+		return lied
+	}
+
+	tests := []struct {
+		name  string
+		strip func([]byte) ([]byte, error)
+		data  []byte
+	}{
+		{"jpeg without start marker", stripJPEG, jpegData[2:]},
+		{"jpeg truncated in a segment", stripJPEG, jpegData[:20]},
+		{"jpeg segment longer than the file", stripJPEG, lie(jpegData, 4, binary.BigEndian, 2, 0xFFFF)},
+		{"jpeg segment shorter than its length field", stripJPEG, lie(jpegData, 4, binary.BigEndian, 2, 1)},
+		{"jpeg garbage between segments", stripJPEG, append([]byte{0xFF, 0xD8, 0x00}, jpegData[2:]...)},
+		{"png header only", stripPNG, pngData[:7]},
+		{"png without end chunk", stripPNG, pngData[:len(pngData)-len(trailer)-12]},
+		{"png chunk longer than the file", stripPNG, lie(pngData, 8, binary.BigEndian, 4, 1<<30)},
+		{"png chunk size overflowing", stripPNG, lie(pngData, 8, binary.BigEndian, 4, 0xFFFFFFF0)},
+		{"webp header only", stripWebP, webpData[:8]},
+		{"webp chunk longer than the container", stripWebP, lie(webpData, 16, binary.LittleEndian, 4, 1<<20)},
+		{"webp chunk size overflowing", stripWebP, lie(webpData, 16, binary.LittleEndian, 4, 0xFFFFFFF8)},
+		{"gif header only", stripGIF, gifData[:10]},
+		{"gif without trailer", stripGIF, gifData[:len(gifData)-len(trailer)-1]},
+		{"gif sub-block longer than the file", stripGIF, gifData[:len(gifData)-len(trailer)-4]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.strip(tt.data); !errors.Is(err, errMalformed) {
+				t.Fatalf("error = %v, want errMalformed", err)
+			}
+		})
+	}
+}
+
+func TestSanitizeReencodesRotatedPictures(t *testing.T) {
+	rotated := Orient(testPicture(), 6)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatJPEG, FormatJPEG},
+		{FormatPNG, FormatPNG},
+		{FormatWebP, FormatPNG},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			format, err := Sanitize(&out, bytes.NewReader(nil), tt.format, rotated, 6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.want {
+				t.Fatalf("format = %s, want %s", format, tt.want)
+			}
+
+			config, _, err := image.DecodeConfig(&out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != 8 || config.Height != 16 {
+				t.Fatalf("size = %dx%d, want 8x16", config.Width, config.Height)
+			}
+		})
+	}
+}
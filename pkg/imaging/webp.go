@@ -0,0 +1,48 @@
+//go:build webp
+
+package imaging
+
+/*
+#cgo LDFLAGS: -lwebp
+#include <stdlib.h>
+#include <webp/encode.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+// EncodesWebP reports whether this build can encode WebP, which needs libwebp and the webp build tag
+const EncodesWebP = true
+
+// webpQuality is the lossy quality WebP derivatives are encoded with
+const webpQuality = 80
+
+// EncodeWebP writes img as a lossy WebP using libwebp.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	rgba, ok := img.(*image.RGBA)
+	if !ok || rgba.Rect.Min != (image.Point{}) {
+		b := img.Bounds()
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	}
+	if len(rgba.Pix) == 0 {
+		return errors.New("cannot encode an empty image as WebP")
+	}
+
+	var output *C.uint8_t
+	size := C.WebPEncodeRGBA((*C.uint8_t)(unsafe.Pointer(&rgba.Pix[0])), C.int(rgba.Rect.Dx()), C.int(rgba.Rect.Dy()),
+		C.int(rgba.Stride), C.float(webpQuality), &output)
+	if size == 0 {
+		return errors.New("failed to encode WebP")
+	}
+	defer C.free(unsafe.Pointer(output))
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(output), C.int(size)))
+	return err
+}
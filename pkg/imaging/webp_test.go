@@ -0,0 +1,43 @@
+//go:build webp
+
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	// A sub-image, so the encoder has to copy it to a buffer starting at the origin
+	src := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			src.Set(x, y, color.RGBA{R: 200, G: 40, B: 40, A: 255})
+		}
+	}
+	img := src.SubImage(image.Rect(5, 5, 37, 29))
+
+	var out bytes.Buffer
+	if err := Encode(&out, img, FormatWebP); err != nil {
+		t.Fatal(err)
+	}
+	if format, err := Sniff(out.Bytes()[:SniffLen]); err != nil || format != FormatWebP {
+		t.Fatalf("Sniff = %q, %v, want webp", format, err)
+	}
+
+	decoded, err := webp.Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != image.Rect(0, 0, 32, 24) {
+		t.Fatalf("bounds = %v, want 32x24", decoded.Bounds())
+	}
+	r, g, b, _ := decoded.At(16, 12).RGBA()
+	if r>>8 < 180 || g>>8 > 70 || b>>8 > 70 {
+		t.Fatalf("center pixel = %d,%d,%d, want about 200,40,40", r>>8, g>>8, b>>8)
+	}
+}
@@ -0,0 +1,109 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890", base32-encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.code[len(tt.code)-Digits:]; code != want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, code, want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Fatalf("lowercase secret gave %s, want %s", lower, upper)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Fatal("expected an error for an invalid secret")
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		valid  bool
+	}{
+		{"two steps behind", -2, false},
+		{"previous step", -1, true},
+		{"current step", 0, true},
+		{"next step", 1, true},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok, err := Validate(rfcSecret, code, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.valid {
+				t.Fatalf("Validate = %v, want %v", ok, tt.valid)
+			}
+			if ok && step != current+tt.offset {
+				t.Fatalf("matched step %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateMalformedCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := Validate(rfcSecret, " "+code+"\n", now); !ok {
+		t.Fatal("surrounding whitespace should be ignored")
+	}
+	for _, malformed := range []string{"", code[:Digits-1], code + "0", "89005924"} {
+		if _, ok, _ := Validate(rfcSecret, malformed, now); ok {
+			t.Errorf("Validate accepted %q", malformed)
+		}
+	}
+}